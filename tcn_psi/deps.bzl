@@ -49,3 +49,16 @@ def tcn_psi_deps():
         importpath = "github.com/juliangruber/go-intersect",
         tag = "master",
     )
+
+    go_repository(
+        name = "com_github_mattn_go_sqlite3",
+        importpath = "github.com/mattn/go-sqlite3",
+        tag = "v1.14.0",
+    )
+
+    go_repository(
+        name = "org_golang_google_grpc",
+        build_file_proto_mode = "disable",
+        importpath = "google.golang.org/grpc",
+        tag = "v1.29.1",
+    )
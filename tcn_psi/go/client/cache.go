@@ -0,0 +1,253 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/openmined/tcn-psi/message"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//indexFile is the name of the file holding the metadata of the cached setup messages.
+const indexFile = "index.json"
+
+//CacheEntry describes a setup message stored in a SetupCache.
+type CacheEntry struct {
+	//Source identifies where the setup message was downloaded from, e.g. a server URL.
+	Source string `json:"source"`
+	//ID of the cached setup message, which is also the SHA-256 digest of its payload.
+	ID string `json:"id"`
+	//Size of the encoded setup message in bytes.
+	Size int `json:"size"`
+	//FetchedAt is the time the setup message was downloaded.
+	FetchedAt time.Time `json:"fetched_at"`
+	//CheckedAt is the last time the server confirmed the setup message was still current.
+	CheckedAt time.Time `json:"checked_at"`
+	//ExpiresAt is the time after which the server must be asked for a newer version.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+//FetchResult is returned by a SetupFetcher.
+type FetchResult struct {
+	//Setup is the setup message published by the server. Nil if NotModified is set.
+	Setup *message.SetupMessage
+	//NotModified is set if the server still publishes the cached setup message.
+	NotModified bool
+	//ExpiresAt is the time until which the result can be used without asking the server
+	//again.
+	ExpiresAt time.Time
+}
+
+//SetupFetcher downloads the setup message of source. If current is not nil, the fetcher
+//should only download a setup message if the server publishes another one than current.ID,
+//and report NotModified otherwise.
+type SetupFetcher func(source string, current *CacheEntry) (*FetchResult, error)
+
+//SetupCache stores downloaded setup messages on disk, keyed by their setup ID, so that a
+//setup message is only downloaded again when the server publishes a new one.
+//
+//A SetupCache is safe for concurrent use by multiple goroutines.
+type SetupCache struct {
+	dir    string
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*CacheEntry
+}
+
+//NewSetupCache opens the cache stored in dir, creating the directory if needed. Setup messages
+//downloaded more than maxAge ago are evicted. A maxAge of zero disables eviction.
+//
+//Returns an error if the directory or the index cannot be read.
+func NewSetupCache(dir string, maxAge time.Duration) (*SetupCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	cache := &SetupCache{
+		dir:     dir,
+		maxAge:  maxAge,
+		now:     time.Now,
+		entries: map[string]*CacheEntry{},
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, indexFile))
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []*CacheEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		cache.entries[entry.Source] = entry
+	}
+	return cache, nil
+}
+
+//Get returns the setup message of source, from the cache if it is still fresh, and from fetch
+//otherwise. fetch is told which setup message is cached, so that it only downloads a new one
+//when the server published it.
+//
+//Returns an error if fetch fails or returns an invalid setup message.
+func (c *SetupCache) Get(source string, fetch SetupFetcher) (*message.SetupMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	entry := c.entries[source]
+	var cached *message.SetupMessage
+	if entry != nil && !c.expired(entry, now) {
+		setup, err := c.load(entry)
+		if err == nil {
+			cached = setup
+		} else {
+			//the cached copy is corrupted, download it again.
+			c.remove(entry)
+			entry = nil
+		}
+	} else if entry != nil {
+		c.remove(entry)
+		entry = nil
+	}
+	if cached != nil && now.Before(entry.ExpiresAt) {
+		return cached, nil
+	}
+
+	result, err := fetch(source, entry)
+	if err != nil {
+		return nil, err
+	}
+	if result.NotModified {
+		if cached == nil {
+			return nil, errors.New("setup message reported as not modified, but is not cached")
+		}
+		entry.CheckedAt = now
+		entry.ExpiresAt = result.ExpiresAt
+		return cached, c.saveIndex()
+	}
+
+	setup := result.Setup
+	if setup == nil {
+		return nil, errors.New("invalid setup message")
+	}
+	if err := setup.Verify(); err != nil {
+		return nil, err
+	}
+	data, err := setup.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(c.setupPath(setup.ID.String()), data); err != nil {
+		return nil, err
+	}
+	if entry != nil && entry.ID != setup.ID.String() {
+		c.remove(entry)
+	}
+	c.entries[source] = &CacheEntry{
+		Source:    source,
+		ID:        setup.ID.String(),
+		Size:      len(data),
+		FetchedAt: now,
+		CheckedAt: now,
+		ExpiresAt: result.ExpiresAt,
+	}
+	return setup, c.saveIndex()
+}
+
+//Entries returns the metadata of the cached setup messages.
+func (c *SetupCache) Entries() []CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := []CacheEntry{}
+	for _, entry := range c.entries {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+//Evict removes the setup messages downloaded more than maxAge ago and returns how many were
+//removed.
+//
+//Returns an error if the index cannot be saved.
+func (c *SetupCache) Evict() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	evicted := 0
+	for _, entry := range c.entries {
+		if c.expired(entry, now) {
+			c.remove(entry)
+			evicted++
+		}
+	}
+	if evicted == 0 {
+		return 0, nil
+	}
+	return evicted, c.saveIndex()
+}
+
+//expired returns true if entry is older than the maximum age of the cache.
+func (c *SetupCache) expired(entry *CacheEntry, now time.Time) bool {
+	return c.maxAge > 0 && now.Sub(entry.FetchedAt) > c.maxAge
+}
+
+func (c *SetupCache) setupPath(id string) string {
+	return filepath.Join(c.dir, id+".setup")
+}
+
+//load reads the setup message of entry and verifies its integrity.
+func (c *SetupCache) load(entry *CacheEntry) (*message.SetupMessage, error) {
+	data, err := ioutil.ReadFile(c.setupPath(entry.ID))
+	if err != nil {
+		return nil, err
+	}
+	setup := &message.SetupMessage{}
+	if err := setup.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if setup.ID.String() != entry.ID {
+		return nil, errors.New("cached setup message does not match its ID")
+	}
+	return setup, nil
+}
+
+//remove deletes entry, and its setup message unless another source shares it.
+func (c *SetupCache) remove(entry *CacheEntry) {
+	delete(c.entries, entry.Source)
+	for _, other := range c.entries {
+		if other.ID == entry.ID {
+			return
+		}
+	}
+	os.Remove(c.setupPath(entry.ID))
+}
+
+func (c *SetupCache) saveIndex() error {
+	entries := []*CacheEntry{}
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(c.dir, indexFile), data)
+}
+
+//writeFile atomically replaces the content of path.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
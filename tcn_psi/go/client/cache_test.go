@@ -0,0 +1,139 @@
+package client
+
+import (
+	"errors"
+	"github.com/openmined/tcn-psi/message"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type helperFetcher struct {
+	setup     *message.SetupMessage
+	downloads int
+	checks    int
+}
+
+func (f *helperFetcher) fetch(source string, current *CacheEntry) (*FetchResult, error) {
+	f.checks++
+	expires := time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC)
+	if current != nil && current.ID == f.setup.ID.String() {
+		return &FetchResult{NotModified: true, ExpiresAt: expires}, nil
+	}
+	f.downloads++
+	return &FetchResult{Setup: f.setup, ExpiresAt: expires}, nil
+}
+
+func TestSetupCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setup_cache")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := NewSetupCache(dir, 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create cache %v", err)
+	}
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	fetcher := &helperFetcher{setup: message.NewSetupMessage("0.2.0", "first")}
+	for idx := 0; idx < 3; idx++ {
+		setup, err := cache.Get("server", fetcher.fetch)
+		if err != nil || setup.ID != fetcher.setup.ID {
+			t.Fatalf("failed to get setup %v", err)
+		}
+	}
+	if fetcher.downloads != 1 || fetcher.checks != 1 {
+		t.Errorf("fresh setup should be served from the cache, got %v downloads and %v checks", fetcher.downloads, fetcher.checks)
+	}
+
+	// once expired, the server is asked again but the setup is not downloaded twice.
+	now = now.Add(24 * time.Hour)
+	if _, err := cache.Get("server", fetcher.fetch); err != nil {
+		t.Fatalf("failed to get setup %v", err)
+	}
+	if fetcher.downloads != 1 || fetcher.checks != 2 {
+		t.Errorf("unchanged setup should not be downloaded again, got %v downloads and %v checks", fetcher.downloads, fetcher.checks)
+	}
+
+	// a new setup replaces the old one.
+	now = now.Add(24 * time.Hour)
+	old := fetcher.setup
+	fetcher.setup = message.NewSetupMessage("0.2.0", "second")
+	setup, err := cache.Get("server", fetcher.fetch)
+	if err != nil || setup.ID != fetcher.setup.ID || fetcher.downloads != 2 {
+		t.Fatalf("failed to refresh setup %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, old.ID.String()+".setup")); !os.IsNotExist(err) {
+		t.Errorf("replaced setup should be removed")
+	}
+
+	// the cache survives a restart.
+	reopened, err := NewSetupCache(dir, 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("failed to reopen cache %v", err)
+	}
+	reopened.now = func() time.Time { return now }
+	entries := reopened.Entries()
+	if len(entries) != 1 || entries[0].ID != fetcher.setup.ID.String() || entries[0].Source != "server" {
+		t.Fatalf("invalid entries %+v", entries)
+	}
+	if _, err := reopened.Get("server", fetcher.fetch); err != nil || fetcher.downloads != 2 {
+		t.Errorf("reopened cache should serve the setup, got %v", err)
+	}
+
+	// corrupted setups are detected on load and downloaded again.
+	now = now.Add(48 * time.Hour)
+	reopened.now = func() time.Time { return now }
+	if err := ioutil.WriteFile(filepath.Join(dir, fetcher.setup.ID.String()+".setup"), []byte("corrupted"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if setup, err := reopened.Get("server", fetcher.fetch); err != nil || setup.ID != fetcher.setup.ID || fetcher.downloads != 3 {
+		t.Errorf("corrupted setup should be downloaded again, got %v", err)
+	}
+
+	// old setups are evicted.
+	now = now.Add(8 * 24 * time.Hour)
+	reopened.now = func() time.Time { return now }
+	evicted, err := reopened.Evict()
+	if err != nil || evicted != 1 || len(reopened.Entries()) != 0 {
+		t.Errorf("failed to evict setup %v %v", evicted, err)
+	}
+}
+
+func TestSetupCacheFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setup_cache")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := NewSetupCache(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to create cache %v", err)
+	}
+
+	fail := func(string, *CacheEntry) (*FetchResult, error) { return nil, errors.New("offline") }
+	if _, err := cache.Get("server", fail); err == nil {
+		t.Errorf("Get should fail when the fetcher fails")
+	}
+
+	notModified := func(string, *CacheEntry) (*FetchResult, error) { return &FetchResult{NotModified: true}, nil }
+	if _, err := cache.Get("server", notModified); err == nil {
+		t.Errorf("Get should fail when an uncached setup is not modified")
+	}
+
+	tampered := message.NewSetupMessage("0.2.0", "first")
+	tampered.Payload = "second"
+	invalid := func(string, *CacheEntry) (*FetchResult, error) { return &FetchResult{Setup: tampered}, nil }
+	if _, err := cache.Get("server", invalid); err == nil {
+		t.Errorf("Get should fail when the fetched setup is invalid")
+	}
+	if len(cache.Entries()) != 0 {
+		t.Errorf("failed downloads should not be cached")
+	}
+}
@@ -2,13 +2,28 @@ package client
 
 import (
 	"errors"
+	"fmt"
 	psiclient "github.com/openmined/psi/client"
+	"github.com/openmined/tcn-psi/message"
 	"github.com/openmined/tcn-psi/tcn"
+	"sync"
 )
 
+//ErrClosed is returned by the methods of a client which has been closed.
+var ErrClosed = errors.New("client context closed")
+
 //TCNClient context for the client side of a TCN-Private Set Intersection-Cardinality protocol.
+//
+//A TCNClient is safe for concurrent use by multiple goroutines, but calls on the same context
+//are serialised. Use a Pool to run several rounds in parallel.
 type TCNClient struct {
+	mu      sync.Mutex
 	context *psiclient.PsiClient
+	closed  bool
+	//server is the name of the server this context was bound to by a Coordinator.
+	server string
+	//verifier authenticates the setup messages, if set.
+	verifier *SetupVerifier
 }
 
 //Create returns a new TCN-PSI client
@@ -23,33 +38,144 @@ func Create() (*TCNClient, error) {
 	return tcnClient, nil
 }
 
-//CreateRequest generates a request message to be sent to the server.
+//SetVerifier requires every setup message used by the client to be authenticated by
+//verifier. A nil verifier accepts unsigned setup messages.
+func (c *TCNClient) SetVerifier(verifier *SetupVerifier) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.verifier = verifier
+}
+
+//contextError returns the error reported when the context cannot be used. Must be called
+//with c.mu held.
+func (c *TCNClient) contextError() error {
+	if c.closed {
+		return ErrClosed
+	}
+	return errors.New("invalid context")
+}
+
+//checkSetup verifies that setup can be used by this context. Must be called with c.mu held.
+func (c *TCNClient) checkSetup(setup *message.SetupMessage) error {
+	if setup == nil {
+		return errors.New("invalid setup message")
+	}
+	if err := message.CheckLibraryVersion(message.KindSetup, c.context.Version(), setup.LibraryVersion); err != nil {
+		return err
+	}
+	if err := setup.Verify(); err != nil {
+		return err
+	}
+	if c.verifier != nil {
+		return c.verifier.Verify(setup)
+	}
+	return nil
+}
+
+//CreateRequest generates a request message for the server's setup message.
+//
+//Returns an error if the context is invalid, if the setup message is invalid or if the
+//encryption fails.
+func (c *TCNClient) CreateRequest(setup *message.SetupMessage, contacts []tcn.TemporaryContactNumber) (*message.Request, error) {
+	return c.CreatePaddedRequest(setup, contacts, NoPadding)
+}
+
+//CreatePaddedRequest generates a request message for the server's setup message, padded
+//with random dummy elements up to the size chosen by padding. The dummy elements never match
+//a reported TCN, so the intersection size is not affected.
 //
-//Returns an error if the context is invalid or if the encryption fails.
-func (c *TCNClient) CreateRequest(contacts []tcn.TemporaryContactNumber) (string, error) {
+//Returns an error if the context is invalid, if the setup message is invalid, if the padding
+//fails or if the encryption fails.
+func (c *TCNClient) CreatePaddedRequest(setup *message.SetupMessage, contacts []tcn.TemporaryContactNumber, padding Padding) (*message.Request, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.context == nil {
-		return "", errors.New("invalid context")
+		return nil, c.contextError()
+	}
+	if err := c.checkSetup(setup); err != nil {
+		return nil, err
 	}
 
 	psiInput := []string{}
 	for idx := range contacts {
 		psiInput = append(psiInput, contacts[idx].ToString())
 	}
-	return c.context.CreateRequest(psiInput)
+
+	size, err := padding(len(psiInput))
+	if err != nil {
+		return nil, err
+	}
+	psiInput, err = padInput(psiInput, size)
+	if err != nil {
+		return nil, err
+	}
+	request, err := c.context.CreateRequest(psiInput)
+	if err != nil {
+		return nil, err
+	}
+	return message.NewRequest(setup, c.context.Version(), request), nil
 }
 
 //GetIntersectionSize processes the server's response and returns the PSI cardinality.
 //
-//Returns an error if the context is invalid,  if any input messages are malformed or if decryption fails.
-func (c *TCNClient) GetIntersectionSize(serverSetup, serverResponse string) (int64, error) {
+//Returns an error if the context is invalid, if the setup message is rejected by the verifier,
+//if the response does not belong to the setup message, if any input messages are malformed or
+//if decryption fails.
+func (c *TCNClient) GetIntersectionSize(serverSetup *message.SetupMessage, serverResponse *message.Response) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.context == nil {
-		return 0, errors.New("invalid context")
+		return 0, c.contextError()
+	}
+	if err := c.checkSetup(serverSetup); err != nil {
+		return 0, err
+	}
+	if serverResponse == nil {
+		return 0, errors.New("invalid response")
+	}
+	if err := serverResponse.CheckSetup(serverSetup); err != nil {
+		return 0, err
+	}
+	if err := message.CheckLibraryVersion(message.KindResponse, c.context.Version(), serverResponse.LibraryVersion); err != nil {
+		return 0, err
 	}
 
-	return c.context.GetIntersectionSize(serverSetup, serverResponse)
+	return c.context.GetIntersectionSize(serverSetup.Payload, serverResponse.Payload)
 }
 
-//Version of the library.
+//bind ties the context to a single server, so that the same client key is never used to
+//query two different servers.
+func (c *TCNClient) bind(server string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.server != "" && c.server != server {
+		return fmt.Errorf("client context already used for server %v", c.server)
+	}
+	c.server = server
+	return nil
+}
+
+//Version of the library. Returns an empty string if the context is invalid.
 func (c *TCNClient) Version() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.context == nil {
+		return ""
+	}
 	return c.context.Version()
 }
+
+//Close releases the native context, including the private key it holds. Any further call
+//on the client returns ErrClosed.
+//
+//Close is safe to call more than once.
+func (c *TCNClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.context != nil {
+		c.context.Destroy()
+		c.context = nil
+	}
+	c.closed = true
+	return nil
+}
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"github.com/openmined/tcn-psi/message"
 	"github.com/openmined/tcn-psi/server"
 	"github.com/openmined/tcn-psi/tcn"
 	"regexp"
+	"sync"
 	"testing"
 )
 
@@ -57,25 +59,79 @@ func helperGetReports(cnt int) ([]*tcn.SignedReport, []tcn.TemporaryContactNumbe
 	}
 	return reports, tcns, nil
 }
+
+func helperGetSetup() (*message.SetupMessage, error) {
+	s, err := server.CreateWithNewKey()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	serverItems, _, err := helperGetReports(2)
+	if err != nil {
+		return nil, err
+	}
+	return s.CreateSetupMessage(0.001, 100, serverItems)
+}
+
 func TestClientFailure(t *testing.T) {
 	c := &TCNClient{}
 	_, clientItems, err := helperGetReports(10)
 	if err != nil {
 		t.Error(err.Error())
 	}
-	_, err = c.CreateRequest(clientItems)
+	setup, err := helperGetSetup()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = c.CreateRequest(setup, clientItems)
 	if err == nil {
 		t.Errorf("CreateRequest with an invalid context should fail")
 	}
-	_, err = c.GetIntersectionSize("dummy1", "dummy2")
+	dummySetup := message.NewSetupMessage(setup.LibraryVersion, "dummy1")
+	dummyResponse := &message.Response{LibraryVersion: setup.LibraryVersion, SetupID: dummySetup.ID, Payload: "dummy2"}
+	_, err = c.GetIntersectionSize(dummySetup, dummyResponse)
 	if err == nil {
 		t.Errorf("GetIntersectionSize with an invalid context should fail")
 	}
 	c, _ = Create()
-	_, err = c.GetIntersectionSize("dummy1", "dummy2")
+	_, err = c.GetIntersectionSize(dummySetup, dummyResponse)
 	if err == nil {
 		t.Errorf("GetIntersectionSize with invalid input should fail")
 	}
+	_, err = c.CreateRequest(nil, clientItems)
+	if err == nil {
+		t.Errorf("CreateRequest without a setup message should fail")
+	}
+
+	request, err := c.CreateRequest(setup, clientItems)
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	if request.SetupID != setup.ID || request.LibraryVersion != c.Version() {
+		t.Errorf("invalid request header %v %v", request.SetupID, request.LibraryVersion)
+	}
+
+	tampered := *setup
+	tampered.Payload += " "
+	if _, err = c.CreateRequest(&tampered, clientItems); err == nil {
+		t.Errorf("CreateRequest with a tampered setup message should fail")
+	}
+	oldSetup := *setup
+	oldSetup.LibraryVersion = "0.0.1"
+	if _, err = c.CreateRequest(&oldSetup, clientItems); err == nil {
+		t.Errorf("CreateRequest with a setup message from another library version should fail")
+	}
+	otherSetup, err := helperGetSetup()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	response := &message.Response{LibraryVersion: c.Version(), SetupID: otherSetup.ID, Payload: "dummy"}
+	if _, err = c.GetIntersectionSize(setup, response); err == nil {
+		t.Errorf("GetIntersectionSize with a response for another setup message should fail")
+	}
+	if _, err = c.GetIntersectionSize(setup, nil); err == nil {
+		t.Errorf("GetIntersectionSize without a response should fail")
+	}
 }
 
 func TestClientServer(t *testing.T) {
@@ -99,7 +155,7 @@ func TestClientServer(t *testing.T) {
 	if err != nil {
 		t.Errorf("failed to create setup msg %v", err)
 	}
-	request, err := client.CreateRequest(clientItems)
+	request, err := client.CreateRequest(setup, clientItems)
 	if err != nil {
 		t.Errorf("failed to create request %v", err)
 	}
@@ -121,10 +177,15 @@ func TestClientServer(t *testing.T) {
 	}
 }
 
-var result string
+var result *message.Request
 
 func benchmarkClientCreateRequest(cnt int, b *testing.B) {
+	setup, err := helperGetSetup()
+	if err != nil {
+		b.Fatal(err.Error())
+	}
 	b.ReportAllocs()
+	b.ResetTimer()
 	total := 0
 	for n := 0; n < b.N; n++ {
 		client, err := Create()
@@ -135,7 +196,7 @@ func benchmarkClientCreateRequest(cnt int, b *testing.B) {
 		if err != nil {
 			b.Error(err.Error())
 		}
-		request, err := client.CreateRequest(inputs)
+		request, err := client.CreateRequest(setup, inputs)
 		if err != nil {
 			b.Errorf("failed to generate request")
 		}
@@ -144,7 +205,7 @@ func benchmarkClientCreateRequest(cnt int, b *testing.B) {
 		result = request
 
 		total += cnt
-		b.ReportMetric(float64(len(request)), "RequestSize")
+		b.ReportMetric(float64(len(request.Payload)), "RequestSize")
 
 	}
 	b.ReportMetric(float64(total), "ElementsProcessed")
@@ -181,7 +242,7 @@ func benchmarkClientGetIntersectionSize(cnt int, b *testing.B) {
 		if err != nil {
 			b.Errorf("failed to create setup msg %v", err)
 		}
-		request, err := client.CreateRequest(clientItems)
+		request, err := client.CreateRequest(setup, clientItems)
 		if err != nil {
 			b.Errorf("failed to create request %v", err)
 		}
@@ -207,3 +268,55 @@ func BenchmarkClientGetIntersectionSize1000(b *testing.B) { benchmarkClientGetIn
 func BenchmarkClientGetIntersectionSize10000(b *testing.B) {
 	benchmarkClientGetIntersectionSize(10000, b)
 }
+
+func TestClientClose(t *testing.T) {
+	c, err := Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	_, clientItems, err := helperGetReports(10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup, err := helperGetSetup()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// every call either succeeds or fails cleanly with ErrClosed.
+			if _, err := c.CreateRequest(setup, clientItems); err != nil && err != ErrClosed {
+				t.Errorf("unexpected error %v", err)
+			}
+			c.Version()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := c.Close(); err != nil {
+			t.Errorf("Close failed %v", err)
+		}
+	}()
+	wg.Wait()
+
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close failed %v", err)
+	}
+	if _, err := c.CreateRequest(setup, clientItems); err != ErrClosed {
+		t.Errorf("CreateRequest on a closed client should fail with ErrClosed, got %v", err)
+	}
+	if _, err := c.GetIntersectionSize(setup, &message.Response{}); err != ErrClosed {
+		t.Errorf("GetIntersectionSize on a closed client should fail with ErrClosed, got %v", err)
+	}
+	if c.Version() != "" {
+		t.Errorf("Version on a closed client should be empty")
+	}
+	if (&TCNClient{}).Version() != "" {
+		t.Errorf("Version with an invalid context should be empty")
+	}
+}
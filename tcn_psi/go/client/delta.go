@@ -0,0 +1,89 @@
+package client
+
+import (
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/tcn"
+	"sort"
+	"sync"
+)
+
+//DeltaTracker remembers which delta setup messages the client already checked, so that every
+//delta published by the server is queried once.
+//
+//Since deltas are disjoint, the cardinalities returned for the deltas add up to the cardinality
+//over all the reports they cover. The contacts passed to Query must include every contact which
+//could appear in the new deltas.
+//
+//A DeltaTracker is safe for concurrent use by multiple goroutines.
+type DeltaTracker struct {
+	mu      sync.Mutex
+	checked map[message.SetupID]bool
+}
+
+//NewDeltaTracker returns a tracker which already checked the deltas in checked, e.g. as
+//restored from a previous run.
+func NewDeltaTracker(checked ...message.SetupID) *DeltaTracker {
+	tracker := &DeltaTracker{checked: map[message.SetupID]bool{}}
+	for _, id := range checked {
+		tracker.checked[id] = true
+	}
+	return tracker
+}
+
+//Pending returns the deltas which were not checked yet, oldest first.
+func (d *DeltaTracker) Pending(deltas []*message.SetupMessage) []*message.SetupMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending := []*message.SetupMessage{}
+	for _, delta := range deltas {
+		if !d.checked[delta.ID] {
+			pending = append(pending, delta)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].Start.Before(pending[j].Start)
+	})
+	return pending
+}
+
+//Checked returns the IDs of the deltas which were already checked.
+func (d *DeltaTracker) Checked() []message.SetupID {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ids := []message.SetupID{}
+	for id := range d.checked {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+//Query runs a PSI round with client against every delta not checked yet and returns the sum
+//of their cardinalities. A delta is marked as checked only once its round succeeded, so a
+//failed query can be retried.
+//
+//Returns the sum over the deltas checked so far and the first error encountered.
+func (d *DeltaTracker) Query(client *TCNClient, deltas []*message.SetupMessage, contacts []tcn.TemporaryContactNumber, padding Padding, process func(request *message.Request) (*message.Response, error)) (int64, error) {
+	var total int64
+	for _, delta := range d.Pending(deltas) {
+		request, err := client.CreatePaddedRequest(delta, contacts, padding)
+		if err != nil {
+			return total, err
+		}
+		response, err := process(request)
+		if err != nil {
+			return total, err
+		}
+		size, err := client.GetIntersectionSize(delta, response)
+		if err != nil {
+			return total, err
+		}
+		total += size
+
+		d.mu.Lock()
+		d.checked[delta.ID] = true
+		d.mu.Unlock()
+	}
+	return total, nil
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"github.com/openmined/tcn-psi/server"
+	"testing"
+	"time"
+)
+
+func TestDeltaTracker(t *testing.T) {
+	s, err := server.CreateWithNewKey()
+	if err != nil || s == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	client, err := Create()
+	if err != nil || client == nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+
+	serverItems, clientItems, err := helperGetReports(300)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	publisher := server.NewDeltaPublisher(s, 0.001, int64(len(clientItems)))
+	tracker := NewDeltaTracker()
+
+	// the reports are published over three days, the client checks every day.
+	var total int64
+	third := len(serverItems) / 3
+	for day := 0; day < 3; day++ {
+		end := (day + 1) * third
+		if day == 2 {
+			end = len(serverItems)
+		}
+		publisher.Add(serverItems[day*third : end]...)
+		if _, err := publisher.Publish(); err != nil {
+			t.Fatalf("failed to publish delta %v", err)
+		}
+
+		deltas := publisher.Deltas(time.Time{})
+		if len(tracker.Pending(deltas)) != 1 {
+			t.Fatalf("expected a single new delta, got %v", len(tracker.Pending(deltas)))
+		}
+		size, err := tracker.Query(client, deltas, clientItems, NoPadding, s.ProcessRequest)
+		if err != nil {
+			t.Fatalf("failed to query deltas %v", err)
+		}
+		total += size
+	}
+
+	expected := len(clientItems) / 2
+	if int(total) < expected || float64(total) > float64(expected)*1.1 {
+		t.Errorf("Invalid intersection. expected about %v. got %v", expected, total)
+	}
+
+	// checked deltas are not queried again, even by a restored tracker.
+	restored := NewDeltaTracker(tracker.Checked()...)
+	size, err := restored.Query(client, publisher.Deltas(time.Time{}), clientItems, NoPadding, s.ProcessRequest)
+	if err != nil || size != 0 {
+		t.Errorf("checked deltas should be skipped, got %v %v", size, err)
+	}
+}
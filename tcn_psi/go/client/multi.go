@@ -0,0 +1,152 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/tcn"
+	"sync"
+)
+
+//ServerEndpoint describes a TCN-PSI server, e.g. the one run by a health authority, that the
+//client can query.
+type ServerEndpoint struct {
+	//Name uniquely identifies the server.
+	Name string
+	//Setup fetches the current setup message of the server.
+	Setup func() (*message.SetupMessage, error)
+	//Process sends a request to the server and returns its response.
+	Process func(request *message.Request) (*message.Response, error)
+}
+
+//ServerResult is the outcome of the PSI round against a single server.
+type ServerResult struct {
+	Name             string
+	IntersectionSize int64
+	Err              error
+}
+
+//MultiResult is the outcome of querying a set of servers.
+type MultiResult struct {
+	//Servers holds one result per endpoint, in the order the endpoints were given.
+	Servers []ServerResult
+	//IntersectionSize is the sum of the cardinalities returned by the servers which answered
+	//successfully. A TCN reported to several servers is counted once per server.
+	IntersectionSize int64
+	//Failed is the number of servers which could not be queried.
+	Failed int
+}
+
+//Coordinator runs the PSI protocol against several servers in parallel, using an independent
+//client context, and therefore an independent key, for every server. The client contexts are
+//closed once the query completes.
+type Coordinator struct {
+	//Padding is applied to every request. Defaults to NoPadding.
+	Padding Padding
+	//NewClient creates the client context used for a single server. Defaults to Create.
+	NewClient func() (*TCNClient, error)
+}
+
+//NewCoordinator returns a coordinator using fresh client contexts and no padding.
+func NewCoordinator() *Coordinator {
+	return &Coordinator{
+		Padding:   NoPadding,
+		NewClient: Create,
+	}
+}
+
+//Query runs a PSI round against every endpoint and returns the per-server and combined
+//results. Failures of individual servers are reported in their ServerResult.
+//
+//Returns an error if the endpoints are invalid or if a client context would be reused across
+//servers.
+func (co *Coordinator) Query(endpoints []ServerEndpoint, contacts []tcn.TemporaryContactNumber) (*MultiResult, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoints")
+	}
+	padding := co.Padding
+	if padding == nil {
+		padding = NoPadding
+	}
+	newClient := co.NewClient
+	if newClient == nil {
+		newClient = Create
+	}
+
+	names := map[string]bool{}
+	for _, endpoint := range endpoints {
+		if endpoint.Name == "" || endpoint.Setup == nil || endpoint.Process == nil {
+			return nil, errors.New("invalid endpoint")
+		}
+		if names[endpoint.Name] {
+			return nil, fmt.Errorf("duplicate endpoint %v", endpoint.Name)
+		}
+		names[endpoint.Name] = true
+	}
+
+	result := &MultiResult{Servers: make([]ServerResult, len(endpoints))}
+	clients := make([]*TCNClient, len(endpoints))
+	for idx, endpoint := range endpoints {
+		result.Servers[idx].Name = endpoint.Name
+		client, err := newClient()
+		if err != nil {
+			result.Servers[idx].Err = err
+			continue
+		}
+		clients[idx] = client
+		if err := client.bind(endpoint.Name); err != nil {
+			closeClients(clients)
+			return nil, err
+		}
+	}
+	defer closeClients(clients)
+
+	var wg sync.WaitGroup
+	for idx := range endpoints {
+		if clients[idx] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			size, err := queryEndpoint(clients[idx], endpoints[idx], contacts, padding)
+			result.Servers[idx].IntersectionSize = size
+			result.Servers[idx].Err = err
+		}(idx)
+	}
+	wg.Wait()
+
+	for idx := range result.Servers {
+		if result.Servers[idx].Err != nil {
+			result.Failed++
+			continue
+		}
+		result.IntersectionSize += result.Servers[idx].IntersectionSize
+	}
+	return result, nil
+}
+
+//closeClients releases the client contexts created by the coordinator.
+func closeClients(clients []*TCNClient) {
+	for _, client := range clients {
+		if client != nil {
+			client.Close()
+		}
+	}
+}
+
+func queryEndpoint(client *TCNClient, endpoint ServerEndpoint, contacts []tcn.TemporaryContactNumber, padding Padding) (int64, error) {
+	setup, err := endpoint.Setup()
+	if err != nil {
+		return 0, err
+	}
+	request, err := client.CreatePaddedRequest(setup, contacts, padding)
+	if err != nil {
+		return 0, err
+	}
+	response, err := endpoint.Process(request)
+	if err != nil {
+		return 0, err
+	}
+	return client.GetIntersectionSize(setup, response)
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"errors"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/tcn"
+	"testing"
+)
+
+func helperGetEndpoint(t *testing.T, name string, reports []*tcn.SignedReport, inputCount int) ServerEndpoint {
+	s, err := server.CreateWithNewKey()
+	if err != nil || s == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	setup, err := s.CreateSetupMessage(0.001, int64(inputCount), reports)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	return ServerEndpoint{
+		Name:    name,
+		Setup:   func() (*message.SetupMessage, error) { return setup, nil },
+		Process: s.ProcessRequest,
+	}
+}
+
+func TestCoordinatorQuery(t *testing.T) {
+	serverItems, clientItems, err := helperGetReports(200)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// every server gets a disjoint half of the reports.
+	half := len(serverItems) / 2
+	endpoints := []ServerEndpoint{
+		helperGetEndpoint(t, "north", serverItems[:half], len(clientItems)),
+		helperGetEndpoint(t, "south", serverItems[half:], len(clientItems)),
+		{
+			Name:    "offline",
+			Setup:   func() (*message.SetupMessage, error) { return nil, errors.New("unreachable") },
+			Process: func(*message.Request) (*message.Response, error) { return nil, errors.New("unreachable") },
+		},
+	}
+
+	co := NewCoordinator()
+	co.Padding = PadToPowerOfTwo(1)
+	result, err := co.Query(endpoints, clientItems)
+	if err != nil {
+		t.Fatalf("Query failed %v", err)
+	}
+	if len(result.Servers) != 3 || result.Failed != 1 {
+		t.Fatalf("unexpected results %+v", result)
+	}
+	for idx, name := range []string{"north", "south", "offline"} {
+		if result.Servers[idx].Name != name {
+			t.Errorf("unexpected result order %v != %v", result.Servers[idx].Name, name)
+		}
+	}
+	if result.Servers[2].Err == nil {
+		t.Errorf("offline server should fail")
+	}
+	if result.Servers[0].IntersectionSize+result.Servers[1].IntersectionSize != result.IntersectionSize {
+		t.Errorf("invalid combined result %+v", result)
+	}
+
+	expected := len(clientItems) / 2
+	if int(result.IntersectionSize) < expected || float64(result.IntersectionSize) > float64(expected)*1.1 {
+		t.Errorf("Invalid intersection. expected about %v. got %v", expected, result.IntersectionSize)
+	}
+}
+
+func TestCoordinatorFailure(t *testing.T) {
+	_, clientItems, err := helperGetReports(10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	co := NewCoordinator()
+	if _, err := co.Query(nil, clientItems); err == nil {
+		t.Errorf("Query without endpoints should fail")
+	}
+
+	endpoint := helperGetEndpoint(t, "north", nil, len(clientItems))
+	if _, err := co.Query([]ServerEndpoint{endpoint, endpoint}, clientItems); err == nil {
+		t.Errorf("Query with duplicate endpoints should fail")
+	}
+
+	shared, err := Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	co.NewClient = func() (*TCNClient, error) { return shared, nil }
+	other := helperGetEndpoint(t, "south", nil, len(clientItems))
+	if _, err := co.Query([]ServerEndpoint{endpoint, other}, clientItems); err == nil {
+		t.Errorf("Query should refuse to reuse a client across servers")
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+)
+
+//dummyLength is the size in bytes of a padding element. Real TCNs are always 16 bytes long,
+//so a padding element can never be equal to a reported TCN and never counts towards the
+//intersection size.
+const dummyLength = 32
+
+//Padding computes the number of elements a request must contain in order to hide the exact
+//number of contacts observed by the client.
+//
+//Returns an error if count cannot be padded under this policy.
+type Padding func(count int) (int, error)
+
+//NoPadding sends exactly one element per contact.
+func NoPadding(count int) (int, error) {
+	return count, nil
+}
+
+//PadToPowerOfTwo rounds the request size up to the next power of two, and to at least min
+//elements.
+func PadToPowerOfTwo(min int) Padding {
+	return func(count int) (int, error) {
+		size := 1
+		for size < count || size < min {
+			size <<= 1
+		}
+		return size, nil
+	}
+}
+
+//PadToMultiple rounds the request size up to the next multiple of step.
+func PadToMultiple(step int) Padding {
+	return func(count int) (int, error) {
+		if step <= 0 {
+			return 0, errors.New("invalid padding step")
+		}
+		if count == 0 {
+			return step, nil
+		}
+		return ((count + step - 1) / step) * step, nil
+	}
+}
+
+//PadToFixed pads every request to exactly size elements.
+//
+//Returns an error if the client observed more than size contacts.
+func PadToFixed(size int) Padding {
+	return func(count int) (int, error) {
+		if count > size {
+			return 0, errors.New("too many contacts for the fixed padding size")
+		}
+		return size, nil
+	}
+}
+
+//padInput appends random dummy elements to input until it holds size elements, then
+//shuffles it so that the padding cannot be told apart by its position.
+func padInput(input []string, size int) ([]string, error) {
+	if len(input) >= size {
+		return input, nil
+	}
+	for len(input) < size {
+		dummy := make([]byte, dummyLength)
+		if _, err := rand.Read(dummy); err != nil {
+			return nil, err
+		}
+		input = append(input, string(dummy))
+	}
+
+	for idx := len(input) - 1; idx > 0; idx-- {
+		jdx, err := rand.Int(rand.Reader, big.NewInt(int64(idx+1)))
+		if err != nil {
+			return nil, err
+		}
+		input[idx], input[jdx.Int64()] = input[jdx.Int64()], input[idx]
+	}
+	return input, nil
+}
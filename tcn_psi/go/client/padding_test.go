@@ -0,0 +1,83 @@
+package client
+
+import (
+	"github.com/openmined/tcn-psi/server"
+	"testing"
+)
+
+func TestPaddingSizes(t *testing.T) {
+	cases := []struct {
+		padding  Padding
+		count    int
+		expected int
+	}{
+		{NoPadding, 0, 0},
+		{NoPadding, 13, 13},
+		{PadToPowerOfTwo(1), 0, 1},
+		{PadToPowerOfTwo(1), 5, 8},
+		{PadToPowerOfTwo(1), 64, 64},
+		{PadToPowerOfTwo(256), 13, 256},
+		{PadToPowerOfTwo(256), 300, 512},
+		{PadToMultiple(100), 0, 100},
+		{PadToMultiple(100), 100, 100},
+		{PadToMultiple(100), 101, 200},
+		{PadToFixed(1000), 10, 1000},
+	}
+	for _, tc := range cases {
+		size, err := tc.padding(tc.count)
+		if err != nil {
+			t.Errorf("padding %v failed %v", tc.count, err)
+		}
+		if size != tc.expected {
+			t.Errorf("invalid padding for %v. expected %v got %v", tc.count, tc.expected, size)
+		}
+	}
+
+	if _, err := PadToFixed(10)(11); err == nil {
+		t.Errorf("PadToFixed should fail when the contacts don't fit")
+	}
+	if _, err := PadToMultiple(0)(11); err == nil {
+		t.Errorf("PadToMultiple should fail with an invalid step")
+	}
+}
+
+func TestPaddedClientServer(t *testing.T) {
+	client, err := Create()
+	if err != nil || client == nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	server, err := server.CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+
+	serverItems, clientItems, err := helperGetReports(100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup, err := server.CreateSetupMessage(0.001, int64(len(clientItems)), serverItems)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+
+	for _, padding := range []Padding{NoPadding, PadToPowerOfTwo(1), PadToFixed(5000)} {
+		request, err := client.CreatePaddedRequest(setup, clientItems, padding)
+		if err != nil {
+			t.Fatalf("failed to create request %v", err)
+		}
+		serverResp, err := server.ProcessRequest(request)
+		if err != nil {
+			t.Fatalf("failed to process request %v", err)
+		}
+		intersectionCnt, err := client.GetIntersectionSize(setup, serverResp)
+		if err != nil {
+			t.Fatalf("failed to compute intersection %v", err)
+		}
+		if int(intersectionCnt) < len(clientItems)/2 {
+			t.Errorf("Invalid intersection. expected lower bound %v. got %v", len(clientItems)/2, intersectionCnt)
+		}
+		if float64(intersectionCnt) > float64(len(clientItems)/2)*1.1 {
+			t.Errorf("Invalid intersection. expected upper bound %v. got %v", float64(len(clientItems)/2)*1.1, intersectionCnt)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/tcn"
+	"runtime"
+	"sync"
+)
+
+//Pool dispatches PSI rounds across several client contexts, so that they run in parallel.
+//Every round uses a single context from start to end, since a response can only be decrypted
+//by the context which created the request.
+//
+//A Pool is safe for concurrent use by multiple goroutines.
+type Pool struct {
+	clients []*TCNClient
+	free    chan *TCNClient
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+//NewPool creates a pool of size client contexts, each with its own key. If size is not
+//positive, one context per CPU is created.
+//
+//Returns an error if any crypto operations fail.
+func NewPool(size int) (*Pool, error) {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	pool := &Pool{
+		free: make(chan *TCNClient, size),
+	}
+	for idx := 0; idx < size; idx++ {
+		client, err := Create()
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.clients = append(pool.clients, client)
+		pool.free <- client
+	}
+	return pool, nil
+}
+
+//Size returns the number of client contexts in the pool.
+func (p *Pool) Size() int {
+	return len(p.clients)
+}
+
+//Do runs fn with the first available client context, which is reserved for fn until it
+//returns.
+//
+//Returns ErrClosed if the pool is closed, or the error returned by fn.
+func (p *Pool) Do(fn func(*TCNClient) error) error {
+	p.mu.RLock()
+	closed := p.closed
+	p.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	client := <-p.free
+	defer func() { p.free <- client }()
+	return fn(client)
+}
+
+//Query runs a full PSI round on one of the contexts of the pool: it creates a request for
+//contacts, hands it to process and returns the intersection size computed from the response.
+//
+//Returns an error if the pool is closed or if any step of the round fails.
+func (p *Pool) Query(setup *message.SetupMessage, contacts []tcn.TemporaryContactNumber, padding Padding, process func(request *message.Request) (*message.Response, error)) (int64, error) {
+	var size int64
+	err := p.Do(func(client *TCNClient) error {
+		request, err := client.CreatePaddedRequest(setup, contacts, padding)
+		if err != nil {
+			return err
+		}
+		response, err := process(request)
+		if err != nil {
+			return err
+		}
+		size, err = client.GetIntersectionSize(setup, response)
+		return err
+	})
+	return size, err
+}
+
+//Close releases every context of the pool. Rounds in flight complete, later ones fail with
+//ErrClosed.
+//
+//Close is safe to call more than once.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	var result error
+	for _, client := range p.clients {
+		if err := client.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
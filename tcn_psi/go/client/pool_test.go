@@ -0,0 +1,57 @@
+package client
+
+import (
+	"github.com/openmined/tcn-psi/server"
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	pool, err := NewPool(3)
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client pool %v", err)
+	}
+	if pool.Size() != 3 {
+		t.Errorf("invalid pool size %v", pool.Size())
+	}
+
+	server, err := server.CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	serverItems, clientItems, err := helperGetReports(100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup, err := server.CreateSetupMessage(0.001, int64(len(clientItems)), serverItems)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 12; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			intersectionCnt, err := pool.Query(setup, clientItems, PadToPowerOfTwo(1), server.ProcessRequest)
+			if err != nil {
+				t.Errorf("Query failed %v", err)
+				return
+			}
+			if int(intersectionCnt) < len(clientItems)/2 || float64(intersectionCnt) > float64(len(clientItems)/2)*1.1 {
+				t.Errorf("Invalid intersection. expected about %v. got %v", len(clientItems)/2, intersectionCnt)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := pool.Close(); err != nil {
+		t.Errorf("Close failed %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Errorf("second Close failed %v", err)
+	}
+	if _, err := pool.Query(setup, clientItems, NoPadding, server.ProcessRequest); err != ErrClosed {
+		t.Errorf("Query on a closed pool should fail with ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/tcn"
+	"sort"
+	"time"
+)
+
+//ShardQuery is a shard to query, along with the contacts which may appear in it.
+type ShardQuery struct {
+	Setup    *message.SetupMessage
+	Contacts []tcn.TemporaryContactNumber
+}
+
+//SelectShards returns the shards which may contain the contacts in encounters, which maps the
+//start of a UTC day to the contacts observed during that day.
+//
+//A report can only reveal TCNs broadcast before it was ingested, so a contact observed on a
+//given day is only looked up in the shards ending after the start of that day. Shards without
+//any such contact are not queried.
+func SelectShards(shards []*message.SetupMessage, encounters map[time.Time][]tcn.TemporaryContactNumber) []ShardQuery {
+	days := []time.Time{}
+	for day := range encounters {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Before(days[j])
+	})
+
+	queries := []ShardQuery{}
+	for _, shard := range shards {
+		contacts := []tcn.TemporaryContactNumber{}
+		for _, day := range days {
+			if !shard.End.IsZero() && !day.Before(shard.End) {
+				break
+			}
+			contacts = append(contacts, encounters[day]...)
+		}
+		if len(contacts) > 0 {
+			queries = append(queries, ShardQuery{Setup: shard, Contacts: contacts})
+		}
+	}
+	return queries
+}
+
+//QueryShards runs a PSI round with client for every query and returns the sum of the
+//cardinalities. Since shards hold disjoint sets of reports, the sum is the cardinality over
+//all the queried shards, up to the false positives of the filters.
+//
+//Returns an error if any round fails.
+func QueryShards(client *TCNClient, queries []ShardQuery, padding Padding, process func(request *message.Request) (*message.Response, error)) (int64, error) {
+	var total int64
+	for _, query := range queries {
+		request, err := client.CreatePaddedRequest(query.Setup, query.Contacts, padding)
+		if err != nil {
+			return 0, err
+		}
+		response, err := process(request)
+		if err != nil {
+			return 0, err
+		}
+		size, err := client.GetIntersectionSize(query.Setup, response)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/tcn"
+	"testing"
+	"time"
+)
+
+func TestSelectShards(t *testing.T) {
+	day := 24 * time.Hour
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	shards := []*message.SetupMessage{}
+	for idx := 0; idx < 3; idx++ {
+		shard := message.NewSetupMessage("0.2.0", string(rune('a'+idx)))
+		shard.Start = start.Add(time.Duration(idx) * day)
+		shard.End = shard.Start.Add(day)
+		shards = append(shards, shard)
+	}
+
+	_, contacts, err := helperGetReports(1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	encounters := map[time.Time][]tcn.TemporaryContactNumber{
+		start.Add(day):     contacts[:3],
+		start.Add(2 * day): contacts[3:5],
+	}
+	queries := SelectShards(shards, encounters)
+	if len(queries) != 2 {
+		t.Fatalf("invalid number of shards %v", len(queries))
+	}
+	if queries[0].Setup.ID != shards[1].ID || len(queries[0].Contacts) != 3 {
+		t.Errorf("invalid first shard query %v", len(queries[0].Contacts))
+	}
+	if queries[1].Setup.ID != shards[2].ID || len(queries[1].Contacts) != 5 {
+		t.Errorf("invalid second shard query %v", len(queries[1].Contacts))
+	}
+
+	if len(SelectShards(shards, nil)) != 0 {
+		t.Errorf("no shard should be selected without encounters")
+	}
+}
+
+func TestQueryShards(t *testing.T) {
+	s, err := server.CreateWithNewKey()
+	if err != nil || s == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	client, err := Create()
+	if err != nil || client == nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+
+	serverItems, clientItems, err := helperGetReports(200)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	day := 24 * time.Hour
+	today := time.Now().UTC().Truncate(day)
+	shards := server.NewDayShards(s, 0.001, int64(len(clientItems)), 14)
+	half := len(serverItems) / 2
+	if err := shards.AddAt(today.Add(-day), serverItems[:half]...); err != nil {
+		t.Fatalf("failed to add reports %v", err)
+	}
+	if err := shards.AddAt(today, serverItems[half:]...); err != nil {
+		t.Fatalf("failed to add reports %v", err)
+	}
+	manifest, err := shards.Manifest()
+	if err != nil {
+		t.Fatalf("failed to create manifest %v", err)
+	}
+	setups := []*message.SetupMessage{}
+	for _, info := range manifest {
+		setup, err := shards.Shard(info.ID)
+		if err != nil {
+			t.Fatalf("failed to get shard %v", err)
+		}
+		setups = append(setups, setup)
+	}
+
+	queries := SelectShards(setups, map[time.Time][]tcn.TemporaryContactNumber{today.Add(-2 * day): clientItems})
+	total, err := QueryShards(client, queries, PadToPowerOfTwo(1), s.ProcessRequest)
+	if err != nil {
+		t.Fatalf("failed to query shards %v", err)
+	}
+	expected := len(clientItems) / 2
+	if int(total) < expected || float64(total) > float64(expected)*1.1 {
+		t.Errorf("Invalid intersection. expected about %v. got %v", expected, total)
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/message"
+	"time"
+)
+
+//DefaultMaxClockSkew is the default tolerance of a SetupVerifier for setup messages signed in
+//the future according to the local clock.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+//SetupVerifier authenticates setup messages with the pinned public keys of the server operator,
+//so that setup messages downloaded from a CDN or a mirror can be trusted, and rejects the stale
+//ones.
+type SetupVerifier struct {
+	keys []ed25519.PublicKey
+	//MaxAge is the time since its signature beyond which a setup message is rejected as stale, 0
+	//to accept any age. The publisher signs its setup messages again periodically, see
+	//server.DayShards.
+	MaxAge time.Duration
+	//MaxClockSkew is the tolerance for setup messages signed in the future.
+	MaxClockSkew time.Duration
+
+	now func() time.Time
+}
+
+//NewSetupVerifier returns a verifier accepting the setup messages signed with one of keys, for
+//example the current and the next operator keys during a rotation, and signed at most maxAge
+//ago.
+//
+//Returns an error if no key is provided or if a key is invalid.
+func NewSetupVerifier(maxAge time.Duration, keys ...ed25519.PublicKey) (*SetupVerifier, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one operator key is required")
+	}
+	if maxAge < 0 {
+		return nil, errors.New("the maximum age must not be negative")
+	}
+	pinned := []ed25519.PublicKey{}
+	for _, key := range keys {
+		if len(key) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid operator key")
+		}
+		pinned = append(pinned, append(ed25519.PublicKey{}, key...))
+	}
+	return &SetupVerifier{
+		keys:         pinned,
+		MaxAge:       maxAge,
+		MaxClockSkew: DefaultMaxClockSkew,
+		now:          time.Now,
+	}, nil
+}
+
+//Verify checks the signature and the freshness of setup.
+//
+//Returns message.ErrUnsigned or message.ErrInvalidSignature if the setup message is not signed
+//with a pinned key, or an error if it is stale or was signed in the future.
+func (v *SetupVerifier) Verify(setup *message.SetupMessage) error {
+	if setup == nil {
+		return errors.New("invalid setup message")
+	}
+	if err := setup.VerifySignature(v.keys...); err != nil {
+		return err
+	}
+	//the creation time of a setup message published for long is old, its freshness is the one
+	//of its signature.
+	now := v.now()
+	if setup.SignedAt.After(now.Add(v.MaxClockSkew)) {
+		return fmt.Errorf("setup message signed in the future at %v", setup.SignedAt)
+	}
+	if v.MaxAge == 0 {
+		return nil
+	}
+	if setup.SignedAt.IsZero() {
+		return errors.New("setup message without signing time")
+	}
+	if age := now.Sub(setup.SignedAt); age > v.MaxAge {
+		return fmt.Errorf("stale setup message signed %v ago", age.Truncate(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"testing"
+	"time"
+)
+
+func TestSetupVerifier(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := NewSetupVerifier(time.Hour); err == nil {
+		t.Errorf("a verifier without keys should be rejected")
+	}
+	if _, err := NewSetupVerifier(time.Hour, public[:10]); err == nil {
+		t.Errorf("an invalid key should be rejected")
+	}
+	verifier, err := NewSetupVerifier(time.Hour, other, public)
+	if err != nil {
+		t.Fatalf("NewSetupVerifier failed %v", err)
+	}
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	verifier.now = func() time.Time { return now }
+
+	sign := func(signedAt time.Time) *message.SetupMessage {
+		setup := message.NewSetupMessage("0.2.0", "setup payload")
+		setup.CreatedAt = now.Add(-48 * time.Hour)
+		if err := setup.SignAt(private, signedAt); err != nil {
+			t.Fatal(err.Error())
+		}
+		return setup
+	}
+	if err := verifier.Verify(sign(now.Add(-time.Minute))); err != nil {
+		t.Errorf("a setup signed recently should be accepted, however old %v", err)
+	}
+	if err := verifier.Verify(message.NewSetupMessage("0.2.0", "setup payload")); err != message.ErrUnsigned {
+		t.Errorf("an unsigned setup should be rejected %v", err)
+	}
+	tampered := sign(now)
+	tampered.Reports = 1000
+	if err := verifier.Verify(tampered); err != message.ErrInvalidSignature {
+		t.Errorf("a tampered setup should be rejected %v", err)
+	}
+	for _, signedAt := range []time.Time{now.Add(-2 * time.Hour), now.Add(time.Hour), {}} {
+		if err := verifier.Verify(sign(signedAt)); err == nil {
+			t.Errorf("a setup signed at %v should be rejected", signedAt)
+		}
+	}
+	verifier.MaxAge = 0
+	if err := verifier.Verify(sign(now.Add(-48 * time.Hour))); err != nil {
+		t.Errorf("any age should be accepted %v", err)
+	}
+}
+
+func TestClientVerifier(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer tcnServer.Close()
+	client, err := Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer client.Close()
+	verifier, err := NewSetupVerifier(time.Hour, public)
+	if err != nil {
+		t.Fatalf("NewSetupVerifier failed %v", err)
+	}
+	client.SetVerifier(verifier)
+
+	serverItems, clientItems, err := helperGetReports(10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup, err := tcnServer.CreateSetupMessage(0.001, 100, serverItems)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	if _, err := client.CreateRequest(setup, clientItems); err != message.ErrUnsigned {
+		t.Errorf("an unsigned setup should be rejected %v", err)
+	}
+	if err := setup.Sign(private); err != nil {
+		t.Fatal(err.Error())
+	}
+	request, err := client.CreateRequest(setup, clientItems)
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	response, err := tcnServer.ProcessRequest(request)
+	if err != nil {
+		t.Fatalf("failed to process request %v", err)
+	}
+
+	tampered := *setup
+	tampered.Reports++
+	if _, err := client.GetIntersectionSize(&tampered, response); err != message.ErrInvalidSignature {
+		t.Errorf("a tampered setup should be rejected %v", err)
+	}
+	if _, err := client.GetIntersectionSize(setup, response); err != nil {
+		t.Errorf("failed to compute intersection %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/dataset"
+	"github.com/openmined/tcn-psi/keystore"
+	"github.com/openmined/tcn-psi/server"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+//passphraseEnv is the environment variable holding the passphrase of the encrypted key file,
+//unless a passphrase file is configured.
+const passphraseEnv = "TCNPSI_KEY_PASSPHRASE"
+
+//loadKey creates the server with the key described by config. A missing encrypted key file is
+//created with a fresh key, whereas a missing plaintext key file is an error: the server never
+//writes a key in plaintext.
+func loadKey(config *Config) (*server.TCNServer, error) {
+	if config.KeyEnv != "" {
+		return keystore.LoadServer(&keystore.Env{Name: config.KeyEnv})
+	}
+	if config.KeyShares != "" {
+		return keystore.LoadServer(&keystore.ShareFiles{Paths: strings.Split(config.KeyShares, ",")})
+	}
+	if config.KeyFile != "" {
+		if _, err := os.Stat(config.KeyFile); os.IsNotExist(err) {
+			return nil, fmt.Errorf("server key file %v not found, use -encrypted-key to create a key", config.KeyFile)
+		}
+		return keystore.LoadServer(&keystore.File{Path: config.KeyFile})
+	}
+
+	passphrase := keystore.PassphraseEnv(passphraseEnv)
+	if config.PassphraseFile != "" {
+		passphrase = keystore.PassphraseFile(config.PassphraseFile)
+	}
+	path := config.EncryptedKey
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return keystore.LoadServer(&keystore.EncryptedFile{Path: path, Passphrase: passphrase})
+	}
+	secret, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	defer keystore.Zero(secret)
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := keystore.SaveServer(tcnServer, path, secret); err != nil {
+		tcnServer.Close()
+		return nil, err
+	}
+	log.Printf("created server key %v in %v", tcnServer.KeyID(), path)
+	return tcnServer, nil
+}
+
+//loadSigningKey reads the operator signing key from path: the hexadecimal encoding of an
+//ed25519 private key, as written by tcnpsi generate-signing-key.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	if err := keystore.CheckPermissions(path); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer keystore.Zero(data)
+	key, err := keystore.DecodeHex(data)
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		keystore.Zero(key)
+		return nil, errors.New("invalid signing key file")
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+//openDataset opens the dataset described by config. The stored reports are loaded by
+//Dataset.Load.
+func openDataset(config *Config) (*dataset.Dataset, error) {
+	var signingKey ed25519.PrivateKey
+	if config.SigningKey != "" {
+		key, err := loadSigningKey(config.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+		signingKey = key
+	}
+	tcnServer, err := loadKey(config)
+	if err != nil {
+		return nil, err
+	}
+	d, err := dataset.Open(tcnServer, dataset.Config{
+		Store:        config.Store,
+		FPR:          config.FPR,
+		ClientInputs: config.ClientInputs,
+		Retention:    config.Retention,
+		MaxSpan:      config.MaxSpan,
+		RateLimit:    config.RateLimit,
+		Burst:        config.Burst,
+		MinElements:  config.MinElements,
+		MaxElements:  config.MaxElements,
+		ClientBudget: config.ClientBudget,
+		GlobalBudget: config.GlobalBudget,
+		SigningKey:   signingKey,
+		ExportDir:    config.ExportDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if signingKey != nil {
+		log.Printf("signing the setup messages with operator key %x", d.SigningKey())
+	}
+	return d, nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/keystore"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/rest"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/static"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func helperManifest(t *testing.T, url string) *rest.ManifestJSON {
+	response, err := http.Get(url + "/v1/setup")
+	if err != nil {
+		t.Fatalf("GET /v1/setup failed %v", err)
+	}
+	defer response.Body.Close()
+	manifest := &rest.ManifestJSON{}
+	if err := json.NewDecoder(response.Body).Decode(manifest); err != nil {
+		t.Fatalf("invalid manifest %v", err)
+	}
+	return manifest
+}
+
+//helperKeyFile writes a fresh server key in plaintext to dir and returns its path.
+func helperKeyFile(t *testing.T, dir string) string {
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer tcnServer.Close()
+	key, err := tcnServer.GetPrivateKeyBytes()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	path := filepath.Join(dir, "server.key")
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	return path
+}
+
+func TestApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcnpsi-server")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	config, err := parseConfig([]string{
+		"-key", helperKeyFile(t, dir),
+		"-store", filepath.Join(dir, "reports.log"),
+	})
+	if err != nil {
+		t.Fatalf("parseConfig failed %v", err)
+	}
+
+	a, err := openDataset(config)
+	if err != nil {
+		t.Fatalf("openDataset failed %v", err)
+	}
+	ts := httptest.NewServer(a)
+	if response, err := http.Get(ts.URL + "/readyz"); err != nil || response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("the server should not be ready before loading")
+	}
+	if _, err := a.Load(); err != nil {
+		t.Fatalf("Load failed %v", err)
+	}
+	if response, err := http.Get(ts.URL + "/readyz"); err != nil || response.StatusCode != http.StatusOK {
+		t.Errorf("the server should be ready after loading")
+	}
+	reports, _ := reporttest.Serialized(t, 1)
+	response, err := http.Post(ts.URL+"/v1/reports", "application/octet-stream", bytes.NewReader(reports[0]))
+	if err != nil || response.StatusCode != http.StatusCreated {
+		t.Fatalf("report submission failed %v", err)
+	}
+	if err := a.Maintain(); err != nil {
+		t.Errorf("Maintain failed %v", err)
+	}
+	manifest := helperManifest(t, ts.URL)
+	if len(manifest.Shards) != 1 || manifest.Shards[0].Reports != 1 {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+	response, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed %v", err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if !strings.Contains(string(body), `tcnpsi_reports_submitted_total{result="ok"} 1`) {
+		t.Errorf("the submission was not recorded:\n%s", body)
+	}
+	ts.Close()
+	a.Close()
+
+	//a restarted server uses the same key and reloads the reports.
+	restarted, err := openDataset(config)
+	if err != nil {
+		t.Fatalf("openDataset failed %v", err)
+	}
+	defer restarted.Close()
+	if restarted.KeyID() != a.KeyID() {
+		t.Errorf("the key was not reloaded")
+	}
+	if loaded, err := restarted.Load(); err != nil || loaded != 1 {
+		t.Fatalf("Load failed %v %v", loaded, err)
+	}
+	ts = httptest.NewServer(restarted)
+	defer ts.Close()
+	manifest = helperManifest(t, ts.URL)
+	if len(manifest.Shards) != 1 || manifest.Shards[0].Reports != 1 {
+		t.Errorf("the reports were not reloaded %+v", manifest)
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcnpsi-server")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyPath := filepath.Join(dir, "operator.key")
+	if err := ioutil.WriteFile(keyPath, []byte(hex.EncodeToString(private)+"\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	config, err := parseConfig([]string{
+		"-key", helperKeyFile(t, dir),
+		"-signing-key", keyPath,
+		"-export-dir", filepath.Join(dir, "export"),
+		"-store", filepath.Join(dir, "reports.log"),
+	})
+	if err != nil {
+		t.Fatalf("parseConfig failed %v", err)
+	}
+	a, err := openDataset(config)
+	if err != nil {
+		t.Fatalf("openDataset failed %v", err)
+	}
+	defer a.Close()
+	if _, err := a.Load(); err != nil {
+		t.Fatalf("Load failed %v", err)
+	}
+	ts := httptest.NewServer(a)
+	defer ts.Close()
+	reports, _ := reporttest.Serialized(t, 1)
+	response, err := http.Post(ts.URL+"/v1/reports", "application/octet-stream", bytes.NewReader(reports[0]))
+	if err != nil || response.StatusCode != http.StatusCreated {
+		t.Fatalf("report submission failed %v", err)
+	}
+	manifest := helperManifest(t, ts.URL)
+	if len(manifest.Shards) != 1 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	response, err = http.Get(ts.URL + "/v1/setup/" + manifest.Shards[0].ID)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("GET setup failed %v", err)
+	}
+	data, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	setup := &message.SetupMessage{}
+	if err := setup.UnmarshalBinary(data); err != nil {
+		t.Fatalf("invalid setup %v", err)
+	}
+	if err := setup.VerifySignature(public); err != nil || setup.Reports != 1 {
+		t.Errorf("the setup should be signed by the operator %v", err)
+	}
+
+	//the maintenance exports the setup messages.
+	if err := a.Maintain(); err != nil {
+		t.Fatalf("Maintain failed %v", err)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(dir, "export", static.ManifestFile))
+	if err != nil {
+		t.Fatalf("the setup messages should be exported %v", err)
+	}
+	exported := &static.ManifestJSON{}
+	if err := json.Unmarshal(data, exported); err != nil {
+		t.Fatalf("invalid manifest %v", err)
+	}
+	if err := exported.VerifySignature(public); err != nil || len(exported.Setups) != 1 || exported.Setups[0].ID != setup.ID.String() {
+		t.Errorf("unexpected export %+v %v", exported, err)
+	}
+
+	if err := ioutil.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := openDataset(config); err == nil {
+		t.Errorf("an invalid signing key should be rejected")
+	}
+}
+
+func TestKeySources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcnpsi-server")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	keyPath := filepath.Join(dir, "server.key.enc")
+	passphrasePath := filepath.Join(dir, "passphrase")
+	if err := ioutil.WriteFile(passphrasePath, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	config, err := parseConfig([]string{
+		"-encrypted-key", keyPath,
+		"-passphrase-file", passphrasePath,
+		"-store", filepath.Join(dir, "reports.log"),
+	})
+	if err != nil {
+		t.Fatalf("parseConfig failed %v", err)
+	}
+
+	//the encrypted key is created on the first start, then reloaded.
+	created, err := loadKey(config)
+	if err != nil {
+		t.Fatalf("loadKey failed %v", err)
+	}
+	defer created.Close()
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil || !strings.Contains(string(data), "pbkdf2-sha256") {
+		t.Fatalf("the key should be stored encrypted %v", err)
+	}
+	reloaded, err := loadKey(config)
+	if err != nil || reloaded.KeyID() != created.KeyID() {
+		t.Fatalf("the encrypted key was not reloaded %v", err)
+	}
+	reloaded.Close()
+
+	if err := ioutil.WriteFile(passphrasePath, []byte("wrong horse"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := loadKey(config); err == nil {
+		t.Errorf("a wrong passphrase should be rejected")
+	}
+
+	key, err := created.GetPrivateKeyBytes()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	os.Setenv("TCNPSI_TEST_SERVER_KEY", hex.EncodeToString(key))
+	defer os.Unsetenv("TCNPSI_TEST_SERVER_KEY")
+	fromEnv, err := loadKey(&Config{KeyEnv: "TCNPSI_TEST_SERVER_KEY"})
+	if err != nil || fromEnv.KeyID() != created.KeyID() {
+		t.Fatalf("the key was not read from the environment %v", err)
+	}
+	fromEnv.Close()
+
+	shares, err := keystore.SplitServer(created, 3, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sharePaths := []string{}
+	for _, share := range shares[1:] {
+		path := filepath.Join(dir, "share-"+strconv.Itoa(share.Index)+".json")
+		if err := keystore.WriteShareFile(path, share); err != nil {
+			t.Fatal(err.Error())
+		}
+		sharePaths = append(sharePaths, path)
+	}
+	fromShares, err := loadKey(&Config{KeyShares: strings.Join(sharePaths, ",")})
+	if err != nil || fromShares.KeyID() != created.KeyID() {
+		t.Fatalf("the key was not reconstructed from the shares %v", err)
+	}
+	fromShares.Close()
+	if _, err := loadKey(&Config{KeyShares: sharePaths[0]}); err != keystore.ErrNoQuorum {
+		t.Errorf("a single share should not be a quorum %v", err)
+	}
+
+	plainPath := filepath.Join(dir, "server.key")
+	if _, err := loadKey(&Config{KeyFile: plainPath}); err == nil {
+		t.Errorf("a missing plaintext key file should be rejected")
+	}
+	if _, err := os.Stat(plainPath); !os.IsNotExist(err) {
+		t.Errorf("a plaintext key file should never be created %v", err)
+	}
+	if err := ioutil.WriteFile(plainPath, key, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := loadKey(&Config{KeyFile: plainPath}); err == nil {
+		t.Errorf("a key file readable by other users should be rejected")
+	}
+}
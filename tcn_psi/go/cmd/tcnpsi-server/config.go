@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"time"
+)
+
+//Config configures the server. It is read from a JSON file whose keys are the flag names, and
+//the flags set on the command line override the file.
+type Config struct {
+	Listen string `json:"listen"`
+	//The server key is read from exactly one of KeyFile, EncryptedKey, KeyEnv and KeyShares.
+	KeyFile        string `json:"key"`
+	EncryptedKey   string `json:"encrypted-key"`
+	PassphraseFile string `json:"passphrase-file"`
+	KeyEnv         string `json:"key-env"`
+	//KeyShares is a comma-separated list of share files created by tcnpsi split-key.
+	KeyShares string `json:"key-shares"`
+	//SigningKey is the file holding the operator key signing the setup messages.
+	SigningKey string `json:"signing-key"`
+	//ExportDir receives the setup messages as static files, signed with SigningKey.
+	ExportDir string `json:"export-dir"`
+	Store     string `json:"store"`
+	TLSCert   string `json:"tls-cert"`
+	TLSKey    string `json:"tls-key"`
+
+	FPR          float64 `json:"fpr"`
+	ClientInputs int64   `json:"client-inputs"`
+	Retention    int     `json:"retention"`
+
+	MaxSpan   int     `json:"max-span"`
+	RateLimit float64 `json:"rate-limit"`
+	Burst     int     `json:"burst"`
+
+	MinElements  int `json:"min-elements"`
+	MaxElements  int `json:"max-elements"`
+	ClientBudget int `json:"client-budget"`
+	GlobalBudget int `json:"global-budget"`
+
+	Maintenance Duration `json:"maintenance"`
+}
+
+//Duration is a time.Duration read from JSON as a string, e.g. "1m30s".
+type Duration time.Duration
+
+//UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+//defaultConfig returns the configuration used when neither a file nor a flag set a value.
+func defaultConfig() Config {
+	return Config{
+		Listen:       ":8080",
+		FPR:          1e-6,
+		ClientInputs: 1024,
+		Retention:    14,
+		RateLimit:    0.1,
+		Burst:        10,
+		MinElements:  64,
+		Maintenance:  Duration(time.Minute),
+	}
+}
+
+//registerFlags binds the flags of fs to config.
+func registerFlags(fs *flag.FlagSet, config *Config) *string {
+	path := fs.String("config", "", "JSON configuration file, overridden by the flags")
+	fs.StringVar(&config.Listen, "listen", config.Listen, "address to listen on")
+	fs.StringVar(&config.KeyFile, "key", config.KeyFile, "file holding the server private key in plaintext, never created")
+	fs.StringVar(&config.EncryptedKey, "encrypted-key", config.EncryptedKey, "file holding the passphrase-encrypted server private key, created if missing")
+	fs.StringVar(&config.PassphraseFile, "passphrase-file", config.PassphraseFile, "file holding the passphrase of -encrypted-key, read from $"+passphraseEnv+" if empty")
+	fs.StringVar(&config.KeyEnv, "key-env", config.KeyEnv, "environment variable holding the hex encoded server private key")
+	fs.StringVar(&config.KeyShares, "key-shares", config.KeyShares, "comma-separated quorum of share files reconstructing the server private key")
+	fs.StringVar(&config.SigningKey, "signing-key", config.SigningKey, "file holding the hex encoded ed25519 operator key signing the setup messages, unsigned if empty")
+	fs.StringVar(&config.ExportDir, "export-dir", config.ExportDir, "directory to export the setup messages and their signed manifest to, for a CDN")
+	fs.StringVar(&config.Store, "store", config.Store, "file holding the submitted reports, created if missing")
+	fs.StringVar(&config.TLSCert, "tls-cert", config.TLSCert, "TLS certificate file, serves plain HTTP if empty")
+	fs.StringVar(&config.TLSKey, "tls-key", config.TLSKey, "TLS private key file")
+	fs.Float64Var(&config.FPR, "fpr", config.FPR, "false-positive rate of a check of client-inputs contacts")
+	fs.Int64Var(&config.ClientInputs, "client-inputs", config.ClientInputs, "number of contacts of a check the false-positive rate applies to")
+	fs.IntVar(&config.Retention, "retention", config.Retention, "number of days of reports published")
+	fs.IntVar(&config.MaxSpan, "max-span", config.MaxSpan, "maximum number of TCNs revealed by a report, 0 for no limit")
+	fs.Float64Var(&config.RateLimit, "rate-limit", config.RateLimit, "reports a client may submit per second, 0 for no limit")
+	fs.IntVar(&config.Burst, "burst", config.Burst, "reports a client may submit at once")
+	fs.IntVar(&config.MinElements, "min-elements", config.MinElements, "minimum number of elements of a request")
+	fs.IntVar(&config.MaxElements, "max-elements", config.MaxElements, "maximum number of elements of a request, 0 for no limit")
+	fs.IntVar(&config.ClientBudget, "client-budget", config.ClientBudget, "requests a client may send per setup message, 0 for no limit")
+	fs.IntVar(&config.GlobalBudget, "global-budget", config.GlobalBudget, "requests answered per setup message, 0 for no limit")
+	fs.DurationVar((*time.Duration)(&config.Maintenance), "maintenance", time.Duration(config.Maintenance), "interval of the maintenance tasks")
+	return path
+}
+
+//parseConfig parses the command line arguments and the configuration file they point to.
+//
+//Returns an error if the arguments or the file are invalid.
+func parseConfig(args []string) (*Config, error) {
+	config := defaultConfig()
+	fs := flag.NewFlagSet("tcnpsi-server", flag.ContinueOnError)
+	path := registerFlags(fs, &config)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		//reload the defaults, then the file, then the flags set on the command line.
+		config = defaultConfig()
+		data, err := ioutil.ReadFile(*path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+		fs = flag.NewFlagSet("tcnpsi-server", flag.ContinueOnError)
+		registerFlags(fs, &config)
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+	}
+	return &config, config.validate()
+}
+
+//countSet returns the number of non-empty values.
+func countSet(values ...string) int {
+	cnt := 0
+	for _, value := range values {
+		if value != "" {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+//validate checks the consistency of the configuration.
+func (c *Config) validate() error {
+	switch {
+	case countSet(c.KeyFile, c.EncryptedKey, c.KeyEnv, c.KeyShares) != 1:
+		return errors.New("exactly one of the key file, the encrypted key file, the key variable and the key shares is required")
+	case c.PassphraseFile != "" && c.EncryptedKey == "":
+		return errors.New("the passphrase file requires an encrypted key file")
+	case c.ExportDir != "" && c.SigningKey == "":
+		return errors.New("the export directory requires a signing key")
+	case c.Store == "":
+		return errors.New("a store file is required")
+	case c.FPR <= 0 || c.FPR >= 1:
+		return errors.New("the false-positive rate must be in (0, 1)")
+	case c.ClientInputs <= 0:
+		return errors.New("the number of client inputs must be positive")
+	case c.Retention <= 0:
+		return errors.New("the retention must be positive")
+	case (c.TLSCert == "") != (c.TLSKey == ""):
+		return errors.New("both the TLS certificate and key are required")
+	case c.Maintenance <= 0:
+		return errors.New("the maintenance interval must be positive")
+	case c.RateLimit > 0 && c.Burst < 1:
+		return errors.New("the burst must be at least 1 when the rate is limited")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := parseConfig([]string{"-key", "server.key", "-store", "reports.log", "-fpr", "0.001"})
+	if err != nil {
+		t.Fatalf("parseConfig failed %v", err)
+	}
+	if config.KeyFile != "server.key" || config.FPR != 0.001 || config.Listen != ":8080" || config.Retention != 14 {
+		t.Errorf("unexpected configuration %+v", config)
+	}
+
+	for _, args := range [][]string{
+		{"-store", "reports.log"},
+		{"-key", "server.key"},
+		{"-key", "server.key", "-store", "reports.log", "-fpr", "1"},
+		{"-key", "server.key", "-store", "reports.log", "-tls-cert", "cert.pem"},
+		{"-key", "server.key", "-store", "reports.log", "-retention", "0"},
+		{"-key", "server.key", "-store", "reports.log", "-client-inputs", "0"},
+		{"-key", "server.key", "-store", "reports.log", "-burst", "0"},
+		{"-key", "server.key", "-encrypted-key", "server.key.enc", "-store", "reports.log"},
+		{"-key", "server.key", "-passphrase-file", "passphrase", "-store", "reports.log"},
+		{"-key", "server.key", "-export-dir", "export", "-store", "reports.log"},
+		{"-key-env", "TCNPSI_SERVER_KEY", "-key-shares", "share-1.json,share-2.json", "-store", "reports.log"},
+		{"-unknown"},
+	} {
+		if _, err := parseConfig(args); err == nil {
+			t.Errorf("parseConfig should fail for %v", args)
+		}
+	}
+}
+
+func TestParseConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcnpsi-server")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	data := `{"listen": ":9090", "key": "file.key", "store": "reports.log", "retention": 7, "maintenance": "5m"}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	config, err := parseConfig([]string{"-config", path, "-key", "flag.key"})
+	if err != nil {
+		t.Fatalf("parseConfig failed %v", err)
+	}
+	if config.Listen != ":9090" || config.Retention != 7 || time.Duration(config.Maintenance) != 5*time.Minute {
+		t.Errorf("the file was not applied %+v", config)
+	}
+	if config.KeyFile != "flag.key" {
+		t.Errorf("the flags should override the file, got %v", config.KeyFile)
+	}
+	if config.FPR != defaultConfig().FPR {
+		t.Errorf("the defaults should apply to the missing keys, got %v", config.FPR)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"maintenance": 5}`), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := parseConfig([]string{"-config", path}); err == nil {
+		t.Errorf("parseConfig should fail with an invalid file")
+	}
+	if _, err := parseConfig([]string{"-config", filepath.Join(dir, "missing.json")}); err == nil {
+		t.Errorf("parseConfig should fail with a missing file")
+	}
+}
@@ -0,0 +1,88 @@
+//Command tcnpsi-server serves TCN-PSI setup messages, answers PSI requests and accepts report
+//submissions over HTTP. See the rest package for the API.
+//
+//Usage:
+//
+//	tcnpsi-server -key server.key -store reports.log [-listen :8080] [-config config.json]
+//	tcnpsi-server -encrypted-key server.key.enc [-passphrase-file passphrase] -store reports.log
+//	tcnpsi-server -key-env TCNPSI_SERVER_KEY -store reports.log
+//	tcnpsi-server -key-shares share-1.json,share-3.json,share-4.json -store reports.log
+//	tcnpsi-server -key server.key -signing-key operator.key -export-dir /var/www/tcn -store reports.log
+//
+//The file of -encrypted-key is created with a fresh key if missing, whereas the plaintext file of
+//-key must exist.
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	config, err := parseConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if err := run(config); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//run serves until the process receives SIGINT or SIGTERM.
+func run(config *Config) error {
+	d, err := openDataset(config)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	httpServer := &http.Server{
+		Addr:              config.Listen,
+		Handler:           d,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      5 * time.Minute,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %v", config.Listen)
+		if config.TLSCert != "" {
+			errs <- httpServer.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+		} else {
+			errs <- httpServer.ListenAndServe()
+		}
+	}()
+
+	//the health endpoint answers while the reports are loaded.
+	loaded, err := d.Load()
+	if err != nil {
+		httpServer.Close()
+		return err
+	}
+	log.Printf("loaded %v reports", loaded)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Duration(config.Maintenance))
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-ticker.C:
+			if err := d.Maintain(); err != nil {
+				log.Printf("maintenance failed: %v", err)
+			}
+		case sig := <-signals:
+			log.Printf("received %v, shutting down", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			return httpServer.Shutdown(ctx)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+//Command tcnpsi-sim simulates a population using TCN-PSI and prints, for every false-positive
+//rate, the true and measured exposures, the false-positive rates, the setup message size and
+//the timings as CSV. See the simulation package for the model.
+//
+//Usage:
+//
+//	tcnpsi-sim [-population 200] [-days 14] [-fpr 0.01,1e-4,1e-6] [-checks checks.csv] [-out summary.csv]
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/openmined/tcn-psi/simulation"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//options are the settings of the command which are not part of the simulation.
+type options struct {
+	fprs   []float64
+	out    string
+	checks string
+}
+
+//parseArgs parses the command line arguments.
+func parseArgs(args []string) (*simulation.Config, *options, error) {
+	config := simulation.DefaultConfig()
+	fs := flag.NewFlagSet("tcnpsi-sim", flag.ContinueOnError)
+	fs.IntVar(&config.Population, "population", config.Population, "number of simulated people")
+	fs.IntVar(&config.Days, "days", config.Days, "number of simulated days")
+	fs.IntVar(&config.TCNsPerDay, "tcns-per-day", config.TCNsPerDay, "TCNs broadcast by a person every day")
+	fs.Float64Var(&config.Encounters, "encounters", config.Encounters, "average number of people met every day")
+	fs.Float64Var(&config.InfectionRate, "infection-rate", config.InfectionRate, "daily probability of an infection regardless of the encounters")
+	fs.Float64Var(&config.TransmissionRate, "transmission-rate", config.TransmissionRate, "probability for an encounter with an infected person to infect")
+	fs.IntVar(&config.ReportWindow, "report-window", config.ReportWindow, "days of TCNs revealed by a report")
+	fs.IntVar(&config.Clients, "clients", config.Clients, "number of people checking their exposure, 0 for everyone")
+	fs.Int64Var(&config.Seed, "seed", config.Seed, "seed of the simulation")
+	fprs := fs.String("fpr", "0.01,0.0001,0.000001", "comma-separated false-positive rates to evaluate")
+	opts := &options{}
+	fs.StringVar(&opts.out, "out", "", "summary CSV file, standard output if empty")
+	fs.StringVar(&opts.checks, "checks", "", "CSV file receiving one row per client check")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, nil, errors.New("unexpected arguments")
+	}
+
+	for _, value := range strings.Split(*fprs, ",") {
+		fpr, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || fpr <= 0 || fpr >= 1 {
+			return nil, nil, fmt.Errorf("invalid false-positive rate %q", value)
+		}
+		opts.fprs = append(opts.fprs, fpr)
+	}
+	return &config, opts, config.Validate()
+}
+
+//run simulates the population and writes the CSV files.
+func run(config *simulation.Config, opts *options, stdout io.Writer) error {
+	population, err := simulation.Generate(*config)
+	if err != nil {
+		return err
+	}
+	log.Printf("%v people, %v infected, %v reported TCNs", config.Population, population.Infected(), population.ReportedTCNs())
+
+	results := []*simulation.Result{}
+	for _, fpr := range opts.fprs {
+		result, err := population.Run(fpr)
+		if err != nil {
+			return fmt.Errorf("fpr %v: %v", fpr, err)
+		}
+		log.Printf("fpr %v: setup message of %v bytes built in %v", fpr, result.SetupSize, result.SetupTime)
+		results = append(results, result)
+	}
+
+	if err := writeFile(opts.out, stdout, results, simulation.WriteSummaryCSV); err != nil {
+		return err
+	}
+	if opts.checks != "" {
+		return writeFile(opts.checks, stdout, results, simulation.WriteChecksCSV)
+	}
+	return nil
+}
+
+//writeFile writes the results to path, or to stdout if path is empty.
+func writeFile(path string, stdout io.Writer, results []*simulation.Result, write func(io.Writer, []*simulation.Result) error) error {
+	if path == "" {
+		return write(stdout, results)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file, results); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func main() {
+	config, opts, err := parseArgs(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid arguments: %v", err)
+	}
+	if err := run(config, opts, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
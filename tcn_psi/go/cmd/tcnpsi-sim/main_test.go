@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/openmined/tcn-psi/simulation"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	config, opts, err := parseArgs([]string{"-population", "50", "-fpr", "0.1, 0.001", "-seed", "7"})
+	if err != nil {
+		t.Fatalf("parseArgs failed %v", err)
+	}
+	if config.Population != 50 || config.Seed != 7 || config.Days != simulation.DefaultConfig().Days {
+		t.Errorf("unexpected configuration %+v", config)
+	}
+	if len(opts.fprs) != 2 || opts.fprs[0] != 0.1 || opts.fprs[1] != 0.001 {
+		t.Errorf("unexpected false-positive rates %v", opts.fprs)
+	}
+
+	for _, args := range [][]string{
+		{"-fpr", "2"},
+		{"-fpr", "abc"},
+		{"-population", "1"},
+		{"extra"},
+		{"-unknown"},
+	} {
+		if _, _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs should fail for %v", args)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcnpsi-sim")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	checks := filepath.Join(dir, "checks.csv")
+
+	config, opts, err := parseArgs([]string{"-population", "30", "-days", "3", "-tcns-per-day", "12", "-clients", "10", "-fpr", "0.01,0.0001", "-checks", checks})
+	if err != nil {
+		t.Fatalf("parseArgs failed %v", err)
+	}
+	out := &bytes.Buffer{}
+	if err := run(config, opts, out); err != nil {
+		t.Fatalf("run failed %v", err)
+	}
+	rows, err := csv.NewReader(out).ReadAll()
+	if err != nil || len(rows) != 3 || rows[0][0] != "fpr" {
+		t.Errorf("unexpected summary %v %v", rows, err)
+	}
+
+	data, err := ioutil.ReadFile(checks)
+	if err != nil {
+		t.Fatalf("the checks were not written %v", err)
+	}
+	rows, err = csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil || len(rows) != 1+2*10 {
+		t.Errorf("unexpected checks %v %v", len(rows), err)
+	}
+}
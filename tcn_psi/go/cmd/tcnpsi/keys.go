@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/keystore"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/tcn"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//TCNJSON is a TCN along with its ratchet index.
+type TCNJSON struct {
+	Index uint16 `json:"index"`
+	TCN   string `json:"tcn"`
+}
+
+//RAKJSON is the output of generate-rak.
+type RAKJSON struct {
+	File string `json:"file"`
+	RVK  string `json:"rvk"`
+}
+
+func (r *RAKJSON) writeText(w io.Writer) {
+	fmt.Fprintf(w, "report authorization key written to %v\n", r.File)
+	fmt.Fprintf(w, "rvk %v\n", r.RVK)
+}
+
+//TCNsJSON is the output of tcns and expand-report.
+type TCNsJSON struct {
+	RVK  string    `json:"rvk"`
+	TCNs []TCNJSON `json:"tcns"`
+}
+
+func (r *TCNsJSON) writeText(w io.Writer) {
+	for _, value := range r.TCNs {
+		fmt.Fprintf(w, "%v %v\n", value.Index, value.TCN)
+	}
+}
+
+//SigningKeyJSON is the output of generate-signing-key.
+type SigningKeyJSON struct {
+	File      string `json:"file"`
+	PublicKey string `json:"public_key"`
+}
+
+func (r *SigningKeyJSON) writeText(w io.Writer) {
+	fmt.Fprintf(w, "operator signing key written to %v\n", r.File)
+	fmt.Fprintf(w, "public key %v\n", r.PublicKey)
+}
+
+//ServerKeyJSON is the output of encrypt-key and combine-key.
+type ServerKeyJSON struct {
+	File  string `json:"file,omitempty"`
+	KeyID string `json:"key_id"`
+}
+
+func (r *ServerKeyJSON) writeText(w io.Writer) {
+	if r.File == "" {
+		fmt.Fprintf(w, "server key %v\n", r.KeyID)
+		return
+	}
+	fmt.Fprintf(w, "encrypted server key %v written to %v\n", r.KeyID, r.File)
+}
+
+//SharesJSON is the output of split-key.
+type SharesJSON struct {
+	KeyID     string   `json:"key_id"`
+	Threshold int      `json:"threshold"`
+	Files     []string `json:"files"`
+}
+
+func (r *SharesJSON) writeText(w io.Writer) {
+	fmt.Fprintf(w, "server key %v split into %v shares, %v required\n", r.KeyID, len(r.Files), r.Threshold)
+	for _, file := range r.Files {
+		fmt.Fprintln(w, file)
+	}
+}
+
+//passphraseEnv is the environment variable holding the passphrase of encrypt-key, unless a
+//passphrase file is given. It matches the one read by tcnpsi-server.
+const passphraseEnv = "TCNPSI_KEY_PASSPHRASE"
+
+//loadKey reads a key stored by generate-rak or generate-signing-key: the hexadecimal encoding
+//of an ed25519 private key.
+func loadKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer keystore.Zero(data)
+	key, err := keystore.DecodeHex(data)
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		keystore.Zero(key)
+		return nil, errors.New("invalid key file")
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+//loadRAK reads a report authorization key stored by generate-rak.
+func loadRAK(path string) (*tcn.ReportAuthorizationKey, error) {
+	rak, err := loadKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return &tcn.ReportAuthorizationKey{RAK: rak, RVK: rak.Public().(ed25519.PublicKey)}, nil
+}
+
+//saveKey writes key to a new file readable by its owner only.
+func saveKey(path string, key ed25519.PrivateKey, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, hex.EncodedLen(len(key))+1)
+	defer keystore.Zero(data)
+	hex.Encode(data, key)
+	data[len(data)-1] = '\n'
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func generateRAK(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("generate-rak", errOut)
+	path := fs.String("out", "", "file to write the key to")
+	force := fs.Bool("force", false, "overwrite an existing file")
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("an output file is required")
+	}
+
+	rak, err := tcn.NewReportAuthorizationKey()
+	if err != nil {
+		return err
+	}
+	if err := saveKey(*path, rak.RAK, *force); err != nil {
+		return err
+	}
+	return write(out, *asJSON, &RAKJSON{File: *path, RVK: hex.EncodeToString(rak.RVK)})
+}
+
+func generateSigningKey(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("generate-signing-key", errOut)
+	path := fs.String("out", "", "file to write the key to")
+	force := fs.Bool("force", false, "overwrite an existing file")
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("an output file is required")
+	}
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	if err := saveKey(*path, private, *force); err != nil {
+		return err
+	}
+	return write(out, *asJSON, &SigningKeyJSON{File: *path, PublicKey: hex.EncodeToString(public)})
+}
+
+//saveEncrypted writes the key of tcnServer to a new file encrypted with the passphrase of
+//passphraseFile, or of $TCNPSI_KEY_PASSPHRASE if empty.
+func saveEncrypted(tcnServer *server.TCNServer, path, passphraseFile string) error {
+	passphrase := keystore.PassphraseEnv(passphraseEnv)
+	if passphraseFile != "" {
+		passphrase = keystore.PassphraseFile(passphraseFile)
+	}
+	secret, err := passphrase()
+	if err != nil {
+		return err
+	}
+	defer keystore.Zero(secret)
+	return keystore.SaveServer(tcnServer, path, secret)
+}
+
+func encryptKey(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("encrypt-key", errOut)
+	in := fs.String("in", "", "file holding the plaintext server key")
+	path := fs.String("out", "", "file to write the encrypted key to")
+	passphraseFile := fs.String("passphrase-file", "", "file holding the passphrase, read from $"+passphraseEnv+" if empty")
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *in == "" || *path == "" {
+		return errors.New("the input and output files are required")
+	}
+
+	tcnServer, err := keystore.LoadServer(&keystore.File{Path: *in})
+	if err != nil {
+		return err
+	}
+	defer tcnServer.Close()
+	if err := saveEncrypted(tcnServer, *path, *passphraseFile); err != nil {
+		return err
+	}
+	return write(out, *asJSON, &ServerKeyJSON{File: *path, KeyID: tcnServer.KeyID().String()})
+}
+
+func splitKey(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("split-key", errOut)
+	in := fs.String("in", "", "file holding the plaintext server key, a new key is generated if empty")
+	dir := fs.String("out-dir", "", "directory to write the share files to")
+	total := fs.Int("shares", 5, "number of shares")
+	threshold := fs.Int("threshold", 3, "number of shares required to reconstruct the key")
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("an output directory is required")
+	}
+
+	var tcnServer *server.TCNServer
+	var err error
+	if *in != "" {
+		tcnServer, err = keystore.LoadServer(&keystore.File{Path: *in})
+	} else {
+		tcnServer, err = server.CreateWithNewKey()
+	}
+	if err != nil {
+		return err
+	}
+	defer tcnServer.Close()
+	shares, err := keystore.SplitServer(tcnServer, *total, *threshold)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		return err
+	}
+	output := &SharesJSON{KeyID: tcnServer.KeyID().String(), Threshold: *threshold, Files: []string{}}
+	for _, share := range shares {
+		path := filepath.Join(*dir, fmt.Sprintf("share-%d.json", share.Index))
+		err := keystore.WriteShareFile(path, share)
+		keystore.Zero(share.Data)
+		if err != nil {
+			return err
+		}
+		output.Files = append(output.Files, path)
+	}
+	return write(out, *asJSON, output)
+}
+
+func combineKey(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("combine-key", errOut)
+	path := fs.String("out", "", "file to write the encrypted key to, only checks the shares if empty")
+	passphraseFile := fs.String("passphrase-file", "", "file holding the passphrase, read from $"+passphraseEnv+" if empty")
+	if err := parseFlags(fs, args, 1, -1); err != nil {
+		return err
+	}
+
+	tcnServer, err := keystore.LoadServer(&keystore.ShareFiles{Paths: fs.Args()})
+	if err != nil {
+		return err
+	}
+	defer tcnServer.Close()
+	if *path != "" {
+		if err := saveEncrypted(tcnServer, *path, *passphraseFile); err != nil {
+			return err
+		}
+	}
+	return write(out, *asJSON, &ServerKeyJSON{File: *path, KeyID: tcnServer.KeyID().String()})
+}
+
+func printTCNs(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("tcns", errOut)
+	path := fs.String("rak", "", "report authorization key file")
+	from := fs.Uint("from", 1, "ratchet index of the first TCN, at least 1")
+	to := fs.Uint("to", 10, "ratchet index of the last TCN")
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *from == 0 || *from > *to || *to >= 1<<16 {
+		return errors.New("the indices must satisfy 1 <= from <= to < 65536")
+	}
+	rak, err := loadRAK(*path)
+	if err != nil {
+		return err
+	}
+
+	tck, err := rak.InitialTCK()
+	if err != nil {
+		return err
+	}
+	output := &TCNsJSON{RVK: hex.EncodeToString(rak.RVK), TCNs: []TCNJSON{}}
+	for {
+		if uint(tck.Index) >= *from {
+			value, err := tck.TemporaryContactNumber()
+			if err != nil {
+				return err
+			}
+			output.TCNs = append(output.TCNs, TCNJSON{Index: tck.Index, TCN: hex.EncodeToString(value[:])})
+		}
+		if uint(tck.Index) == *to {
+			break
+		}
+		if tck, err = tck.Ratchet(); err != nil {
+			return err
+		}
+	}
+	return write(out, *asJSON, output)
+}
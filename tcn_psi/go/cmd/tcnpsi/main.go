@@ -0,0 +1,131 @@
+//Command tcnpsi manipulates TCN keys and reports and runs local PSI rounds, for debugging and
+//scripting. Every command prints JSON with -json.
+//
+//Usage:
+//
+//	tcnpsi generate-rak -out rak.key
+//	tcnpsi generate-signing-key -out operator.key
+//	tcnpsi encrypt-key -in server.key -out server.key.enc [-passphrase-file passphrase]
+//	tcnpsi split-key [-in server.key] -out-dir shares [-shares 5] [-threshold 3]
+//	tcnpsi combine-key [-out server.key.enc [-passphrase-file passphrase]] share.json...
+//	tcnpsi tcns -rak rak.key [-from 1] [-to 10]
+//	tcnpsi create-report -rak rak.key -j1 1 -j2 10 [-memo-type 0] [-memo text | -memo-hex hex] -out report.bin
+//	tcnpsi inspect-report report.bin
+//	tcnpsi expand-report report.bin
+//	tcnpsi psi -tcns tcns.txt [-fpr 1e-6] report.bin...
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+//command is a subcommand of the tool.
+type command struct {
+	usage string
+	//run executes the command, printing its result on out and its usage on errOut.
+	run func(args []string, out, errOut io.Writer) error
+}
+
+var commands = map[string]command{
+	"generate-rak":         {"generate a report authorization key", generateRAK},
+	"generate-signing-key": {"generate an operator key signing the setup messages", generateSigningKey},
+	"encrypt-key":          {"encrypt a server key with a passphrase", encryptKey},
+	"split-key":            {"split a server key into Shamir shares", splitKey},
+	"combine-key":          {"reconstruct a server key from a quorum of shares", combineKey},
+	"tcns":                 {"print the TCNs of a report authorization key", printTCNs},
+	"create-report":        {"create and sign a report", createReport},
+	"inspect-report":       {"decode and verify a report", inspectReport},
+	"expand-report":        {"print the TCNs revealed by a report", expandReport},
+	"psi":                  {"run a local PSI round between TCNs and reports", runPSI},
+}
+
+//errUsage reports invalid arguments, after the usage was printed.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+//run executes the command selected by args and returns the exit code of the process.
+func run(args []string, out, errOut io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		usage(errOut)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(errOut, "tcnpsi: unknown command %q\n", args[0])
+		usage(errOut)
+		return 2
+	}
+	err := cmd.run(args[1:], out, errOut)
+	switch {
+	case err == nil || err == flag.ErrHelp:
+		return 0
+	case err == errUsage:
+		return 2
+	}
+	fmt.Fprintf(errOut, "tcnpsi %v: %v\n", args[0], err)
+	return 1
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: tcnpsi <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-15v %v\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(w, "\nrun 'tcnpsi <command> -h' for the flags of a command")
+}
+
+//result is the output of a command.
+type result interface {
+	//writeText prints the result for humans.
+	writeText(w io.Writer)
+}
+
+//newFlagSet returns the flag set of a command, with the -json flag.
+func newFlagSet(name string, errOut io.Writer) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet("tcnpsi "+name, flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	return fs, fs.Bool("json", false, "print the result as JSON")
+}
+
+//parseFlags parses args and checks that there are between min and max positional arguments. A
+//negative max means no upper bound.
+func parseFlags(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fmt.Fprintf(fs.Output(), "unexpected number of arguments %v\n", fs.NArg())
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+//write prints r as indented JSON if asJSON is set, and as text otherwise.
+func write(out io.Writer, asJSON bool, r result) error {
+	if !asJSON {
+		r.writeText(out)
+		return nil
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/openmined/tcn-psi/keystore"
+	"github.com/openmined/tcn-psi/server"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//helperRun runs the tool and returns its exit code and output.
+func helperRun(t *testing.T, args ...string) (int, string, string) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run(args, out, errOut)
+	return code, out.String(), errOut.String()
+}
+
+//helperRunJSON runs a command which must succeed and decodes its JSON output into value.
+func helperRunJSON(t *testing.T, value interface{}, args ...string) {
+	//the flags precede the positional arguments.
+	code, out, errOut := helperRun(t, append([]string{args[0], "-json"}, args[1:]...)...)
+	if code != 0 {
+		t.Fatalf("%v failed with code %v: %v", args, code, errOut)
+	}
+	if err := json.Unmarshal([]byte(out), value); err != nil {
+		t.Fatalf("%v: invalid JSON output %v %v", args, err, out)
+	}
+}
+
+func helperTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tcnpsi")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestUsage(t *testing.T) {
+	if code, _, errOut := helperRun(t); code != 2 || !strings.Contains(errOut, "inspect-report") {
+		t.Errorf("unexpected usage %v %v", code, errOut)
+	}
+	if code, _, _ := helperRun(t, "unknown"); code != 2 {
+		t.Errorf("an unknown command should fail with code 2, got %v", code)
+	}
+	if code, _, _ := helperRun(t, "tcns", "-unknown"); code != 2 {
+		t.Errorf("an unknown flag should fail with code 2, got %v", code)
+	}
+	if code, _, _ := helperRun(t, "inspect-report"); code != 2 {
+		t.Errorf("a missing argument should fail with code 2, got %v", code)
+	}
+	if code, _, _ := helperRun(t, "tcns", "-h"); code != 0 {
+		t.Errorf("-h should succeed, got %v", code)
+	}
+	if code, _, errOut := helperRun(t, "inspect-report", "missing.bin"); code != 1 || !strings.Contains(errOut, "missing.bin") {
+		t.Errorf("a missing file should fail with code 1, got %v %v", code, errOut)
+	}
+}
+
+func TestKeysAndReports(t *testing.T) {
+	dir := helperTempDir(t)
+	rakPath := filepath.Join(dir, "rak.key")
+	reportPath := filepath.Join(dir, "report.bin")
+
+	rak := &RAKJSON{}
+	helperRunJSON(t, rak, "generate-rak", "-out", rakPath)
+	if len(rak.RVK) != 64 {
+		t.Errorf("invalid RVK %v", rak.RVK)
+	}
+	if info, err := os.Stat(rakPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the key file should only be readable by its owner %v", err)
+	}
+	if code, _, _ := helperRun(t, "generate-rak", "-out", rakPath); code != 1 {
+		t.Errorf("generate-rak should not overwrite a key without -force")
+	}
+
+	signingPath := filepath.Join(dir, "operator.key")
+	signing := &SigningKeyJSON{}
+	helperRunJSON(t, signing, "generate-signing-key", "-out", signingPath)
+	if key, err := loadKey(signingPath); err != nil || hex.EncodeToString(key.Public().(ed25519.PublicKey)) != signing.PublicKey {
+		t.Errorf("invalid signing key %v %v", signing.PublicKey, err)
+	}
+	if code, _, _ := helperRun(t, "generate-signing-key", "-out", signingPath); code != 1 {
+		t.Errorf("generate-signing-key should not overwrite a key without -force")
+	}
+
+	tcns := &TCNsJSON{}
+	helperRunJSON(t, tcns, "tcns", "-rak", rakPath, "-from", "3", "-to", "8")
+	if tcns.RVK != rak.RVK || len(tcns.TCNs) != 6 || tcns.TCNs[0].Index != 3 || tcns.TCNs[5].Index != 8 {
+		t.Fatalf("unexpected TCNs %+v", tcns)
+	}
+
+	report := &ReportJSON{}
+	helperRunJSON(t, report, "create-report", "-rak", rakPath, "-j1", "3", "-j2", "8", "-memo-type", "1", "-memo", "positive test", "-out", reportPath)
+	inspected := &ReportJSON{}
+	helperRunJSON(t, inspected, "inspect-report", reportPath)
+	if *inspected != *report {
+		t.Errorf("inspect-report does not match create-report %+v %+v", inspected, report)
+	}
+	if !inspected.Valid || inspected.RVK != rak.RVK || inspected.J1 != 3 || inspected.J2 != 8 || inspected.Memo != "positive test" || inspected.MemoTypeName != "CovidWatch v1" {
+		t.Errorf("unexpected report %+v", inspected)
+	}
+	if code, out, _ := helperRun(t, "inspect-report", reportPath); code != 0 || !strings.Contains(out, "valid signature") {
+		t.Errorf("unexpected text output %v", out)
+	}
+
+	expanded := &TCNsJSON{}
+	helperRunJSON(t, expanded, "expand-report", reportPath)
+	if len(expanded.TCNs) != len(tcns.TCNs) {
+		t.Fatalf("unexpected expansion %+v", expanded)
+	}
+	for idx := range tcns.TCNs {
+		if expanded.TCNs[idx] != tcns.TCNs[idx] {
+			t.Errorf("the report should reveal %+v, got %+v", tcns.TCNs[idx], expanded.TCNs[idx])
+		}
+	}
+
+	//a tampered report is decoded but does not verify.
+	data, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data[66]++
+	if err := ioutil.WriteFile(reportPath, data, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	helperRunJSON(t, inspected, "inspect-report", reportPath)
+	if inspected.Valid || inspected.J2 != 9 {
+		t.Errorf("a tampered report should not verify %+v", inspected)
+	}
+	if code, _, _ := helperRun(t, "expand-report", reportPath); code != 1 {
+		t.Errorf("expand-report should reject an invalid signature")
+	}
+	if code, _, _ := helperRun(t, "expand-report", "-skip-verify", reportPath); code != 0 {
+		t.Errorf("expand-report -skip-verify should accept an invalid signature")
+	}
+
+	for _, args := range [][]string{
+		{"create-report", "-rak", rakPath, "-j1", "0", "-out", reportPath},
+		{"create-report", "-rak", rakPath, "-j1", "5", "-j2", "4", "-out", reportPath},
+		{"create-report", "-rak", rakPath, "-memo", "a", "-memo-hex", "00", "-out", reportPath},
+		{"create-report", "-rak", rakPath, "-memo", strings.Repeat("a", 256), "-out", reportPath},
+		{"create-report", "-rak", rakPath},
+		{"tcns", "-rak", reportPath},
+	} {
+		if code, _, _ := helperRun(t, args...); code != 1 {
+			t.Errorf("%v should fail", args)
+		}
+	}
+}
+
+func TestEncryptKey(t *testing.T) {
+	dir := helperTempDir(t)
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer tcnServer.Close()
+	key, err := tcnServer.GetPrivateKeyBytes()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyPath := filepath.Join(dir, "server.key")
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	passphrasePath := filepath.Join(dir, "passphrase")
+	if err := ioutil.WriteFile(passphrasePath, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	encryptedPath := filepath.Join(dir, "server.key.enc")
+	encrypted := &ServerKeyJSON{}
+	helperRunJSON(t, encrypted, "encrypt-key", "-in", keyPath, "-out", encryptedPath, "-passphrase-file", passphrasePath)
+	if encrypted.KeyID != tcnServer.KeyID().String() {
+		t.Errorf("unexpected key ID %v", encrypted.KeyID)
+	}
+	loaded, err := keystore.LoadServer(&keystore.EncryptedFile{Path: encryptedPath, Passphrase: keystore.PassphraseFile(passphrasePath)})
+	if err != nil {
+		t.Fatalf("Failed to load the encrypted key %v", err)
+	}
+	defer loaded.Close()
+	if loaded.KeyID() != tcnServer.KeyID() {
+		t.Errorf("the encrypted key should match the plaintext key")
+	}
+
+	os.Setenv(passphraseEnv, "")
+	defer os.Unsetenv(passphraseEnv)
+	for _, args := range [][]string{
+		{"encrypt-key", "-in", keyPath, "-out", encryptedPath, "-passphrase-file", passphrasePath},
+		{"encrypt-key", "-in", keyPath, "-out", filepath.Join(dir, "other.enc")},
+		{"encrypt-key", "-in", passphrasePath, "-out", filepath.Join(dir, "other.enc"), "-passphrase-file", passphrasePath},
+		{"encrypt-key", "-in", keyPath},
+	} {
+		if code, _, _ := helperRun(t, args...); code != 1 {
+			t.Errorf("%v should fail", args)
+		}
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	dir := helperTempDir(t)
+	sharesDir := filepath.Join(dir, "shares")
+	split := &SharesJSON{}
+	helperRunJSON(t, split, "split-key", "-out-dir", sharesDir, "-shares", "4", "-threshold", "2")
+	if len(split.Files) != 4 || split.Threshold != 2 || split.KeyID == "" {
+		t.Fatalf("unexpected shares %+v", split)
+	}
+	for _, path := range split.Files {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("the share should only be readable by its owner %v", err)
+		}
+	}
+
+	combined := &ServerKeyJSON{}
+	helperRunJSON(t, combined, "combine-key", split.Files[3], split.Files[1])
+	if combined.KeyID != split.KeyID || combined.File != "" {
+		t.Errorf("the shares should reconstruct the key %+v", combined)
+	}
+	passphrasePath := filepath.Join(dir, "passphrase")
+	if err := ioutil.WriteFile(passphrasePath, []byte("correct horse"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	encryptedPath := filepath.Join(dir, "server.key.enc")
+	helperRunJSON(t, combined, "combine-key", "-out", encryptedPath, "-passphrase-file", passphrasePath, split.Files[0], split.Files[2])
+	loaded, err := keystore.LoadServer(&keystore.EncryptedFile{Path: encryptedPath, Passphrase: keystore.PassphraseFile(passphrasePath)})
+	if err != nil {
+		t.Fatalf("Failed to load the combined key %v", err)
+	}
+	defer loaded.Close()
+	if loaded.KeyID().String() != split.KeyID {
+		t.Errorf("the encrypted key should match the split key")
+	}
+
+	if code, _, errOut := helperRun(t, "combine-key", split.Files[0]); code != 1 || !strings.Contains(errOut, "not enough shares") {
+		t.Errorf("a single share should not be a quorum %v", errOut)
+	}
+	if code, _, _ := helperRun(t, "combine-key"); code != 2 {
+		t.Errorf("combine-key without shares should fail with code 2")
+	}
+	if code, _, _ := helperRun(t, "split-key", "-out-dir", sharesDir, "-shares", "4", "-threshold", "2"); code != 1 {
+		t.Errorf("split-key should not overwrite shares")
+	}
+	if code, _, _ := helperRun(t, "split-key", "-out-dir", filepath.Join(dir, "other"), "-threshold", "1"); code != 1 {
+		t.Errorf("split-key should reject a threshold of 1")
+	}
+}
+
+func TestPSI(t *testing.T) {
+	dir := helperTempDir(t)
+	contacts := &bytes.Buffer{}
+	reports := []string{}
+	for idx := 0; idx < 4; idx++ {
+		rakPath := filepath.Join(dir, "rak"+string(rune('0'+idx)))
+		reportPath := rakPath + ".bin"
+		helperRunJSON(t, &RAKJSON{}, "generate-rak", "-out", rakPath)
+		//the text output of tcns is a valid input of psi.
+		code, out, errOut := helperRun(t, "tcns", "-rak", rakPath, "-from", "1", "-to", "10")
+		if code != 0 {
+			t.Fatalf("tcns failed %v", errOut)
+		}
+		contacts.WriteString(out)
+		if idx%2 == 0 {
+			helperRunJSON(t, &ReportJSON{}, "create-report", "-rak", rakPath, "-j1", "1", "-j2", "10", "-out", reportPath)
+			reports = append(reports, reportPath)
+		}
+	}
+	contactsPath := filepath.Join(dir, "contacts.txt")
+	if err := ioutil.WriteFile(contactsPath, append([]byte("# contacts\n\n"), contacts.Bytes()...), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result := &PSIJSON{}
+	helperRunJSON(t, result, append([]string{"psi", "-tcns", contactsPath, "-fpr", "1e-9"}, reports...)...)
+	if result.Contacts != 40 || result.Reports != 2 || result.TCNs != 20 || len(result.Rejected) != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result.IntersectionSize != 20 {
+		t.Errorf("Invalid intersection %v", result.IntersectionSize)
+	}
+	if code, _, _ := helperRun(t, "psi", "-tcns", contactsPath); code != 2 {
+		t.Errorf("psi without reports should fail with code 2")
+	}
+	if err := ioutil.WriteFile(contactsPath, []byte("1 abcd\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if code, _, errOut := helperRun(t, append([]string{"psi", "-tcns", contactsPath}, reports...)...); code != 1 || !strings.Contains(errOut, "line 1") {
+		t.Errorf("psi should reject an invalid TCN %v", errOut)
+	}
+}
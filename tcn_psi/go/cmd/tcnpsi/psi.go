@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/tcn"
+	"io"
+	"os"
+	"strings"
+)
+
+//RejectedJSON is a report left out of the setup message.
+type RejectedJSON struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+//PSIJSON is the output of psi.
+type PSIJSON struct {
+	Contacts int     `json:"contacts"`
+	Reports  int     `json:"reports"`
+	TCNs     int     `json:"tcns"`
+	FPR      float64 `json:"fpr"`
+	//SetupSize and RequestSize are the sizes of the encoded messages, in bytes.
+	SetupSize        int            `json:"setup_size"`
+	RequestSize      int            `json:"request_size"`
+	IntersectionSize int64          `json:"intersection_size"`
+	Rejected         []RejectedJSON `json:"rejected"`
+}
+
+func (r *PSIJSON) writeText(w io.Writer) {
+	fmt.Fprintf(w, "contacts          %v\n", r.Contacts)
+	fmt.Fprintf(w, "reports           %v (%v TCNs)\n", r.Reports, r.TCNs)
+	for _, rejected := range r.Rejected {
+		fmt.Fprintf(w, "rejected          %v: %v\n", rejected.File, rejected.Reason)
+	}
+	fmt.Fprintf(w, "setup size        %v bytes (fpr %v)\n", r.SetupSize, r.FPR)
+	fmt.Fprintf(w, "request size      %v bytes\n", r.RequestSize)
+	fmt.Fprintf(w, "intersection size %v\n", r.IntersectionSize)
+}
+
+//readTCNs reads hexadecimal TCNs, one per line. Only the last field of a line is read, so the
+//output of the tcns command can be used as is. Empty lines and lines starting with # are
+//skipped.
+func readTCNs(r io.Reader) ([]tcn.TemporaryContactNumber, error) {
+	tcns := []tcn.TemporaryContactNumber{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[len(fields)-1])
+		var value tcn.TemporaryContactNumber
+		if err != nil || len(raw) != len(value) {
+			return nil, fmt.Errorf("line %v: invalid TCN", line)
+		}
+		copy(value[:], raw)
+		tcns = append(tcns, value)
+	}
+	return tcns, scanner.Err()
+}
+
+func runPSI(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("psi", errOut)
+	path := fs.String("tcns", "", "file of hexadecimal TCNs, one per line, - for the standard input")
+	fpr := fs.Float64("fpr", 1e-6, "false-positive rate of the check")
+	if err := parseFlags(fs, args, 1, -1); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("a TCN file is required")
+	}
+	if *fpr <= 0 || *fpr >= 1 {
+		return errors.New("the false-positive rate must be in (0, 1)")
+	}
+
+	var input io.Reader = os.Stdin
+	if *path != "-" {
+		file, err := os.Open(*path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	contacts, err := readTCNs(input)
+	if err != nil {
+		return err
+	}
+	reports := []*tcn.SignedReport{}
+	for _, path := range fs.Args() {
+		report, _, err := readReport(path)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, report)
+	}
+
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		return err
+	}
+	defer tcnServer.Close()
+	setup, summary, err := tcnServer.CreateSetupMessageWithPolicy(*fpr, int64(len(contacts)), reports, &server.IngestionPolicy{})
+	if err != nil {
+		return err
+	}
+	tcnClient, err := client.Create()
+	if err != nil {
+		return err
+	}
+	defer tcnClient.Close()
+	request, err := tcnClient.CreateRequest(setup, contacts)
+	if err != nil {
+		return err
+	}
+	response, err := tcnServer.ProcessRequest(request)
+	if err != nil {
+		return err
+	}
+	cnt, err := tcnClient.GetIntersectionSize(setup, response)
+	if err != nil {
+		return err
+	}
+
+	setupData, err := setup.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	requestData, err := request.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	output := &PSIJSON{
+		Contacts:         len(contacts),
+		Reports:          summary.Accepted,
+		TCNs:             summary.TCNs,
+		FPR:              *fpr,
+		SetupSize:        len(setupData),
+		RequestSize:      len(requestData),
+		IntersectionSize: cnt,
+		Rejected:         []RejectedJSON{},
+	}
+	for _, rejected := range summary.Rejected {
+		output.Rejected = append(output.Rejected, RejectedJSON{
+			File:   fs.Arg(rejected.Index),
+			Reason: string(rejected.Reason),
+		})
+	}
+	return write(out, *asJSON, output)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/store"
+	"github.com/openmined/tcn-psi/tcn"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+//ReportJSON is the output of create-report and inspect-report.
+type ReportJSON struct {
+	ID       string `json:"id"`
+	RVK      string `json:"rvk"`
+	J1       uint16 `json:"j1"`
+	J2       uint16 `json:"j2"`
+	MemoType uint8  `json:"memo_type"`
+	//MemoTypeName is the name of the memo format, or "unknown".
+	MemoTypeName string `json:"memo_type_name"`
+	//Memo is the memo data as text, if it is valid UTF-8.
+	Memo      string `json:"memo,omitempty"`
+	MemoHex   string `json:"memo_hex"`
+	Signature string `json:"signature"`
+	//Valid is set if the signature verifies under the RVK.
+	Valid bool `json:"valid"`
+	Size  int  `json:"size"`
+}
+
+func (r *ReportJSON) writeText(w io.Writer) {
+	fmt.Fprintf(w, "id        %v\n", r.ID)
+	fmt.Fprintf(w, "rvk       %v\n", r.RVK)
+	fmt.Fprintf(w, "j1        %v\n", r.J1)
+	fmt.Fprintf(w, "j2        %v\n", r.J2)
+	fmt.Fprintf(w, "memo type %v (%v)\n", r.MemoType, r.MemoTypeName)
+	if r.Memo != "" {
+		fmt.Fprintf(w, "memo      %q\n", r.Memo)
+	}
+	fmt.Fprintf(w, "memo hex  %v\n", r.MemoHex)
+	fmt.Fprintf(w, "signature %v\n", r.Signature)
+	if r.Valid {
+		fmt.Fprintln(w, "status    valid signature")
+	} else {
+		fmt.Fprintln(w, "status    INVALID signature")
+	}
+}
+
+//memoTypeName returns the name of a memo format.
+func memoTypeName(memoType uint8) string {
+	switch memoType {
+	case tcn.CoEpiV1Code:
+		return "CoEpi v1"
+	case tcn.CovidWatchV1Code:
+		return "CovidWatch v1"
+	case tcn.ITOMemoCode:
+		return "ITO"
+	}
+	return "unknown"
+}
+
+//describeReport returns the description of report, serialized as data.
+func describeReport(report *tcn.SignedReport, data []byte) (*ReportJSON, error) {
+	id, err := store.ComputeReportID(report)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := report.Verify()
+	if err != nil {
+		return nil, err
+	}
+	output := &ReportJSON{
+		ID:           id.String(),
+		RVK:          hex.EncodeToString(report.RVK),
+		J1:           report.J1,
+		J2:           report.J2,
+		MemoType:     report.MemoType,
+		MemoTypeName: memoTypeName(report.MemoType),
+		MemoHex:      hex.EncodeToString(report.MemoData),
+		Signature:    hex.EncodeToString(report.Sig),
+		Valid:        valid,
+		Size:         len(data),
+	}
+	if utf8.Valid(report.MemoData) {
+		output.Memo = string(report.MemoData)
+	}
+	return output, nil
+}
+
+//readReport reads a serialized signed report from path.
+func readReport(path string) (*tcn.SignedReport, []byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	report, err := tcn.GetSignedReport(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if encoded, err := report.Bytes(); err != nil || len(encoded) != len(data) {
+		return nil, nil, fmt.Errorf("%v: trailing data after the signed report", path)
+	}
+	return report, data, nil
+}
+
+func createReport(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("create-report", errOut)
+	path := fs.String("rak", "", "report authorization key file")
+	j1 := fs.Uint("j1", 1, "ratchet index of the first reported TCN, at least 1")
+	j2 := fs.Uint("j2", 1, "ratchet index of the last reported TCN")
+	memoType := fs.Uint("memo-type", tcn.CoEpiV1Code, "memo format: 0 CoEpi, 1 CovidWatch, 2 ITO")
+	memo := fs.String("memo", "", "memo data, as text")
+	memoHex := fs.String("memo-hex", "", "memo data, hexadecimal encoded")
+	outPath := fs.String("out", "", "file to write the signed report to")
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+	switch {
+	case *outPath == "":
+		return errors.New("an output file is required")
+	case *j1 == 0 || *j1 > *j2 || *j2 >= math.MaxUint16:
+		return errors.New("the indices must satisfy 1 <= j1 <= j2 < 65535")
+	case *memoType > 255:
+		return errors.New("the memo type must be below 256")
+	case *memo != "" && *memoHex != "":
+		return errors.New("-memo and -memo-hex are exclusive")
+	}
+	memoData := []byte(*memo)
+	if *memoHex != "" {
+		var err error
+		if memoData, err = hex.DecodeString(*memoHex); err != nil {
+			return fmt.Errorf("invalid memo: %v", err)
+		}
+	}
+	if len(memoData) > 255 {
+		return errors.New("the memo must be shorter than 256 bytes")
+	}
+	rak, err := loadRAK(*path)
+	if err != nil {
+		return err
+	}
+
+	report, err := rak.CreateSignedReport(uint8(*memoType), memoData, uint16(*j1), uint16(*j2))
+	if err != nil {
+		return err
+	}
+	data, err := report.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*outPath, data, 0644); err != nil {
+		return err
+	}
+	output, err := describeReport(report, data)
+	if err != nil {
+		return err
+	}
+	return write(out, *asJSON, output)
+}
+
+func inspectReport(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("inspect-report", errOut)
+	if err := parseFlags(fs, args, 1, 1); err != nil {
+		return err
+	}
+	report, data, err := readReport(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	output, err := describeReport(report, data)
+	if err != nil {
+		return err
+	}
+	return write(out, *asJSON, output)
+}
+
+func expandReport(args []string, out, errOut io.Writer) error {
+	fs, asJSON := newFlagSet("expand-report", errOut)
+	skipVerify := fs.Bool("skip-verify", false, "expand reports with an invalid signature")
+	if err := parseFlags(fs, args, 1, 1); err != nil {
+		return err
+	}
+	report, _, err := readReport(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if valid, err := report.Verify(); err != nil || (!valid && !*skipVerify) {
+		return errors.New("invalid report signature")
+	}
+
+	tcns, err := report.TemporaryContactNumbers()
+	if err != nil {
+		return err
+	}
+	output := &TCNsJSON{RVK: hex.EncodeToString(report.RVK), TCNs: []TCNJSON{}}
+	for index, value := range tcns {
+		output.TCNs = append(output.TCNs, TCNJSON{Index: index, TCN: hex.EncodeToString(value[:])})
+	}
+	sort.Slice(output.TCNs, func(i, j int) bool {
+		return output.TCNs[i].Index < output.TCNs[j].Index
+	})
+	return write(out, *asJSON, output)
+}
@@ -0,0 +1,236 @@
+//Package dataset runs a TCN-PSI dataset: the store of its reports, the day shards publishing its
+//setup messages, the ingestion of new reports, the guard answering the queries and the REST
+//handler serving them. tcnpsi-server runs a single dataset, and the tenant package one per
+//tenant.
+package dataset
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"github.com/openmined/tcn-psi/ingest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/metrics"
+	"github.com/openmined/tcn-psi/rest"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/static"
+	"github.com/openmined/tcn-psi/store"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//Config configures a Dataset.
+type Config struct {
+	//Store is the file holding the submitted reports, created if missing.
+	Store string
+
+	FPR          float64
+	ClientInputs int64
+	Retention    int
+
+	MaxSpan   int
+	RateLimit float64
+	Burst     int
+
+	MinElements  int
+	MaxElements  int
+	ClientBudget int
+	GlobalBudget int
+
+	//SigningKey, if set, signs the setup messages.
+	SigningKey ed25519.PrivateKey
+	//ExportDir, if set, receives the setup messages as static files whenever they are published.
+	//It requires SigningKey.
+	ExportDir string
+}
+
+//Dataset serves the setup messages of the stored reports, answers the queries against them and
+//accepts new reports.
+//
+//A Dataset is safe for concurrent use by multiple goroutines.
+type Dataset struct {
+	config   Config
+	server   *server.TCNServer
+	store    *store.FileStore
+	shards   *server.DayShards
+	exporter *static.Exporter
+	ingest   *ingest.Service
+	queries  *server.QueryGuard
+	handler  http.Handler
+
+	mu    sync.Mutex
+	ready error
+}
+
+//Open starts the dataset described by config, which answers the queries with tcnServer and
+//closes it with the dataset. The dataset is not ready until Load added the stored reports.
+//
+//Returns an error if the configuration is invalid or if the store cannot be opened, after
+//closing tcnServer.
+func Open(tcnServer *server.TCNServer, config Config) (*Dataset, error) {
+	if config.ExportDir != "" && config.SigningKey == nil {
+		tcnServer.Close()
+		return nil, errors.New("the export directory requires a signing key")
+	}
+	reports, err := store.OpenFile(config.Store)
+	if err != nil {
+		tcnServer.Close()
+		return nil, err
+	}
+
+	registry := metrics.NewRegistry()
+	observer := metrics.NewServerMetrics(registry)
+	tcnServer.SetObserver(observer)
+	d := &Dataset{
+		config: config,
+		server: tcnServer,
+		store:  reports,
+		shards: server.NewDayShards(tcnServer, config.FPR, config.ClientInputs, config.Retention),
+		ready:  errors.New("loading reports"),
+	}
+	if config.SigningKey != nil {
+		err = d.shards.SetSigningKey(config.SigningKey)
+		if err == nil && config.ExportDir != "" {
+			d.exporter, err = static.NewExporter(config.ExportDir, config.SigningKey)
+		}
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	d.ingest = ingest.NewService(reports, ingest.Config{
+		Policy:    &server.IngestionPolicy{MaxSpan: config.MaxSpan},
+		RateLimit: config.RateLimit,
+		Burst:     config.Burst,
+		Sink:      d.shards,
+		Observer:  observer,
+	})
+	d.queries = server.NewQueryGuard(tcnServer, d.shards, server.QueryPolicy{
+		MinElements:  config.MinElements,
+		MaxElements:  config.MaxElements,
+		ClientBudget: config.ClientBudget,
+		GlobalBudget: config.GlobalBudget,
+	})
+	d.queries.SetObserver(observer)
+	d.handler, err = rest.NewHandler(rest.Config{
+		Shards:  d.shards,
+		Queries: d.queries,
+		Ingest:  d.ingest,
+		Metrics: registry,
+		Ready:   d.Ready,
+	})
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+//ServeHTTP serves the REST API of the rest package.
+func (d *Dataset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.handler.ServeHTTP(w, r)
+}
+
+//KeyID returns the identifier of the server key.
+func (d *Dataset) KeyID() message.KeyID {
+	return d.server.KeyID()
+}
+
+//SigningKey returns the public key authenticating the setup messages, or nil if they are not
+//signed.
+func (d *Dataset) SigningKey() ed25519.PublicKey {
+	if d.config.SigningKey == nil {
+		return nil
+	}
+	return d.config.SigningKey.Public().(ed25519.PublicKey)
+}
+
+//Manifest returns the published setup messages.
+func (d *Dataset) Manifest() ([]server.ShardInfo, error) {
+	return d.shards.Manifest()
+}
+
+//Ready returns nil once the dataset serves requests, and the reason why it does not otherwise.
+func (d *Dataset) Ready() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ready
+}
+
+func (d *Dataset) setReady(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ready = err
+}
+
+//retentionStart returns the start of the oldest day published.
+func (d *Dataset) retentionStart(now time.Time) time.Time {
+	today := now.UTC().Truncate(24 * time.Hour)
+	return today.Add(-time.Duration(d.config.Retention-1) * 24 * time.Hour)
+}
+
+//Load adds the stored reports of the retention window to the shards, publishes the setup
+//messages and marks the dataset ready. It returns the number of reports loaded.
+func (d *Dataset) Load() (int, error) {
+	now := time.Now()
+	loaded := 0
+	err := d.store.Iterate(d.retentionStart(now), now.Add(time.Minute), func(record *store.Record) error {
+		loaded++
+		return d.shards.AddAt(record.IngestedAt, record.Report)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := d.publish(); err != nil {
+		return 0, err
+	}
+	d.setReady(nil)
+	return loaded, nil
+}
+
+//publish rebuilds the changed setup messages and exports them if configured.
+func (d *Dataset) publish() error {
+	if d.exporter == nil {
+		_, err := d.shards.Manifest()
+		return err
+	}
+	_, err := d.exporter.ExportShards(d.shards)
+	return err
+}
+
+//Maintain deletes the reports older than the retention window, drops the expired shards and
+//their query budgets, rebuilds and exports the changed setup messages and releases the idle rate
+//limits. It is meant to be called periodically.
+func (d *Dataset) Maintain() error {
+	expired := []store.ReportID{}
+	err := d.store.Iterate(time.Unix(0, 0), d.retentionStart(time.Now()), func(record *store.Record) error {
+		expired = append(expired, record.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range expired {
+		if err := d.store.Delete(id); err != nil && err != store.ErrNotFound {
+			return err
+		}
+	}
+	if len(expired) > 0 {
+		if err := d.store.Compact(); err != nil {
+			return err
+		}
+	}
+	d.shards.Expire()
+	//the budgets of the expired setup messages are not needed anymore.
+	d.queries.Prune()
+	d.ingest.Prune()
+	return d.publish()
+}
+
+//Close releases the store and the server.
+func (d *Dataset) Close() error {
+	d.setReady(errors.New("shutting down"))
+	err := d.store.Close()
+	d.server.Close()
+	return err
+}
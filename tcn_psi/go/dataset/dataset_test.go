@@ -0,0 +1,129 @@
+package dataset
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/static"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func helperConfig(t *testing.T) Config {
+	dir, err := ioutil.TempDir("", "dataset")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return Config{
+		Store:        filepath.Join(dir, "reports.log"),
+		FPR:          0.001,
+		ClientInputs: 100,
+		Retention:    7,
+	}
+}
+
+func helperOpen(t *testing.T, config Config) *Dataset {
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	d, err := Open(tcnServer, config)
+	if err != nil {
+		t.Fatalf("Open failed %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestDataset(t *testing.T) {
+	config := helperConfig(t)
+	d := helperOpen(t, config)
+	if d.Ready() == nil {
+		t.Errorf("the dataset should not be ready before loading")
+	}
+	if loaded, err := d.Load(); err != nil || loaded != 0 {
+		t.Fatalf("Load failed %v %v", loaded, err)
+	}
+	if err := d.Ready(); err != nil {
+		t.Errorf("the dataset should be ready after loading %v", err)
+	}
+	if d.SigningKey() != nil {
+		t.Errorf("the setup messages should not be signed without a key")
+	}
+
+	reports, _ := reporttest.Serialized(t, 2)
+	for _, report := range reports {
+		if _, err := d.ingest.Submit("a", report); err != nil {
+			t.Fatalf("Submit failed %v", err)
+		}
+	}
+	if err := d.Maintain(); err != nil {
+		t.Fatalf("Maintain failed %v", err)
+	}
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/setup", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("the REST API should be served %v", rec.Code)
+	}
+	keyID := d.KeyID()
+	d.Close()
+
+	//the reports are reloaded from the store.
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	reopened, err := Open(tcnServer, config)
+	if err != nil {
+		t.Fatalf("Open failed %v", err)
+	}
+	defer reopened.Close()
+	if loaded, err := reopened.Load(); err != nil || loaded != len(reports) {
+		t.Fatalf("the reports should be reloaded %v %v", loaded, err)
+	}
+	if reopened.KeyID() == keyID {
+		t.Errorf("the dataset should use the key it was opened with")
+	}
+}
+
+func TestExport(t *testing.T) {
+	config := helperConfig(t)
+	config.ExportDir = filepath.Join(filepath.Dir(config.Store), "export")
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	if _, err := Open(tcnServer, config); err == nil {
+		t.Errorf("an export without signing key should be rejected")
+	}
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	config.SigningKey = private
+	d := helperOpen(t, config)
+	if !d.SigningKey().Equal(public) {
+		t.Errorf("unexpected signing key %x", d.SigningKey())
+	}
+	if _, err := d.Load(); err != nil {
+		t.Fatalf("Load failed %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(config.ExportDir, static.ManifestFile))
+	if err != nil {
+		t.Fatalf("the setup messages should be exported when loaded %v", err)
+	}
+	manifest := &static.ManifestJSON{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		t.Fatalf("invalid manifest %v", err)
+	}
+	if err := manifest.VerifySignature(public); err != nil {
+		t.Errorf("the manifest should be signed %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+//Package ingest accepts the reports submitted by users, and persists the valid ones so that they
+//are published in the next setup messages. Transports, like an HTTP or gRPC server, wrap a
+//Service.
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/store"
+	"github.com/openmined/tcn-psi/tcn"
+	"sync"
+	"time"
+)
+
+//ErrRateLimited is returned when a source submits reports faster than allowed.
+var ErrRateLimited = errors.New("too many reports submitted")
+
+//maxReportLength is the length of a signed report with the largest memo.
+const maxReportLength = tcn.SignedReportMinLength + 255
+
+//RejectedError is returned when a submitted report breaks the ingestion policy.
+type RejectedError struct {
+	Reason server.RejectionReason
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("report rejected: %v", e.Reason)
+}
+
+//Sink receives the accepted reports, for example a server.DayShards.
+type Sink interface {
+	AddAt(t time.Time, reports ...*tcn.SignedReport) error
+}
+
+//Config configures a Service.
+type Config struct {
+	//Policy checks every submitted report. Nil uses the zero policy.
+	Policy *server.IngestionPolicy
+	//RateLimit is the number of reports a source may submit per second, after a burst of Burst
+	//reports. Zero disables rate limiting. Burst defaults to 1.
+	RateLimit float64
+	Burst     int
+	//Sink, if set, receives every accepted report once it is stored. If the sink fails, the
+	//report is removed from the store so that the submission can be retried.
+	Sink Sink
+	//Observer, if set, is notified of every submission.
+	Observer Observer
+}
+
+//Receipt acknowledges a submitted report.
+type Receipt struct {
+	ID         store.ReportID
+	IngestedAt time.Time
+	//Duplicate is true if the report had already been submitted. IngestedAt is then the time of
+	//the first submission.
+	Duplicate bool
+}
+
+//Service validates, deduplicates and stores submitted reports.
+//
+//A Service is safe for concurrent use by multiple goroutines.
+type Service struct {
+	store    store.ReportStore
+	policy   *server.IngestionPolicy
+	limiter  *RateLimiter
+	sink     Sink
+	observer Observer
+	now      func() time.Time
+
+	//mu serializes the submissions so that concurrent duplicates are stored once.
+	mu sync.Mutex
+}
+
+//NewService returns a service storing the accepted reports in s.
+func NewService(s store.ReportStore, config Config) *Service {
+	service := &Service{
+		store:    s,
+		policy:   config.Policy,
+		sink:     config.Sink,
+		observer: config.Observer,
+		now:      time.Now,
+	}
+	if service.policy == nil {
+		service.policy = &server.IngestionPolicy{}
+	}
+	if config.RateLimit > 0 {
+		service.limiter = NewRateLimiter(config.RateLimit, config.Burst)
+	}
+	return service
+}
+
+//Submit ingests the serialized signed report data submitted by source. source identifies the
+//submitter for rate limiting, for example its IP address.
+//
+//Returns ErrRateLimited if source submits too fast, a *RejectedError if the report is invalid,
+//or the error of the store.
+func (s *Service) Submit(source string, data []byte) (*Receipt, error) {
+	receipt, err := s.submit(source, data)
+	if s.observer != nil {
+		s.observer.ReportSubmitted(receipt, err)
+	}
+	return receipt, err
+}
+
+func (s *Service) submit(source string, data []byte) (*Receipt, error) {
+	if s.limiter != nil && !s.limiter.Allow(source) {
+		return nil, ErrRateLimited
+	}
+	report, err := parse(data)
+	if err != nil {
+		return nil, &RejectedError{Reason: server.RejectedMalformed}
+	}
+	if _, summary := s.policy.Apply([]*tcn.SignedReport{report}); len(summary.Rejected) > 0 {
+		return nil, &RejectedError{Reason: summary.Rejected[0].Reason}
+	}
+
+	record, err := store.NewRecord(report, s.now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.store.Get(record.ID)
+	if err == nil {
+		return &Receipt{ID: existing.ID, IngestedAt: existing.IngestedAt, Duplicate: true}, nil
+	}
+	if err != store.ErrNotFound {
+		return nil, err
+	}
+	if err := s.store.Put(record); err != nil {
+		return nil, err
+	}
+	if s.sink != nil {
+		if err := s.sink.AddAt(record.IngestedAt, report); err != nil {
+			//a stored report would be a duplicate on retry, and never be published.
+			if derr := s.store.Delete(record.ID); derr != nil {
+				return nil, fmt.Errorf("%v, and the report could not be removed: %v", err, derr)
+			}
+			return nil, err
+		}
+	}
+	return &Receipt{ID: record.ID, IngestedAt: record.IngestedAt}, nil
+}
+
+//parse deserializes a signed report, which must span the whole of data.
+func parse(data []byte) (*tcn.SignedReport, error) {
+	if len(data) < tcn.SignedReportMinLength || len(data) > maxReportLength {
+		return nil, errors.New("invalid report length")
+	}
+	//the report keeps references to its input, which belongs to the caller.
+	report, err := tcn.GetSignedReport(append([]byte{}, data...))
+	if err != nil {
+		return nil, err
+	}
+	if tcn.SignedReportMinLength+len(report.MemoData) != len(data) {
+		return nil, errors.New("trailing data after the report")
+	}
+	return report, nil
+}
+
+//Reports returns the stored reports ingested in [from, to), oldest first, to build a setup
+//message.
+func (s *Service) Reports(from, to time.Time) ([]*tcn.SignedReport, error) {
+	records, err := s.store.List(from, to)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*tcn.SignedReport, len(records))
+	for idx, record := range records {
+		reports[idx] = record.Report
+	}
+	return reports, nil
+}
+
+//Prune releases the rate limiting state of the idle sources.
+func (s *Service) Prune() {
+	if s.limiter != nil {
+		s.limiter.Prune()
+	}
+}
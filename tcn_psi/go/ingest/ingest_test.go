@@ -0,0 +1,204 @@
+package ingest
+
+import (
+	"errors"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/store"
+	"github.com/openmined/tcn-psi/tcn"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	reports []*tcn.SignedReport
+	err     error
+}
+
+func (s *recordingSink) AddAt(t time.Time, reports ...*tcn.SignedReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.reports = append(s.reports, reports...)
+	return nil
+}
+
+func helperGetReportBytes(t *testing.T, memoType uint8) []byte {
+	rak, err := tcn.NewReportAuthorizationKey()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	report, err := rak.CreateSignedReport(memoType, []byte("symptoms"), 1, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, err := report.Bytes()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return data
+}
+
+func helperOpenStore(t *testing.T) *store.FileStore {
+	dir, err := ioutil.TempDir("", "ingest")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := store.OpenFile(filepath.Join(dir, "reports.log"))
+	if err != nil {
+		t.Fatalf("OpenFile failed %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestServiceSubmit(t *testing.T) {
+	sink := &recordingSink{}
+	service := NewService(helperOpenStore(t), Config{Sink: sink})
+
+	data := helperGetReportBytes(t, tcn.CoEpiV1Code)
+	receipt, err := service.Submit("a", data)
+	if err != nil || receipt.Duplicate {
+		t.Fatalf("Submit failed %v", err)
+	}
+	again, err := service.Submit("b", data)
+	if err != nil {
+		t.Fatalf("Submit of a duplicate failed %v", err)
+	}
+	if !again.Duplicate || again.ID != receipt.ID || !again.IngestedAt.Equal(receipt.IngestedAt) {
+		t.Errorf("the duplicate was not detected %v", again)
+	}
+	if len(sink.reports) != 1 {
+		t.Errorf("the sink should receive the report once, got %v", len(sink.reports))
+	}
+
+	reports, err := service.Reports(receipt.IngestedAt, receipt.IngestedAt.Add(time.Second))
+	if err != nil || len(reports) != 1 {
+		t.Errorf("Reports returned %v %v", reports, err)
+	}
+}
+
+func TestServiceSinkFailure(t *testing.T) {
+	sink := &recordingSink{err: errors.New("sink unavailable")}
+	service := NewService(helperOpenStore(t), Config{Sink: sink})
+
+	data := helperGetReportBytes(t, tcn.CoEpiV1Code)
+	if _, err := service.Submit("a", data); err != sink.err {
+		t.Fatalf("Submit should return the error of the sink, got %v", err)
+	}
+	now := time.Now()
+	if reports, err := service.Reports(now.Add(-time.Hour), now.Add(time.Hour)); err != nil || len(reports) != 0 {
+		t.Errorf("the report should be removed from the store %v %v", reports, err)
+	}
+
+	// the retry is not a duplicate, and publishes the report.
+	sink.err = nil
+	receipt, err := service.Submit("a", data)
+	if err != nil || receipt.Duplicate {
+		t.Fatalf("the retry should be accepted %+v %v", receipt, err)
+	}
+	if len(sink.reports) != 1 {
+		t.Errorf("the sink should receive the report, got %v", len(sink.reports))
+	}
+}
+
+func TestServiceReject(t *testing.T) {
+	policy := &server.IngestionPolicy{AllowedMemoTypes: []uint8{tcn.CoEpiV1Code}}
+	service := NewService(helperOpenStore(t), Config{Policy: policy})
+
+	data := helperGetReportBytes(t, tcn.CoEpiV1Code)
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 1
+	truncated := data[:len(data)-1]
+	trailing := append(append([]byte{}, data...), 0)
+
+	for _, test := range []struct {
+		data   []byte
+		reason server.RejectionReason
+	}{
+		{nil, server.RejectedMalformed},
+		{truncated, server.RejectedMalformed},
+		{trailing, server.RejectedMalformed},
+		{tampered, server.RejectedInvalidSignature},
+		{helperGetReportBytes(t, tcn.CovidWatchV1Code), server.RejectedMemoType},
+	} {
+		_, err := service.Submit("a", test.data)
+		rejected, ok := err.(*RejectedError)
+		if !ok || rejected.Reason != test.reason {
+			t.Errorf("expected rejection %v, got %v", test.reason, err)
+		}
+	}
+	if reports, _ := service.Reports(time.Time{}, time.Now().Add(time.Hour)); len(reports) != 0 {
+		t.Errorf("no report should be stored, got %v", len(reports))
+	}
+}
+
+func TestServiceRateLimit(t *testing.T) {
+	service := NewService(helperOpenStore(t), Config{RateLimit: 0.001, Burst: 2})
+	for idx := 0; idx < 2; idx++ {
+		if _, err := service.Submit("a", helperGetReportBytes(t, tcn.CoEpiV1Code)); err != nil {
+			t.Fatalf("Submit failed %v", err)
+		}
+	}
+	if _, err := service.Submit("a", helperGetReportBytes(t, tcn.CoEpiV1Code)); err != ErrRateLimited {
+		t.Errorf("Submit should be rate limited, got %v", err)
+	}
+	if _, err := service.Submit("b", helperGetReportBytes(t, tcn.CoEpiV1Code)); err != nil {
+		t.Errorf("Submit from another source failed %v", err)
+	}
+
+	// without a burst, a single report is allowed at once.
+	service = NewService(helperOpenStore(t), Config{RateLimit: 0.001})
+	if _, err := service.Submit("a", helperGetReportBytes(t, tcn.CoEpiV1Code)); err != nil {
+		t.Fatalf("Submit without a burst failed %v", err)
+	}
+	if _, err := service.Submit("a", helperGetReportBytes(t, tcn.CoEpiV1Code)); err != ErrRateLimited {
+		t.Errorf("Submit should be rate limited, got %v", err)
+	}
+}
+
+func TestServiceConcurrentDuplicates(t *testing.T) {
+	sink := &recordingSink{}
+	service := NewService(helperOpenStore(t), Config{Sink: sink})
+	data := helperGetReportBytes(t, tcn.CoEpiV1Code)
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.Submit("a", data); err != nil {
+				t.Errorf("Submit failed %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(sink.reports) != 1 {
+		t.Errorf("the report should be stored once, got %v", len(sink.reports))
+	}
+}
+
+func TestServiceFeedsDayShards(t *testing.T) {
+	builder, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer builder.Close()
+	shards := server.NewDayShards(builder, 0.001, 100, 7)
+	service := NewService(helperOpenStore(t), Config{Sink: shards})
+
+	if _, err := service.Submit("a", helperGetReportBytes(t, tcn.CoEpiV1Code)); err != nil {
+		t.Fatalf("Submit failed %v", err)
+	}
+	manifest, err := shards.Manifest()
+	if err != nil || len(manifest) != 1 || manifest[0].Reports != 1 {
+		t.Errorf("the report was not added to the shards %v %v", manifest, err)
+	}
+}
@@ -0,0 +1,9 @@
+package ingest
+
+//Observer is notified of the submitted reports, for example to export metrics. Its methods are
+//called synchronously and must be safe for concurrent use.
+type Observer interface {
+	//ReportSubmitted is called once a submission was handled, with its receipt if the report
+	//was accepted and the error returned to the submitter otherwise.
+	ReportSubmitted(receipt *Receipt, err error)
+}
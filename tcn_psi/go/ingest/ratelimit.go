@@ -0,0 +1,82 @@
+package ingest
+
+import (
+	"sync"
+	"time"
+)
+
+//bucket is the token bucket of a source.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+//RateLimiter limits the rate of the submissions of every source with a token bucket: a source
+//may submit burst reports at once, then rate reports per second.
+//
+//A RateLimiter is safe for concurrent use by multiple goroutines.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+//NewRateLimiter returns a limiter allowing rate submissions per second and bursts of burst
+//submissions for every source. A burst below 1 is raised to 1, as a smaller bucket never holds a
+//whole token.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+//Allow reports whether source may submit a report now, and consumes a token if it may.
+func (l *RateLimiter) Allow(source string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[source]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[source] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+//Prune forgets the sources whose bucket is full again, and returns how many were forgotten.
+//Call it periodically to bound the memory used by the limiter.
+func (l *RateLimiter) Prune() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	pruned := 0
+	for source, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, source)
+			pruned++
+		}
+	}
+	return pruned
+}
@@ -0,0 +1,42 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(1, 2)
+	limiter.now = func() time.Time { return now }
+
+	if !limiter.Allow("a") || !limiter.Allow("a") {
+		t.Errorf("the burst should be allowed")
+	}
+	if limiter.Allow("a") {
+		t.Errorf("the submission after the burst should be limited")
+	}
+	if !limiter.Allow("b") {
+		t.Errorf("the sources should be limited independently")
+	}
+
+	now = now.Add(time.Second)
+	if !limiter.Allow("a") {
+		t.Errorf("a token should be refilled after a second")
+	}
+	if limiter.Allow("a") {
+		t.Errorf("a single token should be refilled after a second")
+	}
+
+	// the bucket of b is full again, the one of a is empty.
+	if pruned := limiter.Prune(); pruned != 1 {
+		t.Errorf("only the idle source should be pruned, pruned %v", pruned)
+	}
+	now = now.Add(time.Minute)
+	if pruned := limiter.Prune(); pruned != 1 {
+		t.Errorf("the idle source should be pruned, pruned %v", pruned)
+	}
+	if !limiter.Allow("a") || !limiter.Allow("a") {
+		t.Errorf("a pruned source should get a full burst")
+	}
+}
@@ -0,0 +1,66 @@
+//Package reporttest generates the signed reports used by the tests of the other packages.
+package reporttest
+
+import (
+	"github.com/openmined/tcn-psi/tcn"
+	"testing"
+)
+
+//TCNsPerReport is the number of TCNs revealed by every generated report.
+const TCNsPerReport = 10
+
+//Report returns a signed report of a new report authorization key, along with the TCNs it
+//reveals.
+func Report(t testing.TB) (*tcn.SignedReport, []tcn.TemporaryContactNumber) {
+	rak, err := tcn.NewReportAuthorizationKey()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tck, err := rak.InitialTCK()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tcns := []tcn.TemporaryContactNumber{}
+	for idx := 0; idx < TCNsPerReport; idx++ {
+		val, err := tck.TemporaryContactNumber()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		tcns = append(tcns, *val)
+		tck, err = tck.Ratchet()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	report, err := rak.CreateSignedReport(tcn.CoEpiV1Code, []byte{}, 1, TCNsPerReport)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return report, tcns
+}
+
+//Reports returns cnt reports generated by Report, along with all the TCNs they reveal.
+func Reports(t testing.TB, cnt int) ([]*tcn.SignedReport, []tcn.TemporaryContactNumber) {
+	reports := []*tcn.SignedReport{}
+	tcns := []tcn.TemporaryContactNumber{}
+	for idx := 0; idx < cnt; idx++ {
+		report, revealed := Report(t)
+		reports = append(reports, report)
+		tcns = append(tcns, revealed...)
+	}
+	return reports, tcns
+}
+
+//Serialized is like Reports, but returns the serialized reports, as submitted by users.
+func Serialized(t testing.TB, cnt int) ([][]byte, []tcn.TemporaryContactNumber) {
+	reports, tcns := Reports(t, cnt)
+	serialized := make([][]byte, len(reports))
+	for idx, report := range reports {
+		data, err := report.Bytes()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		serialized[idx] = data
+	}
+	return serialized, tcns
+}
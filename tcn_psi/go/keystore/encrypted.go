@@ -0,0 +1,236 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/server"
+	"io"
+	"os"
+)
+
+//DefaultIterations is the number of PBKDF2 iterations deriving the encryption key from the
+//passphrase of a new key file.
+const DefaultIterations = 600000
+
+//maxIterations bounds the work required to open a key file.
+const maxIterations = 100000000
+
+//formatVersion is the version of the encrypted key file format.
+const formatVersion = 1
+
+//iterations is the number of iterations used by Encrypt, lowered by the tests.
+var iterations = DefaultIterations
+
+//EncryptedKeyJSON is the content of a passphrase-encrypted key file. The key is encrypted with
+//AES-256-GCM under a key derived from the passphrase with PBKDF2-HMAC-SHA256.
+type EncryptedKeyJSON struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+//pbkdf2 derives a key of keyLen bytes from password as defined by RFC 8018, with HMAC-SHA256.
+func pbkdf2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	derived := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	counter := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter, uint32(block))
+		prf.Write(counter)
+		derived = prf.Sum(derived)
+		t := derived[len(derived)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for idx := range u {
+				t[idx] ^= u[idx]
+			}
+		}
+	}
+	Zero(u)
+	return derived[:keyLen]
+}
+
+//newAEAD returns the cipher of a key file.
+func newAEAD(passphrase, salt []byte, iter int) (cipher.AEAD, error) {
+	derived := pbkdf2(passphrase, salt, iter, 32)
+	defer Zero(derived)
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//Encrypt returns the content of a key file holding key, encrypted with passphrase.
+//
+//Returns an error if the passphrase is empty or if the encryption fails.
+func Encrypt(key, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	file := &EncryptedKeyJSON{
+		Version:    formatVersion,
+		KDF:        "pbkdf2-sha256",
+		Iterations: iterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := io.ReadFull(rand.Reader, file.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, file.Nonce); err != nil {
+		return nil, err
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, key, nil)
+	return json.MarshalIndent(file, "", "  ")
+}
+
+//Decrypt returns the key held by the content of a key file.
+//
+//Returns an error if the file is malformed, or if the passphrase is wrong or the file was
+//modified.
+func Decrypt(data, passphrase []byte) ([]byte, error) {
+	file := &EncryptedKeyJSON{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key file: %v", err)
+	}
+	switch {
+	case file.Version != formatVersion:
+		return nil, fmt.Errorf("unsupported encrypted key file version %d", file.Version)
+	case file.KDF != "pbkdf2-sha256":
+		return nil, fmt.Errorf("unsupported key derivation function %q", file.KDF)
+	case file.Iterations <= 0 || file.Iterations > maxIterations:
+		return nil, errors.New("invalid number of iterations")
+	case len(file.Salt) == 0:
+		return nil, errors.New("missing salt")
+	}
+	aead, err := newAEAD(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	key, err := aead.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted key file")
+	}
+	return key, nil
+}
+
+//Passphrase supplies the passphrase of an encrypted key file. The caller zeroes the returned
+//passphrase once used.
+type Passphrase func() ([]byte, error)
+
+//PassphraseEnv reads the passphrase from an environment variable.
+func PassphraseEnv(name string) Passphrase {
+	return func() ([]byte, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("environment variable %v not set", name)
+		}
+		return []byte(value), nil
+	}
+}
+
+//PassphraseFile reads the passphrase from a file only readable by its owner. A trailing newline
+//is ignored.
+func PassphraseFile(path string) Passphrase {
+	return func() ([]byte, error) {
+		data, err := readSecretFile(path)
+		if err != nil {
+			return nil, err
+		}
+		passphrase := append([]byte{}, bytes.TrimRight(data, "\r\n")...)
+		Zero(data)
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("empty passphrase file %v", path)
+		}
+		return passphrase, nil
+	}
+}
+
+//EncryptedFile provides the key stored in a file encrypted with a passphrase, see Encrypt.
+type EncryptedFile struct {
+	Path       string
+	Passphrase Passphrase
+}
+
+//Key decrypts the key.
+//
+//Returns an error if the file cannot be read or may be modified by other users, if the
+//passphrase is unavailable or wrong, or if the file is malformed.
+func (f *EncryptedFile) Key() ([]byte, error) {
+	if f.Passphrase == nil {
+		return nil, errors.New("no passphrase source")
+	}
+	data, err := readSecretFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := f.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	defer Zero(passphrase)
+	return Decrypt(data, passphrase)
+}
+
+//WriteEncryptedFile encrypts key with passphrase and writes it to a new file readable by its
+//owner only.
+//
+//Returns an error if the file exists or cannot be written.
+func WriteEncryptedFile(path string, key, passphrase []byte) error {
+	data, err := Encrypt(key, passphrase)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+//SaveServer writes the key of tcnServer to a new file encrypted with passphrase, zeroing the
+//plaintext key once encrypted.
+//
+//Returns an error if the key cannot be exported or if the file cannot be written.
+func SaveServer(tcnServer *server.TCNServer, path string, passphrase []byte) error {
+	key, err := tcnServer.GetPrivateKeyBytes()
+	if err != nil {
+		return err
+	}
+	defer Zero(key)
+	return WriteEncryptedFile(path, key, passphrase)
+}
@@ -0,0 +1,125 @@
+package keystore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	//keep the tests fast, the iterations are read back from the files.
+	iterations = 1000
+	os.Exit(m.Run())
+}
+
+func TestPBKDF2(t *testing.T) {
+	for _, vector := range []struct {
+		iter     int
+		expected string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	} {
+		derived := pbkdf2([]byte("password"), []byte("salt"), vector.iter, 32)
+		if hex.EncodeToString(derived) != vector.expected {
+			t.Errorf("invalid key for %v iterations %x", vector.iter, derived)
+		}
+	}
+	if derived := pbkdf2([]byte("password"), []byte("salt"), 1, 40); len(derived) != 40 {
+		t.Errorf("invalid key length %v", len(derived))
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	key := []byte("server private key")
+	data, err := Encrypt(key, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Encrypt failed %v", err)
+	}
+	if decrypted, err := Decrypt(data, []byte("correct horse")); err != nil || string(decrypted) != string(key) {
+		t.Errorf("Decrypt failed %v", err)
+	}
+	if _, err := Decrypt(data, []byte("wrong horse")); err == nil {
+		t.Errorf("a wrong passphrase should be rejected")
+	}
+	if _, err := Encrypt(key, nil); err == nil {
+		t.Errorf("an empty passphrase should be rejected")
+	}
+
+	for _, change := range []func(f *EncryptedKeyJSON){
+		func(f *EncryptedKeyJSON) { f.Ciphertext[0] ^= 1 },
+		func(f *EncryptedKeyJSON) { f.Salt[0] ^= 1 },
+		func(f *EncryptedKeyJSON) { f.Iterations++ },
+		func(f *EncryptedKeyJSON) { f.Iterations = 0 },
+		func(f *EncryptedKeyJSON) { f.Nonce = f.Nonce[1:] },
+		func(f *EncryptedKeyJSON) { f.Version = 2 },
+		func(f *EncryptedKeyJSON) { f.KDF = "md5" },
+	} {
+		file := &EncryptedKeyJSON{}
+		if err := json.Unmarshal(data, file); err != nil {
+			t.Fatal(err.Error())
+		}
+		change(file)
+		modified, _ := json.Marshal(file)
+		if _, err := Decrypt(modified, []byte("correct horse")); err == nil {
+			t.Errorf("a modified file should be rejected %+v", file)
+		}
+	}
+	if _, err := Decrypt([]byte("{"), []byte("correct horse")); err == nil {
+		t.Errorf("a malformed file should be rejected")
+	}
+}
+
+func TestEncryptedFile(t *testing.T) {
+	tcnServer, _ := helperServerKey(t)
+	dir := helperTempDir(t)
+	path := filepath.Join(dir, "server.key.enc")
+	passphrasePath := filepath.Join(dir, "passphrase")
+	if err := ioutil.WriteFile(passphrasePath, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := SaveServer(tcnServer, path, []byte("correct horse")); err != nil {
+		t.Fatalf("SaveServer failed %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the key file should only be readable by its owner %v", err)
+	}
+	if err := SaveServer(tcnServer, path, []byte("correct horse")); err == nil {
+		t.Errorf("an existing key file should not be overwritten")
+	}
+
+	loaded, err := LoadServer(&EncryptedFile{Path: path, Passphrase: PassphraseFile(passphrasePath)})
+	if err != nil {
+		t.Fatalf("LoadServer failed %v", err)
+	}
+	defer loaded.Close()
+	if loaded.KeyID() != tcnServer.KeyID() {
+		t.Errorf("the key was not loaded")
+	}
+
+	const name = "TCNPSI_KEYSTORE_TEST_PASSPHRASE"
+	os.Setenv(name, "wrong horse")
+	defer os.Unsetenv(name)
+	if _, err := (&EncryptedFile{Path: path, Passphrase: PassphraseEnv(name)}).Key(); err == nil {
+		t.Errorf("a wrong passphrase should be rejected")
+	}
+	os.Setenv(name, "correct horse")
+	if _, err := (&EncryptedFile{Path: path, Passphrase: PassphraseEnv(name)}).Key(); err != nil {
+		t.Errorf("the passphrase should be read from the environment %v", err)
+	}
+	if _, err := (&EncryptedFile{Path: path}).Key(); err == nil {
+		t.Errorf("a missing passphrase source should be rejected")
+	}
+	if _, err := PassphraseEnv("TCNPSI_KEYSTORE_TEST_MISSING")(); err == nil {
+		t.Errorf("a missing variable should be rejected")
+	}
+	os.Chmod(passphrasePath, 0644)
+	if _, err := PassphraseFile(passphrasePath)(); err == nil {
+		t.Errorf("a passphrase file readable by other users should be rejected")
+	}
+}
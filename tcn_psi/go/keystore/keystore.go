@@ -0,0 +1,125 @@
+//Package keystore loads and stores the private key of a TCN-PSI server without leaving it in
+//plaintext on disk or in memory longer than needed.
+//
+//A Provider supplies the key: from a passphrase-encrypted file, from an environment variable,
+//from a file only readable by its owner, e.g. a secret mounted by the orchestrator, or from a
+//quorum of Shamir shares held by distinct operators. The key buffers are zeroed once the server
+//is created.
+package keystore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/server"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+)
+
+//Provider supplies the private key of a server.
+type Provider interface {
+	//Key returns a new copy of the key, which the caller zeroes once used.
+	Key() ([]byte, error)
+}
+
+//Zero overwrites data with zeros.
+func Zero(data []byte) {
+	for idx := range data {
+		data[idx] = 0
+	}
+}
+
+//DecodeHex decodes the hexadecimal key held by data, ignoring the surrounding whitespace. Unlike
+//hex.DecodeString, it makes no string copy of data, which Zero could not clear.
+func DecodeHex(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	key := make([]byte, hex.DecodedLen(len(trimmed)))
+	n, err := hex.Decode(key, trimmed)
+	if err != nil {
+		Zero(key)
+		return nil, err
+	}
+	return key[:n], nil
+}
+
+//CheckPermissions returns an error if path is not a regular file or if other users than its
+//owner may access it. The check is skipped on Windows, which has no permission bits.
+func CheckPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%v is not a regular file", path)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%v is accessible by other users (mode %v), expected 0600 or stricter", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+//readSecretFile reads path after checking its permissions.
+func readSecretFile(path string) ([]byte, error) {
+	if err := CheckPermissions(path); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+//File provides the key stored in plaintext in a file only readable by its owner, e.g. a secret
+//mounted by the orchestrator on a memory file system.
+type File struct {
+	Path string
+}
+
+//Key reads the key.
+//
+//Returns an error if the file cannot be read or may be accessed by other users.
+func (f *File) Key() ([]byte, error) {
+	key, err := readSecretFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("empty key file %v", f.Path)
+	}
+	return key, nil
+}
+
+//Env provides the hexadecimal key held by an environment variable.
+type Env struct {
+	Name string
+}
+
+//Key decodes the key.
+//
+//Returns an error if the variable is not set or does not hold a hexadecimal key.
+func (e *Env) Key() ([]byte, error) {
+	value, ok := os.LookupEnv(e.Name)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("environment variable %v not set", e.Name)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %v does not hold a hexadecimal key", e.Name)
+	}
+	return key, nil
+}
+
+//LoadServer creates a server with the key supplied by provider, then zeroes the key.
+//
+//Returns an error if the key cannot be obtained or is not a valid server key.
+func LoadServer(provider Provider) (*server.TCNServer, error) {
+	if provider == nil {
+		return nil, errors.New("invalid key provider")
+	}
+	key, err := provider.Key()
+	if err != nil {
+		return nil, err
+	}
+	defer Zero(key)
+	return server.CreateFromKey(key)
+}
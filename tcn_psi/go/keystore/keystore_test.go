@@ -0,0 +1,139 @@
+package keystore
+
+import (
+	"encoding/hex"
+	"github.com/openmined/tcn-psi/server"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func helperTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func helperServerKey(t *testing.T) (*server.TCNServer, []byte) {
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	t.Cleanup(func() { tcnServer.Close() })
+	key, err := tcnServer.GetPrivateKeyBytes()
+	if err != nil {
+		t.Fatalf("Failed to get the server key %v", err)
+	}
+	return tcnServer, key
+}
+
+func TestZero(t *testing.T) {
+	data := []byte("secret")
+	Zero(data)
+	for _, b := range data {
+		if b != 0 {
+			t.Fatalf("the buffer was not zeroed %v", data)
+		}
+	}
+}
+
+func TestDecodeHex(t *testing.T) {
+	key, err := DecodeHex([]byte(" 00ff10\r\n"))
+	if err != nil || hex.EncodeToString(key) != "00ff10" {
+		t.Errorf("DecodeHex failed %x %v", key, err)
+	}
+	for _, data := range []string{"0", "zz", "00 ff"} {
+		if _, err := DecodeHex([]byte(data)); err == nil {
+			t.Errorf("DecodeHex should fail for %q", data)
+		}
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no permission bits on Windows")
+	}
+	dir := helperTempDir(t)
+	path := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(path, []byte("key"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := CheckPermissions(path); err != nil {
+		t.Errorf("a private file should be accepted %v", err)
+	}
+	for _, mode := range []os.FileMode{0640, 0604, 0666} {
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := CheckPermissions(path); err == nil {
+			t.Errorf("mode %v should be rejected", mode)
+		}
+	}
+	if err := CheckPermissions(dir); err == nil {
+		t.Errorf("a directory should be rejected")
+	}
+	if err := CheckPermissions(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("a missing file should be rejected")
+	}
+}
+
+func TestFile(t *testing.T) {
+	tcnServer, key := helperServerKey(t)
+	dir := helperTempDir(t)
+	path := filepath.Join(dir, "server.key")
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	loaded, err := LoadServer(&File{Path: path})
+	if err != nil {
+		t.Fatalf("LoadServer failed %v", err)
+	}
+	defer loaded.Close()
+	if loaded.KeyID() != tcnServer.KeyID() {
+		t.Errorf("the key was not loaded")
+	}
+
+	if runtime.GOOS != "windows" {
+		os.Chmod(path, 0644)
+		if _, err := (&File{Path: path}).Key(); err == nil {
+			t.Errorf("a key readable by other users should be rejected")
+		}
+	}
+	empty := filepath.Join(dir, "empty.key")
+	ioutil.WriteFile(empty, nil, 0600)
+	if _, err := (&File{Path: empty}).Key(); err == nil {
+		t.Errorf("an empty key should be rejected")
+	}
+	if _, err := LoadServer(nil); err == nil {
+		t.Errorf("a nil provider should be rejected")
+	}
+}
+
+func TestEnv(t *testing.T) {
+	tcnServer, key := helperServerKey(t)
+	const name = "TCNPSI_KEYSTORE_TEST_KEY"
+	os.Setenv(name, hex.EncodeToString(key)+"\n")
+	defer os.Unsetenv(name)
+	loaded, err := LoadServer(&Env{Name: name})
+	if err != nil {
+		t.Fatalf("LoadServer failed %v", err)
+	}
+	defer loaded.Close()
+	if loaded.KeyID() != tcnServer.KeyID() {
+		t.Errorf("the key was not loaded")
+	}
+
+	os.Setenv(name, "not hex")
+	if _, err := (&Env{Name: name}).Key(); err == nil {
+		t.Errorf("an invalid key should be rejected")
+	}
+	os.Unsetenv(name)
+	if _, err := (&Env{Name: name}).Key(); err == nil {
+		t.Errorf("a missing variable should be rejected")
+	}
+}
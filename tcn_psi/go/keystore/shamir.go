@@ -0,0 +1,260 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/server"
+	"io"
+	"os"
+)
+
+//shareVersion is the version of the share file format.
+const shareVersion = 1
+
+//shareDomainSep separates the digest of a split key from other uses of SHA-256.
+const shareDomainSep = "TCN-PSI key share"
+
+//ErrNoQuorum is returned when fewer shares than the threshold are combined.
+var ErrNoQuorum = errors.New("not enough shares to reconstruct the key")
+
+//Share is one of the Shamir shares of a key, handed to one operator. Any threshold shares of the
+//same split reconstruct the key, while fewer reveal nothing about it.
+type Share struct {
+	Version int `json:"version"`
+	//SetID identifies the split, so that shares of different splits are not mixed.
+	SetID []byte `json:"set_id"`
+	//Threshold is the number of shares required to reconstruct the key, out of Total.
+	Threshold int `json:"threshold"`
+	Total     int `json:"total"`
+	//Index is the evaluation point of the share, from 1 to Total.
+	Index int `json:"index"`
+	//KeyID is the ID of the split server key, informational only.
+	KeyID string `json:"key_id,omitempty"`
+	//Digest authenticates the reconstructed key.
+	Digest []byte `json:"digest"`
+	Data   []byte `json:"data"`
+}
+
+//gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x + 1, in constant time.
+func gfMul(a, b byte) byte {
+	var p byte
+	for bit := 0; bit < 8; bit++ {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+//gfInv returns the multiplicative inverse of a non-zero element, as a^254.
+func gfInv(a byte) byte {
+	inv := byte(1)
+	for exp := 254; exp > 0; exp >>= 1 {
+		if exp&1 == 1 {
+			inv = gfMul(inv, a)
+		}
+		a = gfMul(a, a)
+	}
+	return inv
+}
+
+//shareDigest returns the digest authenticating the key of a split.
+func shareDigest(setID, key []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(shareDomainSep))
+	h.Write(setID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+//Split splits key into total shares, any threshold of which reconstruct it with Combine.
+//
+//Returns an error if the key is empty, or unless 2 <= threshold <= total <= 255.
+func Split(key []byte, total, threshold int) ([]*Share, error) {
+	switch {
+	case len(key) == 0:
+		return nil, errors.New("empty key")
+	case threshold < 2:
+		return nil, errors.New("the threshold must be at least 2")
+	case total < threshold || total > 255:
+		return nil, errors.New("the number of shares must be between the threshold and 255")
+	}
+	setID := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, setID); err != nil {
+		return nil, err
+	}
+	digest := shareDigest(setID, key)
+	shares := make([]*Share, total)
+	for idx := range shares {
+		shares[idx] = &Share{
+			Version:   shareVersion,
+			SetID:     setID,
+			Threshold: threshold,
+			Total:     total,
+			Index:     idx + 1,
+			Digest:    digest,
+			Data:      make([]byte, len(key)),
+		}
+	}
+
+	//every byte of the key is the constant term of a random polynomial of degree threshold-1.
+	coefficients := make([]byte, threshold-1)
+	defer Zero(coefficients)
+	for pos, secret := range key {
+		if _, err := io.ReadFull(rand.Reader, coefficients); err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			x := byte(share.Index)
+			y := byte(0)
+			for deg := len(coefficients) - 1; deg >= 0; deg-- {
+				y = gfMul(y, x) ^ coefficients[deg]
+			}
+			share.Data[pos] = gfMul(y, x) ^ secret
+		}
+	}
+	return shares, nil
+}
+
+//Combine reconstructs the key from shares of the same split.
+//
+//Returns ErrNoQuorum if fewer shares than the threshold are provided, or an error if the shares
+//come from different splits or if a share is corrupted.
+func Combine(shares []*Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrNoQuorum
+	}
+	first := shares[0]
+	if first == nil || first.Version != shareVersion {
+		return nil, errors.New("unsupported share version")
+	}
+	seen := map[int]bool{}
+	for _, share := range shares {
+		switch {
+		case share == nil || share.Version != first.Version:
+			return nil, errors.New("unsupported share version")
+		case subtle.ConstantTimeCompare(share.SetID, first.SetID) != 1 || share.Threshold != first.Threshold ||
+			subtle.ConstantTimeCompare(share.Digest, first.Digest) != 1:
+			return nil, errors.New("the shares belong to different splits")
+		case share.Index < 1 || share.Index > 255 || len(share.Data) != len(first.Data) || len(share.Data) == 0:
+			return nil, fmt.Errorf("invalid share %d", share.Index)
+		case seen[share.Index]:
+			return nil, fmt.Errorf("duplicate share %d", share.Index)
+		}
+		seen[share.Index] = true
+	}
+	if len(shares) < first.Threshold || first.Threshold < 2 {
+		return nil, ErrNoQuorum
+	}
+
+	//Lagrange interpolation at 0, using every share so that a corrupted one is detected.
+	key := make([]byte, len(first.Data))
+	for i, share := range shares {
+		xi := byte(share.Index)
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				xj := byte(other.Index)
+				basis = gfMul(basis, gfMul(xj, gfInv(xj^xi)))
+			}
+		}
+		for pos, y := range share.Data {
+			key[pos] ^= gfMul(y, basis)
+		}
+	}
+	if subtle.ConstantTimeCompare(shareDigest(first.SetID, key), first.Digest) != 1 {
+		Zero(key)
+		return nil, errors.New("the shares do not reconstruct the key, a share is corrupted")
+	}
+	return key, nil
+}
+
+//SplitServer splits the key of tcnServer into total shares, any threshold of which reconstruct
+//it. The plaintext key is zeroed once split.
+//
+//Returns an error if the key cannot be exported or if the parameters are invalid, see Split.
+func SplitServer(tcnServer *server.TCNServer, total, threshold int) ([]*Share, error) {
+	key, err := tcnServer.GetPrivateKeyBytes()
+	if err != nil {
+		return nil, err
+	}
+	defer Zero(key)
+	shares, err := Split(key, total, threshold)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		share.KeyID = tcnServer.KeyID().String()
+	}
+	return shares, nil
+}
+
+//WriteShareFile writes share to a new file readable by its owner only.
+//
+//Returns an error if the file exists or cannot be written.
+func WriteShareFile(path string, share *Share) error {
+	data, err := json.MarshalIndent(share, "", "  ")
+	if err != nil {
+		return err
+	}
+	defer Zero(data)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+//ReadShareFile reads a share written by WriteShareFile.
+//
+//Returns an error if the file cannot be read, may be accessed by other users or is malformed.
+func ReadShareFile(path string) (*Share, error) {
+	data, err := readSecretFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer Zero(data)
+	share := &Share{}
+	if err := json.Unmarshal(data, share); err != nil {
+		return nil, fmt.Errorf("invalid share file %v: %v", path, err)
+	}
+	return share, nil
+}
+
+//ShareFiles provides the key reconstructed from a quorum of share files, e.g. mounted by
+//distinct operators at startup, so that no single host stores the whole key.
+type ShareFiles struct {
+	Paths []string
+}
+
+//Key reconstructs the key and zeroes the shares.
+//
+//Returns an error if a file cannot be read or may be accessed by other users, or if the shares
+//do not reconstruct the key, see Combine.
+func (s *ShareFiles) Key() ([]byte, error) {
+	shares := []*Share{}
+	defer func() {
+		for _, share := range shares {
+			Zero(share.Data)
+		}
+	}()
+	for _, path := range s.Paths {
+		share, err := ReadShareFile(path)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return Combine(shares)
+}
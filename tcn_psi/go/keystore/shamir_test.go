@@ -0,0 +1,130 @@
+package keystore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestGF(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInv(byte(a))) != 1 {
+			t.Fatalf("invalid inverse of %v", a)
+		}
+		if gfMul(byte(a), 1) != byte(a) || gfMul(byte(a), 0) != 0 {
+			t.Fatalf("invalid product of %v", a)
+		}
+	}
+	//the AES polynomial, FIPS-197 section 4.2.
+	if gfMul(0x57, 0x83) != 0xc1 || gfMul(0x57, 0x13) != 0xfe {
+		t.Errorf("invalid product")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	key := []byte("a server key of thirty-two bytes")
+	shares, err := Split(key, 5, 3)
+	if err != nil {
+		t.Fatalf("Split failed %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("unexpected number of shares %v", len(shares))
+	}
+	for _, share := range shares {
+		if bytes.Equal(share.Data, key) {
+			t.Errorf("a share should not hold the key")
+		}
+	}
+
+	//every quorum reconstructs the key.
+	for _, indices := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		quorum := []*Share{}
+		for _, idx := range indices {
+			quorum = append(quorum, shares[idx])
+		}
+		combined, err := Combine(quorum)
+		if err != nil || !bytes.Equal(combined, key) {
+			t.Errorf("Combine failed for %v %v", indices, err)
+		}
+	}
+
+	if _, err := Combine(shares[:2]); err != ErrNoQuorum {
+		t.Errorf("two shares should not be a quorum %v", err)
+	}
+	if _, err := Combine(nil); err != ErrNoQuorum {
+		t.Errorf("no share should not be a quorum %v", err)
+	}
+	if _, err := Combine([]*Share{shares[0], shares[1], shares[1]}); err == nil {
+		t.Errorf("a duplicate share should be rejected")
+	}
+	other, err := Split(key, 5, 3)
+	if err != nil {
+		t.Fatalf("Split failed %v", err)
+	}
+	if _, err := Combine([]*Share{shares[0], shares[1], other[2]}); err == nil {
+		t.Errorf("shares of different splits should be rejected")
+	}
+
+	corrupted := *shares[3]
+	corrupted.Data = append([]byte{}, shares[3].Data...)
+	corrupted.Data[7]++
+	if _, err := Combine([]*Share{shares[0], shares[1], &corrupted}); err == nil {
+		t.Errorf("a corrupted share should be rejected")
+	}
+	if _, err := Combine([]*Share{shares[0], shares[1], shares[2], &corrupted}); err == nil {
+		t.Errorf("a corrupted share should be detected beyond the threshold")
+	}
+
+	for _, params := range [][2]int{{5, 1}, {2, 3}, {256, 3}} {
+		if _, err := Split(key, params[0], params[1]); err == nil {
+			t.Errorf("invalid parameters %v should be rejected", params)
+		}
+	}
+	if _, err := Split(nil, 5, 3); err == nil {
+		t.Errorf("an empty key should be rejected")
+	}
+}
+
+func TestShareFiles(t *testing.T) {
+	dir := helperTempDir(t)
+	tcnServer, _ := helperServerKey(t)
+	shares, err := SplitServer(tcnServer, 3, 2)
+	if err != nil {
+		t.Fatalf("SplitServer failed %v", err)
+	}
+	paths := []string{}
+	for _, share := range shares {
+		if share.KeyID != tcnServer.KeyID().String() {
+			t.Errorf("unexpected key ID %v", share.KeyID)
+		}
+		path := filepath.Join(dir, "share-"+strconv.Itoa(share.Index)+".json")
+		if err := WriteShareFile(path, share); err != nil {
+			t.Fatalf("WriteShareFile failed %v", err)
+		}
+		paths = append(paths, path)
+	}
+	if err := WriteShareFile(paths[0], shares[0]); err == nil {
+		t.Errorf("an existing share file should not be overwritten")
+	}
+
+	loaded, err := LoadServer(&ShareFiles{Paths: paths[1:]})
+	if err != nil {
+		t.Fatalf("Failed to load the server from the shares %v", err)
+	}
+	defer loaded.Close()
+	if loaded.KeyID() != tcnServer.KeyID() {
+		t.Errorf("the shares should reconstruct the key")
+	}
+	if _, err := LoadServer(&ShareFiles{Paths: paths[:1]}); err != ErrNoQuorum {
+		t.Errorf("a single share should not be a quorum %v", err)
+	}
+
+	if err := os.Chmod(paths[2], 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := LoadServer(&ShareFiles{Paths: paths[1:]}); err == nil {
+		t.Errorf("a share readable by other users should be rejected")
+	}
+}
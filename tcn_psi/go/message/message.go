@@ -0,0 +1,324 @@
+//Package message defines the typed envelopes exchanged by the TCN-PSI client and server.
+//
+//Every message is serialized as a binary envelope: a 4 bytes magic value followed by a list
+//of fields, each encoded as a one byte tag, a uvarint length and the field value. Unknown
+//fields are skipped, so new fields can be added without breaking older readers.
+package message
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+//ProtocolVersion is the version of the envelope format and of the exchange it describes.
+//Messages carrying another version are rejected.
+const ProtocolVersion = 1
+
+//magic marks the start of every envelope.
+var magic = []byte("TPSI")
+
+//Kind identifies the type of message carried by an envelope.
+type Kind uint8
+
+//Message kinds.
+const (
+	KindSetup    Kind = 1
+	KindRequest  Kind = 2
+	KindResponse Kind = 3
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindSetup:
+		return "setup message"
+	case KindRequest:
+		return "request"
+	case KindResponse:
+		return "response"
+	}
+	return fmt.Sprintf("unknown message kind %d", uint8(k))
+}
+
+//Envelope field tags.
+const (
+	tagProtocolVersion = 1
+	tagLibraryVersion  = 2
+	tagKind            = 3
+	tagSetupID         = 4
+	tagPayload         = 5
+	tagStart           = 6
+	tagEnd             = 7
+	tagKeyID           = 8
+	tagCreatedAt       = 9
+	tagReports         = 10
+	tagFPR             = 11
+	tagSignature       = 12
+	tagSignedAt        = 13
+)
+
+//SetupID identifies a setup message. It is the SHA-256 digest of the PSI setup payload.
+type SetupID [sha256.Size]byte
+
+//ComputeSetupID returns the identifier of a PSI setup payload.
+func ComputeSetupID(payload string) SetupID {
+	return SetupID(sha256.Sum256([]byte(payload)))
+}
+
+//String returns the hexadecimal representation of the identifier.
+func (id SetupID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+//KeyID identifies the server key a message was created with. The zero KeyID marks messages
+//which are not bound to a key.
+type KeyID [8]byte
+
+//keyIDDomainSep is the domain separator of the hash deriving key identifiers.
+var keyIDDomainSep = []byte("TCN-PSI key ID")
+
+//ComputeKeyID derives the public identifier of a server private key.
+func ComputeKeyID(key []byte) KeyID {
+	var id KeyID
+	digest := sha256.Sum256(append(append([]byte{}, keyIDDomainSep...), key...))
+	copy(id[:], digest[:])
+	return id
+}
+
+//String returns the hexadecimal representation of the identifier.
+func (id KeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+//ParseSetupID parses the hexadecimal representation of an identifier.
+func ParseSetupID(s string) (SetupID, error) {
+	var id SetupID
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return id, err
+	}
+	if len(raw) != len(id) {
+		return id, errors.New("invalid setup ID length")
+	}
+	copy(id[:], raw)
+	return id, nil
+}
+
+//CheckLibraryVersion returns a descriptive error if a message was produced by another version
+//of the PSI library than the local one.
+func CheckLibraryVersion(kind Kind, local, remote string) error {
+	if local != remote {
+		return fmt.Errorf("%v was produced by PSI library version %q, expected %q", kind, remote, local)
+	}
+	return nil
+}
+
+//envelope is the decoded form of any message.
+type envelope struct {
+	protocolVersion uint64
+	libraryVersion  string
+	kind            Kind
+	setupID         SetupID
+	keyID           KeyID
+	payload         string
+	//start and end delimit the ingestion times of the reports covered by a setup message.
+	start time.Time
+	end   time.Time
+	//createdAt, reports and fpr describe how a setup message was built.
+	createdAt time.Time
+	reports   uint64
+	fpr       float64
+	//signedAt is the time the signature of a setup message was made.
+	signedAt  time.Time
+	signature []byte
+}
+
+func appendField(data []byte, tag uint8, value []byte) []byte {
+	data = append(data, tag)
+	length := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(length, uint64(len(value)))
+	data = append(data, length[:n]...)
+	return append(data, value...)
+}
+
+func uvarintBytes(value uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, value)
+	return buf[:n]
+}
+
+//timeBytes encodes t as a number of seconds since the Unix epoch.
+func timeBytes(t time.Time) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, t.Unix())
+	return buf[:n]
+}
+
+//parseTime decodes an optional time field.
+func parseTime(fields map[uint8][]byte, tag uint8) (time.Time, error) {
+	value, ok := fields[tag]
+	if !ok {
+		return time.Time{}, nil
+	}
+	seconds, n := binary.Varint(value)
+	if n <= 0 || n != len(value) {
+		return time.Time{}, fmt.Errorf("invalid time field %d", tag)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+//parseUvarint decodes an optional unsigned integer field.
+func parseUvarint(fields map[uint8][]byte, tag uint8) (uint64, error) {
+	value, ok := fields[tag]
+	if !ok {
+		return 0, nil
+	}
+	number, n := binary.Uvarint(value)
+	if n <= 0 || n != len(value) {
+		return 0, fmt.Errorf("invalid integer field %d", tag)
+	}
+	return number, nil
+}
+
+//float64Bytes encodes value as its IEEE 754 representation in big-endian order.
+func float64Bytes(value float64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(value))
+	return buf
+}
+
+//parseFloat64 decodes an optional floating-point field.
+func parseFloat64(fields map[uint8][]byte, tag uint8) (float64, error) {
+	value, ok := fields[tag]
+	if !ok {
+		return 0, nil
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("invalid floating-point field %d", tag)
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(value)), nil
+}
+
+func (e *envelope) marshal() []byte {
+	data := append([]byte{}, magic...)
+	data = appendField(data, tagProtocolVersion, uvarintBytes(ProtocolVersion))
+	data = appendField(data, tagLibraryVersion, []byte(e.libraryVersion))
+	data = appendField(data, tagKind, []byte{uint8(e.kind)})
+	data = appendField(data, tagSetupID, e.setupID[:])
+	if e.keyID != (KeyID{}) {
+		data = appendField(data, tagKeyID, e.keyID[:])
+	}
+	if !e.start.IsZero() {
+		data = appendField(data, tagStart, timeBytes(e.start))
+	}
+	if !e.end.IsZero() {
+		data = appendField(data, tagEnd, timeBytes(e.end))
+	}
+	if !e.createdAt.IsZero() {
+		data = appendField(data, tagCreatedAt, timeBytes(e.createdAt))
+	}
+	if e.reports != 0 {
+		data = appendField(data, tagReports, uvarintBytes(e.reports))
+	}
+	if e.fpr != 0 {
+		data = appendField(data, tagFPR, float64Bytes(e.fpr))
+	}
+	if !e.signedAt.IsZero() {
+		data = appendField(data, tagSignedAt, timeBytes(e.signedAt))
+	}
+	data = appendField(data, tagPayload, []byte(e.payload))
+	if len(e.signature) > 0 {
+		data = appendField(data, tagSignature, e.signature)
+	}
+	return data
+}
+
+//unmarshalEnvelope decodes data and checks that it holds a message of the expected kind and
+//protocol version.
+func unmarshalEnvelope(data []byte, expected Kind) (*envelope, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return nil, errors.New("not a TCN-PSI message")
+	}
+	data = data[len(magic):]
+
+	fields := map[uint8][]byte{}
+	for len(data) > 0 {
+		tag := data[0]
+		length, n := binary.Uvarint(data[1:])
+		if n <= 0 || length > uint64(len(data)-1-n) {
+			return nil, errors.New("truncated message")
+		}
+		if _, ok := fields[tag]; ok {
+			return nil, fmt.Errorf("duplicate message field %d", tag)
+		}
+		start := 1 + n
+		fields[tag] = data[start : start+int(length)]
+		data = data[start+int(length):]
+	}
+
+	for _, tag := range []uint8{tagProtocolVersion, tagLibraryVersion, tagKind, tagSetupID, tagPayload} {
+		if _, ok := fields[tag]; !ok {
+			return nil, fmt.Errorf("missing message field %d", tag)
+		}
+	}
+
+	e := &envelope{}
+	version, n := binary.Uvarint(fields[tagProtocolVersion])
+	if n <= 0 {
+		return nil, errors.New("invalid protocol version")
+	}
+	if version != ProtocolVersion {
+		return nil, fmt.Errorf("unsupported protocol version %d, expected %d", version, ProtocolVersion)
+	}
+	e.protocolVersion = version
+
+	if len(fields[tagKind]) != 1 {
+		return nil, errors.New("invalid message kind")
+	}
+	e.kind = Kind(fields[tagKind][0])
+	if e.kind != expected {
+		return nil, fmt.Errorf("got a %v, expected a %v", e.kind, expected)
+	}
+
+	if len(fields[tagSetupID]) != len(e.setupID) {
+		return nil, errors.New("invalid setup ID")
+	}
+	copy(e.setupID[:], fields[tagSetupID])
+	e.libraryVersion = string(fields[tagLibraryVersion])
+	e.payload = string(fields[tagPayload])
+	if keyID, ok := fields[tagKeyID]; ok {
+		if len(keyID) != len(e.keyID) {
+			return nil, errors.New("invalid key ID")
+		}
+		copy(e.keyID[:], keyID)
+	}
+
+	var err error
+	if e.start, err = parseTime(fields, tagStart); err != nil {
+		return nil, err
+	}
+	if e.end, err = parseTime(fields, tagEnd); err != nil {
+		return nil, err
+	}
+	if e.createdAt, err = parseTime(fields, tagCreatedAt); err != nil {
+		return nil, err
+	}
+	if e.reports, err = parseUvarint(fields, tagReports); err != nil {
+		return nil, err
+	}
+	if e.fpr, err = parseFloat64(fields, tagFPR); err != nil {
+		return nil, err
+	}
+	if e.signedAt, err = parseTime(fields, tagSignedAt); err != nil {
+		return nil, err
+	}
+	if signature, ok := fields[tagSignature]; ok {
+		e.signature = append([]byte{}, signature...)
+	}
+	return e, nil
+}
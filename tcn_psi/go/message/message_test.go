@@ -0,0 +1,146 @@
+package message
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	setup := NewSetupMessage("0.2.0", "setup payload")
+	data, err := setup.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal setup %v", err)
+	}
+	decodedSetup := &SetupMessage{}
+	if err := decodedSetup.UnmarshalBinary(data); err != nil {
+		t.Fatalf("failed to unmarshal setup %v", err)
+	}
+	if !reflect.DeepEqual(decodedSetup, setup) {
+		t.Errorf("invalid setup %+v, expected %+v", decodedSetup, setup)
+	}
+
+	request := NewRequest(setup, "0.2.0", "request payload")
+	data, err = request.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal request %v", err)
+	}
+	decodedRequest := &Request{}
+	if err := decodedRequest.UnmarshalBinary(data); err != nil {
+		t.Fatalf("failed to unmarshal request %v", err)
+	}
+	if *decodedRequest != *request || decodedRequest.SetupID != setup.ID {
+		t.Errorf("invalid request %+v, expected %+v", decodedRequest, request)
+	}
+
+	response := NewResponse(request, "0.2.0", "response payload")
+	data, err = response.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal response %v", err)
+	}
+	decodedResponse := &Response{}
+	if err := decodedResponse.UnmarshalBinary(data); err != nil {
+		t.Fatalf("failed to unmarshal response %v", err)
+	}
+	if *decodedResponse != *response {
+		t.Errorf("invalid response %+v, expected %+v", decodedResponse, response)
+	}
+	if err := decodedResponse.CheckSetup(setup); err != nil {
+		t.Errorf("response should belong to the setup %v", err)
+	}
+	if err := decodedResponse.CheckSetup(NewSetupMessage("0.2.0", "other")); err == nil {
+		t.Errorf("response should not belong to another setup")
+	}
+
+	delta := NewSetupMessage("0.2.0", "delta payload")
+	delta.Start = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	delta.End = delta.Start.Add(24 * time.Hour)
+	data, err = delta.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal delta %v", err)
+	}
+	decodedDelta := &SetupMessage{}
+	if err := decodedDelta.UnmarshalBinary(data); err != nil {
+		t.Fatalf("failed to unmarshal delta %v", err)
+	}
+	if !decodedDelta.Start.Equal(delta.Start) || !decodedDelta.End.Equal(delta.End) {
+		t.Errorf("invalid delta range %v %v", decodedDelta.Start, decodedDelta.End)
+	}
+
+	keyed := NewSetupMessage("0.2.0", "keyed payload")
+	keyed.KeyID = ComputeKeyID([]byte("server key"))
+	data, err = NewRequest(keyed, "0.2.0", "request payload").MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal request %v", err)
+	}
+	keyedRequest := &Request{}
+	if err := keyedRequest.UnmarshalBinary(data); err != nil || keyedRequest.KeyID != keyed.KeyID {
+		t.Fatalf("failed to unmarshal request key ID %v", err)
+	}
+	keyedResponse := NewResponse(keyedRequest, "0.2.0", "response payload")
+	if err := keyedResponse.CheckSetup(keyed); err != nil {
+		t.Errorf("response should belong to the keyed setup %v", err)
+	}
+	keyedResponse.KeyID = ComputeKeyID([]byte("other key"))
+	if err := keyedResponse.CheckSetup(keyed); err == nil {
+		t.Errorf("response computed with another key should be rejected")
+	}
+
+	id, err := ParseSetupID(setup.ID.String())
+	if err != nil || id != setup.ID {
+		t.Errorf("failed to parse setup ID %v", err)
+	}
+	if _, err := ParseSetupID("abcd"); err == nil {
+		t.Errorf("ParseSetupID should fail with a short ID")
+	}
+}
+
+func TestUnmarshalFailure(t *testing.T) {
+	setup := NewSetupMessage("0.2.0", "setup payload")
+	data, _ := setup.MarshalBinary()
+
+	if err := (&Request{}).UnmarshalBinary(data); err == nil || !strings.Contains(err.Error(), "expected a request") {
+		t.Errorf("unmarshalling a setup as a request should fail, got %v", err)
+	}
+	if err := (&Response{}).UnmarshalBinary(data); err == nil {
+		t.Errorf("unmarshalling a setup as a response should fail")
+	}
+	if err := (&SetupMessage{}).UnmarshalBinary([]byte("setup payload")); err == nil {
+		t.Errorf("unmarshalling raw data should fail")
+	}
+	for idx := len(magic); idx < len(data); idx++ {
+		if err := (&SetupMessage{}).UnmarshalBinary(data[:idx]); err == nil {
+			t.Errorf("unmarshalling a truncated setup should fail at %v", idx)
+		}
+	}
+
+	tampered := bytes.Replace(data, []byte("setup payload"), []byte("setup paylaod"), 1)
+	if err := (&SetupMessage{}).UnmarshalBinary(tampered); err == nil {
+		t.Errorf("unmarshalling a tampered setup should fail")
+	}
+
+	e := &envelope{libraryVersion: "0.2.0", kind: KindSetup, setupID: setup.ID, payload: setup.Payload}
+	future := append([]byte{}, magic...)
+	future = appendField(future, tagProtocolVersion, uvarintBytes(ProtocolVersion+1))
+	future = append(future, e.marshal()[len(magic)+3:]...)
+	if err := (&SetupMessage{}).UnmarshalBinary(future); err == nil || !strings.Contains(err.Error(), "unsupported protocol version") {
+		t.Errorf("unmarshalling a setup from another protocol version should fail, got %v", err)
+	}
+
+	// unknown fields are skipped.
+	extended := appendField(append([]byte{}, data...), 200, []byte("from the future"))
+	decoded := &SetupMessage{}
+	if err := decoded.UnmarshalBinary(extended); err != nil || !reflect.DeepEqual(decoded, setup) {
+		t.Errorf("unknown fields should be skipped, got %v", err)
+	}
+	duplicated := appendField(append([]byte{}, data...), tagPayload, []byte("other"))
+	if err := (&SetupMessage{}).UnmarshalBinary(duplicated); err == nil {
+		t.Errorf("duplicate fields should be rejected")
+	}
+
+	if err := CheckLibraryVersion(KindSetup, "0.2.0", "0.1.0"); err == nil || !strings.Contains(err.Error(), "0.1.0") {
+		t.Errorf("CheckLibraryVersion should fail with a descriptive error, got %v", err)
+	}
+}
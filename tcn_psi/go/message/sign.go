@@ -0,0 +1,72 @@
+package message
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"time"
+)
+
+//signatureDomainSep is the domain separator of the setup message signatures.
+var signatureDomainSep = []byte("TCN-PSI setup signature")
+
+var (
+	//ErrUnsigned is returned when verifying a setup message which carries no signature.
+	ErrUnsigned = errors.New("setup message is not signed")
+	//ErrInvalidSignature is returned when the signature of a setup message does not match any
+	//of the trusted keys.
+	ErrInvalidSignature = errors.New("invalid setup message signature")
+)
+
+//signedData returns the data covered by the signature: the envelope of the setup message
+//without its signature. It covers the ID, and hence the payload, the key ID, the time range,
+//the build metadata and the signing time.
+func (m *SetupMessage) signedData() []byte {
+	e := m.envelope()
+	e.signature = nil
+	return append(append([]byte{}, signatureDomainSep...), e.marshal()...)
+}
+
+//Sign signs the setup message at the current time. See SignAt.
+func (m *SetupMessage) Sign(key ed25519.PrivateKey) error {
+	return m.SignAt(key, time.Now())
+}
+
+//SignAt signs the setup message and its metadata with the operator signing key, and records t
+//as its signing time. Verifiers measure the freshness of a setup message from its signing time,
+//so a setup message published for long must be signed again periodically. The message must not
+//be modified afterwards, since any change invalidates the signature.
+//
+//Returns an error if the key is invalid.
+func (m *SetupMessage) SignAt(key ed25519.PrivateKey, t time.Time) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return errors.New("invalid signing key")
+	}
+	if m.Reports < 0 {
+		return errors.New("negative report count")
+	}
+	m.SignedAt = t.UTC().Truncate(time.Second)
+	m.Signature = ed25519.Sign(key, m.signedData())
+	return nil
+}
+
+//VerifySignature checks that the setup message was signed with the private key of one of keys,
+//e.g. the current and the next operator keys during a rotation. Fields added by later protocol
+//versions are not covered, so their messages must be verified by a client of the same version.
+//
+//Returns ErrUnsigned if the message carries no signature, and ErrInvalidSignature if it was
+//modified or signed with another key.
+func (m *SetupMessage) VerifySignature(keys ...ed25519.PublicKey) error {
+	if len(m.Signature) == 0 {
+		return ErrUnsigned
+	}
+	if len(m.Signature) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	data := m.signedData()
+	for _, key := range keys {
+		if len(key) == ed25519.PublicKeySize && ed25519.Verify(key, data, m.Signature) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
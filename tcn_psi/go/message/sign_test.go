@@ -0,0 +1,148 @@
+package message
+
+import (
+	"crypto/ed25519"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func helperSignedSetup(t *testing.T) (*SetupMessage, ed25519.PublicKey) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup := NewSetupMessage("0.2.0", "setup payload")
+	setup.KeyID = ComputeKeyID([]byte("server key"))
+	setup.Start = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	setup.End = setup.Start.Add(24 * time.Hour)
+	setup.CreatedAt = setup.End
+	setup.Reports = 42
+	setup.FPR = 1e-6
+	if err := setup.Sign(private); err != nil {
+		t.Fatalf("Sign failed %v", err)
+	}
+	return setup, public
+}
+
+func TestSign(t *testing.T) {
+	setup, public := helperSignedSetup(t)
+	if err := setup.VerifySignature(public); err != nil {
+		t.Errorf("the signature should be valid %v", err)
+	}
+
+	data, err := setup.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal setup %v", err)
+	}
+	decoded := &SetupMessage{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("failed to unmarshal setup %v", err)
+	}
+	if !reflect.DeepEqual(decoded, setup) {
+		t.Errorf("invalid setup %+v, expected %+v", decoded, setup)
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if err := decoded.VerifySignature(other, public); err != nil {
+		t.Errorf("the signature should match one of the keys %v", err)
+	}
+	if err := decoded.VerifySignature(other); err != ErrInvalidSignature {
+		t.Errorf("the signature should not match another key %v", err)
+	}
+	if err := decoded.VerifySignature(); err != ErrInvalidSignature {
+		t.Errorf("no key should be trusted %v", err)
+	}
+
+	for _, change := range []func(m *SetupMessage){
+		func(m *SetupMessage) { m.Reports++ },
+		func(m *SetupMessage) { m.FPR = 0.1 },
+		func(m *SetupMessage) { m.CreatedAt = m.CreatedAt.Add(time.Hour) },
+		func(m *SetupMessage) { m.SignedAt = m.SignedAt.Add(time.Hour) },
+		func(m *SetupMessage) { m.End = m.End.Add(time.Hour) },
+		func(m *SetupMessage) { m.KeyID = KeyID{} },
+		func(m *SetupMessage) { m.LibraryVersion = "0.2.1" },
+		func(m *SetupMessage) {
+			m.Payload = "other payload"
+			m.ID = ComputeSetupID(m.Payload)
+		},
+		func(m *SetupMessage) { m.Signature = m.Signature[1:] },
+	} {
+		tampered := *setup
+		tampered.Signature = append([]byte{}, setup.Signature...)
+		change(&tampered)
+		if err := tampered.VerifySignature(public); err != ErrInvalidSignature {
+			t.Errorf("a tampered setup should be rejected %+v %v", tampered, err)
+		}
+	}
+
+	//the signature is the last field, flipping a bit of it is caught by the verification.
+	data[len(data)-1] ^= 1
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("failed to unmarshal setup %v", err)
+	}
+	if err := decoded.VerifySignature(public); err != ErrInvalidSignature {
+		t.Errorf("a corrupted signature should be rejected %v", err)
+	}
+
+	unsigned := NewSetupMessage("0.2.0", "setup payload")
+	if err := unsigned.VerifySignature(public); err != ErrUnsigned {
+		t.Errorf("an unsigned setup should be rejected %v", err)
+	}
+	if err := unsigned.Sign(ed25519.PrivateKey("short")); err == nil {
+		t.Errorf("an invalid key should be rejected")
+	}
+}
+
+func TestSignAt(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup := NewSetupMessage("0.2.0", "setup payload")
+	signedAt := time.Date(2020, 6, 1, 12, 0, 0, 500, time.FixedZone("CEST", 2*3600))
+	if err := setup.SignAt(private, signedAt); err != nil {
+		t.Fatalf("SignAt failed %v", err)
+	}
+	if !setup.SignedAt.Equal(signedAt.Truncate(time.Second)) || setup.SignedAt.Location() != time.UTC {
+		t.Errorf("unexpected signing time %v", setup.SignedAt)
+	}
+	if err := setup.VerifySignature(public); err != nil {
+		t.Errorf("the signature should be valid %v", err)
+	}
+
+	//signing again refreshes the signing time.
+	first := setup.Signature
+	if err := setup.SignAt(private, signedAt.Add(time.Hour)); err != nil {
+		t.Fatalf("SignAt failed %v", err)
+	}
+	if reflect.DeepEqual(first, setup.Signature) || setup.VerifySignature(public) != nil {
+		t.Errorf("a new signing time should be signed")
+	}
+}
+
+func TestMetadataFields(t *testing.T) {
+	unsigned := NewSetupMessage("0.2.0", "setup payload")
+	data, _ := unsigned.MarshalBinary()
+	for tag, value := range map[uint8][]byte{
+		tagCreatedAt: {},
+		tagReports:   {0x80},
+		tagFPR:       {1, 2, 3},
+		tagSignedAt:  {0x80},
+	} {
+		invalid := appendField(append([]byte{}, data...), tag, value)
+		if err := (&SetupMessage{}).UnmarshalBinary(invalid); err == nil {
+			t.Errorf("an invalid field %v should be rejected", tag)
+		}
+	}
+
+	unsigned.FPR = 0.5
+	data, _ = unsigned.MarshalBinary()
+	decoded := &SetupMessage{}
+	if err := decoded.UnmarshalBinary(data); err != nil || decoded.FPR != 0.5 || decoded.Reports != 0 || !decoded.CreatedAt.IsZero() {
+		t.Errorf("unexpected metadata %+v %v", decoded, err)
+	}
+	unsigned.Reports = -1
+	if _, err := unsigned.MarshalBinary(); err == nil {
+		t.Errorf("a negative report count should be rejected")
+	}
+}
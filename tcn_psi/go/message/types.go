@@ -0,0 +1,213 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+//SetupMessage is published by the server and holds the encrypted dataset the client
+//intersects with.
+type SetupMessage struct {
+	//LibraryVersion is the version of the PSI library which created the payload.
+	LibraryVersion string
+	//ID identifies the setup message. See ComputeSetupID.
+	ID SetupID
+	//KeyID identifies the server key the setup message was created with.
+	KeyID KeyID
+	//Payload is the PSI setup message.
+	Payload string
+	//Start and End delimit the ingestion times of the reports covered by the setup message,
+	//with a resolution of one second. Both are zero if the setup message is not bound to a
+	//time range.
+	Start time.Time
+	End   time.Time
+	//CreatedAt is the time the setup message was built, with a resolution of one second.
+	CreatedAt time.Time
+	//Reports is the number of reports in the setup message.
+	Reports int
+	//FPR is the false-positive rate of the filter.
+	FPR float64
+	//SignedAt is the time the setup message was signed, with a resolution of one second. See
+	//SignAt.
+	SignedAt time.Time
+	//Signature authenticates the setup message and its metadata. See Sign.
+	Signature []byte
+}
+
+//NewSetupMessage wraps a PSI setup payload.
+func NewSetupMessage(libraryVersion, payload string) *SetupMessage {
+	return &SetupMessage{
+		LibraryVersion: libraryVersion,
+		ID:             ComputeSetupID(payload),
+		Payload:        payload,
+	}
+}
+
+//Verify checks that the identifier of the setup message matches its payload.
+func (m *SetupMessage) Verify() error {
+	if m.ID != ComputeSetupID(m.Payload) {
+		return errors.New("setup message ID does not match its payload")
+	}
+	return nil
+}
+
+func (m *SetupMessage) envelope() *envelope {
+	return &envelope{
+		libraryVersion: m.LibraryVersion,
+		kind:           KindSetup,
+		setupID:        m.ID,
+		keyID:          m.KeyID,
+		payload:        m.Payload,
+		start:          m.Start,
+		end:            m.End,
+		createdAt:      m.CreatedAt,
+		reports:        uint64(m.Reports),
+		fpr:            m.FPR,
+		signedAt:       m.SignedAt,
+		signature:      m.Signature,
+	}
+}
+
+//MarshalBinary encodes the setup message in its envelope.
+func (m *SetupMessage) MarshalBinary() ([]byte, error) {
+	if m.Reports < 0 {
+		return nil, errors.New("negative report count")
+	}
+	return m.envelope().marshal(), nil
+}
+
+//UnmarshalBinary decodes a setup message and verifies its integrity.
+//
+//Returns an error if data is not a valid setup message for this protocol version.
+func (m *SetupMessage) UnmarshalBinary(data []byte) error {
+	e, err := unmarshalEnvelope(data, KindSetup)
+	if err != nil {
+		return err
+	}
+	m.LibraryVersion = e.libraryVersion
+	m.ID = e.setupID
+	m.KeyID = e.keyID
+	m.Payload = e.payload
+	m.Start = e.start
+	m.End = e.end
+	m.CreatedAt = e.createdAt
+	if e.reports > math.MaxInt32 {
+		return errors.New("invalid report count")
+	}
+	m.Reports = int(e.reports)
+	m.FPR = e.fpr
+	m.SignedAt = e.signedAt
+	m.Signature = e.signature
+	return m.Verify()
+}
+
+//Request is sent by the client to query the server's dataset.
+type Request struct {
+	//LibraryVersion is the version of the PSI library which created the payload.
+	LibraryVersion string
+	//SetupID identifies the setup message the request will be intersected with.
+	SetupID SetupID
+	//KeyID identifies the server key which must process the request.
+	KeyID KeyID
+	//Payload is the PSI request.
+	Payload string
+}
+
+//NewRequest wraps a PSI request payload created for setup.
+func NewRequest(setup *SetupMessage, libraryVersion, payload string) *Request {
+	return &Request{
+		LibraryVersion: libraryVersion,
+		SetupID:        setup.ID,
+		KeyID:          setup.KeyID,
+		Payload:        payload,
+	}
+}
+
+//MarshalBinary encodes the request in its envelope.
+func (m *Request) MarshalBinary() ([]byte, error) {
+	e := &envelope{
+		libraryVersion: m.LibraryVersion,
+		kind:           KindRequest,
+		setupID:        m.SetupID,
+		keyID:          m.KeyID,
+		payload:        m.Payload,
+	}
+	return e.marshal(), nil
+}
+
+//UnmarshalBinary decodes a request.
+//
+//Returns an error if data is not a valid request for this protocol version.
+func (m *Request) UnmarshalBinary(data []byte) error {
+	e, err := unmarshalEnvelope(data, KindRequest)
+	if err != nil {
+		return err
+	}
+	m.LibraryVersion = e.libraryVersion
+	m.SetupID = e.setupID
+	m.KeyID = e.keyID
+	m.Payload = e.payload
+	return nil
+}
+
+//Response is returned by the server for a request.
+type Response struct {
+	//LibraryVersion is the version of the PSI library which created the payload.
+	LibraryVersion string
+	//SetupID identifies the setup message of the request this response answers.
+	SetupID SetupID
+	//KeyID identifies the server key which processed the request.
+	KeyID KeyID
+	//Payload is the PSI response.
+	Payload string
+}
+
+//NewResponse wraps a PSI response payload computed for request.
+func NewResponse(request *Request, libraryVersion, payload string) *Response {
+	return &Response{
+		LibraryVersion: libraryVersion,
+		SetupID:        request.SetupID,
+		KeyID:          request.KeyID,
+		Payload:        payload,
+	}
+}
+
+//CheckSetup returns an error if the response does not belong to setup.
+func (m *Response) CheckSetup(setup *SetupMessage) error {
+	if m.SetupID != setup.ID {
+		return fmt.Errorf("response belongs to setup message %v, not %v", m.SetupID, setup.ID)
+	}
+	if m.KeyID != setup.KeyID {
+		return fmt.Errorf("response was computed with server key %v, setup message uses %v", m.KeyID, setup.KeyID)
+	}
+	return nil
+}
+
+//MarshalBinary encodes the response in its envelope.
+func (m *Response) MarshalBinary() ([]byte, error) {
+	e := &envelope{
+		libraryVersion: m.LibraryVersion,
+		kind:           KindResponse,
+		setupID:        m.SetupID,
+		keyID:          m.KeyID,
+		payload:        m.Payload,
+	}
+	return e.marshal(), nil
+}
+
+//UnmarshalBinary decodes a response.
+//
+//Returns an error if data is not a valid response for this protocol version.
+func (m *Response) UnmarshalBinary(data []byte) error {
+	e, err := unmarshalEnvelope(data, KindResponse)
+	if err != nil {
+		return err
+	}
+	m.LibraryVersion = e.libraryVersion
+	m.SetupID = e.setupID
+	m.KeyID = e.keyID
+	m.Payload = e.payload
+	return nil
+}
@@ -0,0 +1,34 @@
+//Package metrics instruments the TCN-PSI server. ServerMetrics observes the servers and the
+//ingestion service, and records its measurements through a Provider. Registry is a Provider
+//exposing the metrics in the Prometheus text format.
+package metrics
+
+//Counter is a monotonically increasing value, split by label values.
+type Counter interface {
+	//Add increases the counter identified by labels, given in the order of the label names.
+	Add(delta float64, labels ...string)
+}
+
+//Histogram counts observations in buckets, split by label values.
+type Histogram interface {
+	//Observe records value in the histogram identified by labels, given in the order of the
+	//label names.
+	Observe(value float64, labels ...string)
+}
+
+//Provider creates metrics. It can be implemented over any metrics library.
+type Provider interface {
+	Counter(name, help string, labelNames ...string) Counter
+	Histogram(name, help string, buckets []float64, labelNames ...string) Histogram
+}
+
+//ExponentialBuckets returns count bucket upper bounds, starting at start and multiplied by
+//factor each time.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for idx := range buckets {
+		buckets[idx] = start
+		start *= factor
+	}
+	return buckets
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//series is the value of a metric for a set of label values.
+type series struct {
+	labels  []string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+//metric is a counter or a histogram held by a Registry.
+type metric struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+//get returns the series of labels, creating it if needed. Must be called with m.mu held.
+func (m *metric) get(labels []string) *series {
+	if len(labels) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %v expects %v labels, got %v", m.name, len(m.labelNames), len(labels)))
+	}
+	key := strings.Join(labels, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string{}, labels...)}
+		if m.kind == "histogram" {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+//Add increases the counter identified by labels. It panics if delta is negative.
+func (m *metric) Add(delta float64, labels ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %v cannot decrease", m.name))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labels).value += delta
+}
+
+//Observe records value in the histogram identified by labels.
+func (m *metric) Observe(value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(labels)
+	for idx, bound := range m.buckets {
+		if value <= bound {
+			s.buckets[idx]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+//Registry holds metrics and exposes them in the Prometheus text format. It implements Provider
+//and http.Handler.
+//
+//A Registry is safe for concurrent use by multiple goroutines.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+//NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]*metric{}}
+}
+
+func (r *Registry) register(m *metric) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[m.name]; ok {
+		panic(fmt.Sprintf("metric %v registered twice", m.name))
+	}
+	m.series = map[string]*series{}
+	r.metrics[m.name] = m
+	return m
+}
+
+//Counter registers a counter. It panics if the name is already registered.
+func (r *Registry) Counter(name, help string, labelNames ...string) Counter {
+	return r.register(&metric{name: name, help: help, kind: "counter", labelNames: labelNames})
+}
+
+//Histogram registers a histogram with the given bucket upper bounds, in increasing order. It
+//panics if the name is already registered.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return r.register(&metric{name: name, help: help, kind: "histogram", labelNames: labelNames, buckets: sorted})
+}
+
+//formatValue formats a sample value.
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+//formatLabels formats label pairs, followed by an optional extra pair.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := []string{}
+	for idx, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[idx])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+//write writes the metric in the text format. Must be called with m.mu held.
+func (m *metric) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, helpEscaper.Replace(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind == "counter" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labelNames, s.labels, "", ""), formatValue(s.value))
+			continue
+		}
+		for idx, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labels, "le", formatValue(bound)), s.buckets[idx])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labelNames, s.labels, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labelNames, s.labels, "", ""), s.count)
+	}
+}
+
+//WriteText writes every metric in the Prometheus text exposition format, sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	buffered := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.mu.Lock()
+		m.write(buffered)
+		m.mu.Unlock()
+	}
+	return buffered.Flush()
+}
+
+//ServeHTTP serves the metrics to a Prometheus scraper.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
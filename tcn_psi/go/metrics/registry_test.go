@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistryText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("requests_total", "Requests\nprocessed.", "result")
+	latency := registry.Histogram("latency_seconds", "Latency.", []float64{1, 0.1})
+	requests.Add(2, "ok")
+	requests.Add(1, `bad "input"`)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(5)
+
+	var buf bytes.Buffer
+	if err := registry.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText failed %v", err)
+	}
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+# HELP requests_total Requests\nprocessed.
+# TYPE requests_total counter
+requests_total{result="bad \"input\""} 1
+requests_total{result="ok"} 2
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected exposition:\n%v\nexpected:\n%v", buf.String(), expected)
+	}
+}
+
+func TestRegistryMisuse(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.Counter("total", "Total.", "a", "b")
+	for name, fn := range map[string]func(){
+		"duplicate": func() { registry.Counter("total", "Total.") },
+		"labels":    func() { counter.Add(1, "a") },
+		"negative":  func() { counter.Add(-1, "a", "b") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v should panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.Counter("total", "Total.")
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for jdx := 0; jdx < 100; jdx++ {
+				counter.Add(1)
+			}
+			registry.WriteText(&bytes.Buffer{})
+		}()
+	}
+	wg.Wait()
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	if !strings.Contains(buf.String(), "total 800\n") {
+		t.Errorf("lost increments:\n%v", buf.String())
+	}
+}
+
+func TestRegistryHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("total", "Total.").Add(1)
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("invalid content type %v", recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), "total 1\n") {
+		t.Errorf("invalid body %v", recorder.Body.String())
+	}
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	buckets := ExponentialBuckets(1, 2, 4)
+	for idx, expected := range []float64{1, 2, 4, 8} {
+		if buckets[idx] != expected {
+			t.Errorf("invalid buckets %v", buckets)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"github.com/openmined/tcn-psi/ingest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"time"
+)
+
+//Results recorded in the result label.
+const (
+	resultOK        = "ok"
+	resultError     = "error"
+	resultClosed    = "closed"
+	resultRejected  = "rejected"
+	resultDuplicate = "duplicate"
+	resultLimited   = "rate_limited"
+)
+
+//ServerMetrics records the activity of the servers and of the ingestion service. It implements
+//server.Observer and ingest.Observer: register it with SetObserver and in ingest.Config.
+type ServerMetrics struct {
+	submissions     Counter
+	setupBuilds     Counter
+	setupReports    Counter
+	setupRejections Counter
+	tcns            Counter
+	setupDuration   Histogram
+	setupSize       Histogram
+	requests        Counter
+	queryRejections Counter
+	elements        Histogram
+	requestDuration Histogram
+	responseSize    Histogram
+}
+
+//NewServerMetrics registers the server metrics with provider.
+func NewServerMetrics(provider Provider) *ServerMetrics {
+	seconds := ExponentialBuckets(0.001, 4, 10)
+	bytes := ExponentialBuckets(1024, 4, 10)
+	return &ServerMetrics{
+		submissions: provider.Counter("tcnpsi_reports_submitted_total",
+			"Reports submitted to the ingestion service, by result.", "result"),
+		setupBuilds: provider.Counter("tcnpsi_setup_builds_total",
+			"Setup messages built, by result.", "result"),
+		setupReports: provider.Counter("tcnpsi_setup_reports_total",
+			"Reports considered for setup messages, by result.", "result"),
+		setupRejections: provider.Counter("tcnpsi_setup_rejected_reports_total",
+			"Reports left out of setup messages, by reason.", "reason"),
+		tcns: provider.Counter("tcnpsi_setup_tcns_expanded_total",
+			"Distinct TCNs expanded from the reports of setup messages."),
+		setupDuration: provider.Histogram("tcnpsi_setup_build_seconds",
+			"Time spent planning and encrypting setup messages.", seconds),
+		setupSize: provider.Histogram("tcnpsi_setup_size_bytes",
+			"Size of the payload of the setup messages.", bytes),
+		requests: provider.Counter("tcnpsi_requests_total",
+			"Requests processed, by result.", "result"),
+		queryRejections: provider.Counter("tcnpsi_requests_rejected_total",
+			"Requests rejected by the query policy, by reason.", "reason"),
+		elements: provider.Histogram("tcnpsi_request_elements",
+			"Number of elements of the processed requests.", ExponentialBuckets(1, 4, 10)),
+		requestDuration: provider.Histogram("tcnpsi_request_seconds",
+			"Time spent processing requests.", seconds),
+		responseSize: provider.Histogram("tcnpsi_response_size_bytes",
+			"Size of the payload of the responses.", bytes),
+	}
+}
+
+//errorResult classifies err for the result label.
+func errorResult(err error) string {
+	switch err.(type) {
+	case nil:
+		return resultOK
+	case *server.QueryRejectedError, *ingest.RejectedError:
+		return resultRejected
+	}
+	switch err {
+	case server.ErrClosed:
+		return resultClosed
+	case ingest.ErrRateLimited:
+		return resultLimited
+	}
+	return resultError
+}
+
+//ReportSubmitted records a submission to the ingestion service.
+func (m *ServerMetrics) ReportSubmitted(receipt *ingest.Receipt, err error) {
+	result := errorResult(err)
+	if receipt != nil && receipt.Duplicate {
+		result = resultDuplicate
+	}
+	m.submissions.Add(1, result)
+}
+
+//SetupBuilt records a setup message build.
+func (m *ServerMetrics) SetupBuilt(plan *server.SetupPlan, setup *message.SetupMessage, duration time.Duration, err error) {
+	m.setupBuilds.Add(1, errorResult(err))
+	if err != nil {
+		return
+	}
+	m.setupDuration.Observe(duration.Seconds())
+	m.setupSize.Observe(float64(len(setup.Payload)))
+	m.tcns.Add(float64(plan.Elements))
+	if plan.Summary == nil {
+		return
+	}
+	m.setupReports.Add(float64(plan.Summary.Accepted), "accepted")
+	m.setupReports.Add(float64(len(plan.Summary.Rejected)), "rejected")
+	for _, rejected := range plan.Summary.Rejected {
+		m.setupRejections.Add(1, string(rejected.Reason))
+	}
+}
+
+//RequestProcessed records a processed request. The elements of successful requests are counted
+//from their payload.
+func (m *ServerMetrics) RequestProcessed(request *message.Request, response *message.Response, duration time.Duration, err error) {
+	m.requests.Add(1, errorResult(err))
+	if err != nil {
+		return
+	}
+	m.requestDuration.Observe(duration.Seconds())
+	m.responseSize.Observe(float64(len(response.Payload)))
+	if elements, err := server.RequestElements(request); err == nil {
+		m.elements.Observe(float64(elements))
+	}
+}
+
+//RequestRejected records a request rejected by a QueryGuard.
+func (m *ServerMetrics) RequestRejected(client string, reason server.QueryRejection) {
+	m.requests.Add(1, resultRejected)
+	m.queryRejections.Add(1, string(reason))
+}
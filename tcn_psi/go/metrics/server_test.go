@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/ingest"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/store"
+	"strings"
+	"testing"
+)
+
+func helperCheckSample(t *testing.T, text string, sample string) {
+	if !strings.Contains(text, sample+"\n") {
+		t.Errorf("missing sample %q in:\n%v", sample, text)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	registry := NewRegistry()
+	observer := NewServerMetrics(registry)
+
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer tcnServer.Close()
+	tcnServer.SetObserver(observer)
+	c, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer c.Close()
+
+	reports, tcns := reporttest.Reports(t, 3)
+	invalid := *reports[2]
+	invalid.Sig = make([]byte, len(reports[2].Sig))
+	reports[2] = &invalid
+	setup, err := tcnServer.CreateSetupMessage(0.001, 100, reports)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	request, err := c.CreateRequest(setup, tcns)
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	if _, err := tcnServer.ProcessRequest(request); err != nil {
+		t.Fatalf("failed to process request %v", err)
+	}
+	tcnServer.ProcessRequest(&message.Request{LibraryVersion: "0.0.1", Payload: "dummy"})
+
+	guard := server.NewQueryGuard(tcnServer, server.NewDayShards(tcnServer, 0.001, 100, 7), server.QueryPolicy{MinElements: 1000})
+	guard.SetObserver(observer)
+	guard.ProcessRequest("a", request)
+
+	tcnServer.Close()
+	tcnServer.ProcessRequest(request)
+
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	text := buf.String()
+	helperCheckSample(t, text, `tcnpsi_setup_builds_total{result="ok"} 1`)
+	helperCheckSample(t, text, `tcnpsi_setup_reports_total{result="accepted"} 2`)
+	helperCheckSample(t, text, `tcnpsi_setup_reports_total{result="rejected"} 1`)
+	helperCheckSample(t, text, `tcnpsi_setup_rejected_reports_total{reason="invalid signature"} 1`)
+	helperCheckSample(t, text, `tcnpsi_setup_tcns_expanded_total 20`)
+	helperCheckSample(t, text, `tcnpsi_setup_build_seconds_count 1`)
+	helperCheckSample(t, text, `tcnpsi_setup_size_bytes_count 1`)
+	helperCheckSample(t, text, `tcnpsi_requests_total{result="ok"} 1`)
+	helperCheckSample(t, text, `tcnpsi_requests_total{result="error"} 1`)
+	helperCheckSample(t, text, `tcnpsi_requests_total{result="rejected"} 1`)
+	helperCheckSample(t, text, `tcnpsi_requests_total{result="closed"} 1`)
+	helperCheckSample(t, text, `tcnpsi_requests_rejected_total{reason="too few elements"} 1`)
+	helperCheckSample(t, text, `tcnpsi_request_elements_sum 30`)
+	helperCheckSample(t, text, `tcnpsi_request_seconds_count 1`)
+	helperCheckSample(t, text, `tcnpsi_response_size_bytes_count 1`)
+}
+
+func TestIngestMetrics(t *testing.T) {
+	registry := NewRegistry()
+	service := ingest.NewService(store.NewMemory(), ingest.Config{
+		RateLimit: 0.001,
+		Burst:     3,
+		Observer:  NewServerMetrics(registry),
+	})
+	reports, _ := reporttest.Reports(t, 1)
+	data, err := reports[0].Bytes()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	service.Submit("a", data)
+	service.Submit("a", data)
+	service.Submit("a", data[:10])
+	service.Submit("a", data)
+
+	var buf bytes.Buffer
+	registry.WriteText(&buf)
+	text := buf.String()
+	helperCheckSample(t, text, `tcnpsi_reports_submitted_total{result="ok"} 1`)
+	helperCheckSample(t, text, `tcnpsi_reports_submitted_total{result="duplicate"} 1`)
+	helperCheckSample(t, text, `tcnpsi_reports_submitted_total{result="rejected"} 1`)
+	helperCheckSample(t, text, `tcnpsi_reports_submitted_total{result="rate_limited"} 1`)
+}
@@ -0,0 +1,302 @@
+//Package rest exposes a TCN-PSI server over HTTP.
+//
+//Endpoints:
+//
+//	GET  /v1/setup       JSON manifest of the published setup messages
+//	GET  /v1/setup/{id}  binary setup message (message.SetupMessage.MarshalBinary)
+//	POST /v1/query       binary request in, binary response out (message.Request, message.Response)
+//	POST /v1/reports     serialized tcn.SignedReport in, JSON receipt out
+//	GET  /healthz        200 while the process is alive
+//	GET  /readyz         200 once the server can answer requests, 503 otherwise
+//	GET  /metrics        metrics, if configured
+//
+//Errors are reported with a JSON body {"error": "..."} and a 4xx or 5xx status code.
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/openmined/tcn-psi/ingest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DefaultMaxRequestBytes is the default maximum size of a query body.
+const DefaultMaxRequestBytes = 16 << 20
+
+//maxReportBytes bounds the body of a report submission, larger than any signed report.
+const maxReportBytes = 4096
+
+//Config configures the handler.
+type Config struct {
+	//Shards publishes the setup messages. Required.
+	Shards *server.DayShards
+	//Queries answers the requests of the clients. Use a zero QueryPolicy to answer every
+	//request. Required.
+	Queries *server.QueryGuard
+	//Ingest accepts the submitted reports. Nil disables the submission endpoint.
+	Ingest *ingest.Service
+	//Metrics, if set, is served on /metrics.
+	Metrics http.Handler
+	//Ready, if set, reports whether the server is ready to answer requests.
+	Ready func() error
+	//ClientID identifies the sender of a request, for the query budgets and the rate limits.
+	//Defaults to the IP address of the remote peer.
+	ClientID func(r *http.Request) string
+	//MaxRequestBytes bounds the size of a query body. Defaults to DefaultMaxRequestBytes.
+	MaxRequestBytes int64
+}
+
+//ShardJSON describes a published setup message in the manifest.
+type ShardJSON struct {
+	ID      string    `json:"id"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Reports int       `json:"reports"`
+	Size    int       `json:"size"`
+}
+
+//ManifestJSON is the body of GET /v1/setup.
+type ManifestJSON struct {
+	Shards []ShardJSON `json:"shards"`
+}
+
+//ReceiptJSON is the body of a successful POST /v1/reports.
+type ReceiptJSON struct {
+	ID         string    `json:"id"`
+	IngestedAt time.Time `json:"ingested_at"`
+	Duplicate  bool      `json:"duplicate"`
+}
+
+//ErrorJSON is the body of a failed call.
+type ErrorJSON struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	config Config
+	mux    *http.ServeMux
+}
+
+//NewHandler returns the HTTP handler of the REST API.
+//
+//Returns an error if a required component is missing.
+func NewHandler(config Config) (http.Handler, error) {
+	if config.Shards == nil || config.Queries == nil {
+		return nil, errors.New("shards and queries are required")
+	}
+	if config.ClientID == nil {
+		config.ClientID = RemoteIP
+	}
+	if config.MaxRequestBytes <= 0 {
+		config.MaxRequestBytes = DefaultMaxRequestBytes
+	}
+	h := &handler{config: config, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/v1/setup", h.manifest)
+	h.mux.HandleFunc("/v1/setup/", h.setup)
+	h.mux.HandleFunc("/v1/query", h.query)
+	if config.Ingest != nil {
+		h.mux.HandleFunc("/v1/reports", h.reports)
+	}
+	h.mux.HandleFunc("/healthz", h.health)
+	h.mux.HandleFunc("/readyz", h.ready)
+	if config.Metrics != nil {
+		h.mux.Handle("/metrics", config.Metrics)
+	}
+	return h.mux, nil
+}
+
+//RemoteIP returns the IP address of the remote peer of r.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &ErrorJSON{Error: err.Error()})
+}
+
+func writeBinary(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+//allow rejects the requests using another method than method.
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return false
+	}
+	return true
+}
+
+//readBody reads a body of at most limit bytes.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+		return nil, false
+	}
+	return data, true
+}
+
+func (h *handler) manifest(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	infos, err := h.config.Shards.Manifest()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	manifest := &ManifestJSON{Shards: []ShardJSON{}}
+	for _, info := range infos {
+		manifest.Shards = append(manifest.Shards, ShardJSON{
+			ID:      info.ID.String(),
+			Start:   info.Start,
+			End:     info.End,
+			Reports: info.Reports,
+			Size:    info.Size,
+		})
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, manifest)
+}
+
+func (h *handler) setup(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	id, err := message.ParseSetupID(strings.TrimPrefix(r.URL.Path, "/v1/setup/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid setup ID"))
+		return
+	}
+	setup, err := h.config.Shards.Shard(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	data, err := setup.MarshalBinary()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	//setup messages are addressed by their content, but the signed ones are signed again.
+	cacheControl := "public, max-age=86400, immutable"
+	if len(setup.Signature) > 0 {
+		ttl := time.Until(setup.SignedAt.Add(server.SignatureRefresh))
+		if ttl < 0 {
+			ttl = 0
+		}
+		cacheControl = "public, max-age=" + strconv.Itoa(int(ttl.Seconds()))
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	writeBinary(w, data)
+}
+
+func (h *handler) query(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	data, ok := readBody(w, r, h.config.MaxRequestBytes)
+	if !ok {
+		return
+	}
+	request := &message.Request{}
+	if err := request.UnmarshalBinary(data); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	response, err := h.config.Queries.ProcessRequest(h.config.ClientID(r), request)
+	if err != nil {
+		writeError(w, queryStatus(err), err)
+		return
+	}
+	data, err = response.MarshalBinary()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeBinary(w, data)
+}
+
+//queryStatus returns the status code reporting a failed query.
+func queryStatus(err error) int {
+	if rejected, ok := err.(*server.QueryRejectedError); ok {
+		switch rejected.Reason {
+		case server.QueryClientBudget, server.QueryGlobalBudget, server.QuerySuspiciousClient:
+			//the budgets last as long as the setup message, retrying does not help.
+			return http.StatusForbidden
+		case server.QueryUnknownSetup:
+			//the setup message expired, the client has to download the manifest again.
+			return http.StatusNotFound
+		}
+		return http.StatusBadRequest
+	}
+	if err == server.ErrClosed {
+		return http.StatusServiceUnavailable
+	}
+	//the remaining errors are caused by invalid requests, e.g. for another library version.
+	return http.StatusBadRequest
+}
+
+func (h *handler) reports(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	data, ok := readBody(w, r, maxReportBytes)
+	if !ok {
+		return
+	}
+	receipt, err := h.config.Ingest.Submit(h.config.ClientID(r), data)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*ingest.RejectedError); ok {
+			status = http.StatusBadRequest
+		} else if err == ingest.ErrRateLimited {
+			status = http.StatusTooManyRequests
+		}
+		writeError(w, status, err)
+		return
+	}
+	status := http.StatusCreated
+	if receipt.Duplicate {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, &ReceiptJSON{
+		ID:         receipt.ID.String(),
+		IngestedAt: receipt.IngestedAt,
+		Duplicate:  receipt.Duplicate,
+	})
+}
+
+func (h *handler) health(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+func (h *handler) ready(w http.ResponseWriter, r *http.Request) {
+	if h.config.Ready != nil {
+		if err := h.config.Ready(); err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+	}
+	w.Write([]byte("ok\n"))
+}
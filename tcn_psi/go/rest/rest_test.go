@@ -0,0 +1,252 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/ingest"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/metrics"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/store"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func helperStartServer(t *testing.T, policy server.QueryPolicy, ready func() error) *httptest.Server {
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	t.Cleanup(func() { tcnServer.Close() })
+	shards := server.NewDayShards(tcnServer, 0.001, 100, 7)
+	handler, err := NewHandler(Config{
+		Shards:  shards,
+		Queries: server.NewQueryGuard(tcnServer, shards, policy),
+		Ingest:  ingest.NewService(store.NewMemory(), ingest.Config{Sink: shards}),
+		Metrics: metrics.NewRegistry(),
+		Ready:   ready,
+	})
+	if err != nil {
+		t.Fatalf("NewHandler failed %v", err)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func helperPost(t *testing.T, url string, data []byte) (*http.Response, []byte) {
+	response, err := http.Post(url, "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("POST %v failed %v", url, err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return response, body
+}
+
+func helperGet(t *testing.T, url string) (*http.Response, []byte) {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %v failed %v", url, err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return response, body
+}
+
+func TestEndToEnd(t *testing.T) {
+	ts := helperStartServer(t, server.QueryPolicy{}, nil)
+	reports, tcns := reporttest.Serialized(t, 10)
+
+	for idx, data := range reports {
+		if idx%2 != 0 {
+			continue
+		}
+		response, body := helperPost(t, ts.URL+"/v1/reports", data)
+		if response.StatusCode != http.StatusCreated {
+			t.Fatalf("report submission failed %v %s", response.StatusCode, body)
+		}
+		receipt := &ReceiptJSON{}
+		if err := json.Unmarshal(body, receipt); err != nil || receipt.Duplicate || len(receipt.ID) != 64 {
+			t.Errorf("invalid receipt %s %v", body, err)
+		}
+	}
+	response, body := helperPost(t, ts.URL+"/v1/reports", reports[0])
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(body), `"duplicate":true`) {
+		t.Errorf("a duplicate submission should succeed %v %s", response.StatusCode, body)
+	}
+
+	response, body = helperGet(t, ts.URL+"/v1/setup")
+	manifest := &ManifestJSON{}
+	if err := json.Unmarshal(body, manifest); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("invalid manifest %v %s", response.StatusCode, body)
+	}
+	if len(manifest.Shards) != 1 || manifest.Shards[0].Reports != 5 {
+		t.Fatalf("unexpected manifest %s", body)
+	}
+
+	response, body = helperGet(t, ts.URL+"/v1/setup/"+manifest.Shards[0].ID)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("setup download failed %v %s", response.StatusCode, body)
+	}
+	setup := &message.SetupMessage{}
+	if err := setup.UnmarshalBinary(body); err != nil {
+		t.Fatalf("invalid setup message %v", err)
+	}
+
+	c, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer c.Close()
+	request, err := c.CreateRequest(setup, tcns)
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	data, err := request.MarshalBinary()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	response, body = helperPost(t, ts.URL+"/v1/query", data)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("query failed %v %s", response.StatusCode, body)
+	}
+	psiResponse := &message.Response{}
+	if err := psiResponse.UnmarshalBinary(body); err != nil {
+		t.Fatalf("invalid response %v", err)
+	}
+	cnt, err := c.GetIntersectionSize(setup, psiResponse)
+	if err != nil {
+		t.Fatalf("failed to compute intersection %v", err)
+	}
+	if int(cnt) < len(tcns)/2 || float64(cnt) > float64(len(tcns)/2)*1.1 {
+		t.Errorf("Invalid intersection %v", cnt)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ts := helperStartServer(t, server.QueryPolicy{MinElements: 2, ClientBudget: 1}, nil)
+	reports, tcns := reporttest.Serialized(t, 1)
+
+	for _, test := range []struct {
+		method string
+		path   string
+		body   []byte
+		status int
+	}{
+		{"GET", "/v1/query", nil, http.StatusMethodNotAllowed},
+		{"POST", "/v1/setup", nil, http.StatusMethodNotAllowed},
+		{"GET", "/v1/setup/1234", nil, http.StatusBadRequest},
+		{"GET", "/v1/setup/" + strings.Repeat("00", 32), nil, http.StatusNotFound},
+		{"POST", "/v1/query", []byte("dummy"), http.StatusBadRequest},
+		{"POST", "/v1/reports", reports[0][:50], http.StatusBadRequest},
+		{"POST", "/v1/reports", make([]byte, maxReportBytes+1), http.StatusRequestEntityTooLarge},
+	} {
+		request, err := http.NewRequest(test.method, ts.URL+test.path, bytes.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%v %v failed %v", test.method, test.path, err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("%v %v: expected status %v, got %v %s", test.method, test.path, test.status, response.StatusCode, body)
+		}
+		if !strings.Contains(string(body), `"error"`) {
+			t.Errorf("%v %v: missing error body %s", test.method, test.path, body)
+		}
+	}
+
+	helperPost(t, ts.URL+"/v1/reports", reports[0])
+	_, body := helperGet(t, ts.URL+"/v1/setup")
+	manifest := &ManifestJSON{}
+	json.Unmarshal(body, manifest)
+	_, body = helperGet(t, ts.URL+"/v1/setup/"+manifest.Shards[0].ID)
+	setup := &message.SetupMessage{}
+	if err := setup.UnmarshalBinary(body); err != nil {
+		t.Fatalf("invalid setup message %v", err)
+	}
+	c, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer c.Close()
+	request, err := c.CreateRequest(setup, tcns[:1])
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	data, _ := request.MarshalBinary()
+	response, body := helperPost(t, ts.URL+"/v1/query", data)
+	if response.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), string(server.QueryTooFewElements)) {
+		t.Errorf("a probing request should be rejected %v %s", response.StatusCode, body)
+	}
+
+	request, err = c.CreateRequest(setup, tcns[:2])
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	data, _ = request.MarshalBinary()
+	if response, body := helperPost(t, ts.URL+"/v1/query", data); response.StatusCode != http.StatusOK {
+		t.Fatalf("the first request should be answered %v %s", response.StatusCode, body)
+	}
+	response, body = helperPost(t, ts.URL+"/v1/query", data)
+	if response.StatusCode != http.StatusForbidden || !strings.Contains(string(body), string(server.QueryClientBudget)) {
+		t.Errorf("a request over the budget should be forbidden %v %s", response.StatusCode, body)
+	}
+
+	//a setup ID chosen by the client does not get a budget of its own.
+	request.SetupID = message.SetupID{1}
+	data, _ = request.MarshalBinary()
+	response, body = helperPost(t, ts.URL+"/v1/query", data)
+	if response.StatusCode != http.StatusNotFound || !strings.Contains(string(body), string(server.QueryUnknownSetup)) {
+		t.Errorf("a request for an unknown setup message should be rejected %v %s", response.StatusCode, body)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	var mu sync.Mutex
+	var readiness error = errors.New("loading reports")
+	ts := helperStartServer(t, server.QueryPolicy{}, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return readiness
+	})
+
+	if response, _ := helperGet(t, ts.URL+"/healthz"); response.StatusCode != http.StatusOK {
+		t.Errorf("healthz failed %v", response.StatusCode)
+	}
+	if response, _ := helperGet(t, ts.URL+"/readyz"); response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readyz should fail while loading, got %v", response.StatusCode)
+	}
+	mu.Lock()
+	readiness = nil
+	mu.Unlock()
+	if response, _ := helperGet(t, ts.URL+"/readyz"); response.StatusCode != http.StatusOK {
+		t.Errorf("readyz failed %v", response.StatusCode)
+	}
+	if response, _ := helperGet(t, ts.URL+"/metrics"); response.StatusCode != http.StatusOK {
+		t.Errorf("metrics failed %v", response.StatusCode)
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	if _, err := NewHandler(Config{}); err == nil {
+		t.Errorf("NewHandler without shards and queries should fail")
+	}
+}
@@ -0,0 +1,377 @@
+//Package restclient runs the TCN-PSI protocol against a server exposing the REST API of the
+//rest package: it downloads the setup messages, sends the PSI requests and submits reports,
+//with timeouts, retries and an optional setup message cache.
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/store"
+	"github.com/openmined/tcn-psi/tcn"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//DefaultTimeout bounds a single attempt of a call.
+	DefaultTimeout = 30 * time.Second
+	//DefaultRetries is the number of times a temporary failure is retried.
+	DefaultRetries = 3
+	//DefaultBackoff is the delay before the first retry.
+	DefaultBackoff = 500 * time.Millisecond
+	//DefaultMinElements is the number of elements the requests are padded to by default, the
+	//minimum tcnpsi-server and the tenants require unless configured otherwise.
+	DefaultMinElements = 64
+)
+
+//maxResponseBytes bounds the size of a response body.
+const maxResponseBytes = 256 << 20
+
+//errResponseTooLarge is returned when a response body exceeds maxResponseBytes.
+var errResponseTooLarge = errors.New("response body too large")
+
+//setupTTL is how long a cached setup message stays fresh when the server does not say
+//otherwise. Setup messages are addressed by their content, so a cached copy never goes stale.
+const setupTTL = 24 * time.Hour
+
+//Shard describes a setup message published by the server.
+type Shard struct {
+	ID message.SetupID
+	//Start and End delimit the ingestion day covered by the shard, in UTC.
+	Start   time.Time
+	End     time.Time
+	Reports int
+	//Size of the encoded setup message in bytes.
+	Size int
+}
+
+//Receipt acknowledges a submitted report.
+type Receipt struct {
+	ID         store.ReportID
+	IngestedAt time.Time
+	//Duplicate is set if the server already held the report, e.g. when a submission is retried.
+	Duplicate bool
+}
+
+//Client calls a TCN-PSI REST server.
+//
+//A Client is safe for concurrent use by multiple goroutines, as long as its fields are not
+//modified.
+type Client struct {
+	baseURL string
+	http    *http.Client
+
+	//Timeout bounds every attempt of a call. Zero disables the timeout; use the context to bound
+	//the whole call, retries included.
+	Timeout time.Duration
+	//Retries is the number of times a call failing with a transport error, a timeout or a
+	//temporary Error is attempted again.
+	Retries int
+	//Backoff is the delay before the first retry. It doubles with every retry.
+	Backoff time.Duration
+	//Padding is applied to every request. It must reach the minimum number of elements of the
+	//server. Defaults to PadToPowerOfTwo(DefaultMinElements).
+	Padding client.Padding
+	//Cache, if set, stores the downloaded setup messages.
+	Cache *client.SetupCache
+	//NewClient creates the client context used by a check. Defaults to client.Create.
+	NewClient func() (*client.TCNClient, error)
+	//Verifier, if set, authenticates the setup messages before they are queried.
+	Verifier *client.SetupVerifier
+}
+
+//NewClient returns a client of the server at baseURL, e.g. "https://tcn.example.org", using
+//httpClient, or http.DefaultClient if nil.
+//
+//Returns an error if baseURL is not an absolute HTTP(S) URL.
+func NewClient(baseURL string, httpClient *http.Client) (*Client, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errors.New("the server URL must be an absolute http or https URL")
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		http:      httpClient,
+		Timeout:   DefaultTimeout,
+		Retries:   DefaultRetries,
+		Backoff:   DefaultBackoff,
+		Padding:   client.PadToPowerOfTwo(DefaultMinElements),
+		NewClient: client.Create,
+	}, nil
+}
+
+//Manifest returns the setup messages published by the server, oldest first.
+func (c *Client) Manifest(ctx context.Context) ([]Shard, error) {
+	_, data, err := c.do(ctx, http.MethodGet, "/v1/setup", nil)
+	if err != nil {
+		return nil, err
+	}
+	manifest := struct {
+		Shards []struct {
+			ID      string    `json:"id"`
+			Start   time.Time `json:"start"`
+			End     time.Time `json:"end"`
+			Reports int       `json:"reports"`
+			Size    int       `json:"size"`
+		} `json:"shards"`
+	}{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	shards := []Shard{}
+	for _, shard := range manifest.Shards {
+		id, err := message.ParseSetupID(shard.ID)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, Shard{
+			ID:      id,
+			Start:   shard.Start,
+			End:     shard.End,
+			Reports: shard.Reports,
+			Size:    shard.Size,
+		})
+	}
+	return shards, nil
+}
+
+//Setup returns the setup message id, from the cache if it holds it.
+//
+//Returns an error if the download fails or if the server returns another setup message.
+func (c *Client) Setup(ctx context.Context, id message.SetupID) (*message.SetupMessage, error) {
+	if c.Cache == nil {
+		setup, _, err := c.downloadSetup(ctx, id)
+		return setup, err
+	}
+	return c.Cache.Get(c.baseURL+"/v1/setup/"+id.String(), func(source string, current *client.CacheEntry) (*client.FetchResult, error) {
+		//the signed setup messages are signed again periodically, the verifier needs the last
+		//signature.
+		if current != nil && current.ID == id.String() && c.Verifier == nil {
+			return &client.FetchResult{NotModified: true, ExpiresAt: time.Now().Add(setupTTL)}, nil
+		}
+		setup, ttl, err := c.downloadSetup(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return &client.FetchResult{Setup: setup, ExpiresAt: time.Now().Add(ttl)}, nil
+	})
+}
+
+//downloadSetup downloads the setup message id and returns it along with how long it may be
+//cached.
+func (c *Client) downloadSetup(ctx context.Context, id message.SetupID) (*message.SetupMessage, time.Duration, error) {
+	header, data, err := c.do(ctx, http.MethodGet, "/v1/setup/"+id.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	setup := &message.SetupMessage{}
+	if err := setup.UnmarshalBinary(data); err != nil {
+		return nil, 0, err
+	}
+	if err := setup.Verify(); err != nil {
+		return nil, 0, err
+	}
+	if setup.ID != id {
+		return nil, 0, errors.New("the server returned another setup message")
+	}
+	return setup, maxAge(header), nil
+}
+
+//maxAge returns the max-age directive of the Cache-Control header, or setupTTL.
+func maxAge(header http.Header) time.Duration {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return setupTTL
+}
+
+//ProcessRequest sends request to the server and returns its response.
+func (c *Client) ProcessRequest(ctx context.Context, request *message.Request) (*message.Response, error) {
+	if request == nil {
+		return nil, errors.New("invalid request")
+	}
+	data, err := request.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	_, data, err = c.do(ctx, http.MethodPost, "/v1/query", data)
+	if err != nil {
+		return nil, err
+	}
+	response := &message.Response{}
+	if err := response.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+//Check returns how many of contacts were reported to the server, over every published setup
+//message, up to the false positives of the filters.
+//
+//Returns an error if any call or any PSI round fails.
+func (c *Client) Check(ctx context.Context, contacts []tcn.TemporaryContactNumber) (int64, error) {
+	//the zero day precedes every shard, so every shard is queried.
+	return c.CheckEncounters(ctx, map[time.Time][]tcn.TemporaryContactNumber{{}: contacts})
+}
+
+//CheckEncounters returns how many of the contacts in encounters, which maps the start of a UTC
+//day to the contacts observed during that day, were reported to the server. Only the shards
+//which may hold these contacts are downloaded and queried, see client.SelectShards.
+//
+//Every check uses a fresh client context, closed once the check completes.
+//
+//Returns an error if any call or any PSI round fails.
+func (c *Client) CheckEncounters(ctx context.Context, encounters map[time.Time][]tcn.TemporaryContactNumber) (int64, error) {
+	shards, err := c.Manifest(ctx)
+	if err != nil {
+		return 0, err
+	}
+	setups := []*message.SetupMessage{}
+	for _, shard := range shards {
+		if !covers(shard, encounters) {
+			continue
+		}
+		setup, err := c.Setup(ctx, shard.ID)
+		if err != nil {
+			return 0, err
+		}
+		setups = append(setups, setup)
+	}
+	queries := client.SelectShards(setups, encounters)
+	if len(queries) == 0 {
+		return 0, nil
+	}
+
+	newClient := c.NewClient
+	if newClient == nil {
+		newClient = client.Create
+	}
+	tcnClient, err := newClient()
+	if err != nil {
+		return 0, err
+	}
+	defer tcnClient.Close()
+	if c.Verifier != nil {
+		tcnClient.SetVerifier(c.Verifier)
+	}
+	padding := c.Padding
+	if padding == nil {
+		padding = client.PadToPowerOfTwo(DefaultMinElements)
+	}
+	return client.QueryShards(tcnClient, queries, padding, func(request *message.Request) (*message.Response, error) {
+		return c.ProcessRequest(ctx, request)
+	})
+}
+
+//covers returns true if shard may hold a contact in encounters.
+func covers(shard Shard, encounters map[time.Time][]tcn.TemporaryContactNumber) bool {
+	for day, contacts := range encounters {
+		if len(contacts) > 0 && (shard.End.IsZero() || day.Before(shard.End)) {
+			return true
+		}
+	}
+	return false
+}
+
+//SubmitReport submits a signed report to the server.
+//
+//Returns an error if the report cannot be serialized or if the server rejects it.
+func (c *Client) SubmitReport(ctx context.Context, report *tcn.SignedReport) (*Receipt, error) {
+	if report == nil || report.Report == nil {
+		return nil, errors.New("invalid report")
+	}
+	data, err := report.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	_, data, err = c.do(ctx, http.MethodPost, "/v1/reports", data)
+	if err != nil {
+		return nil, err
+	}
+	body := struct {
+		ID         string    `json:"id"`
+		IngestedAt time.Time `json:"ingested_at"`
+		Duplicate  bool      `json:"duplicate"`
+	}{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	id, err := store.ParseReportID(body.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &Receipt{ID: id, IngestedAt: body.IngestedAt, Duplicate: body.Duplicate}, nil
+}
+
+//do calls the server, retrying the temporary failures, and returns the headers and the body of
+//the successful response. The queries are not idempotent, as the server charges them against
+//the budgets of the client.
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (http.Header, []byte, error) {
+	idempotent := path != "/v1/query"
+	for attempt := 0; ; attempt++ {
+		header, data, err := c.attempt(ctx, method, path, body)
+		if err == nil || attempt >= c.Retries || !retryable(ctx, err, idempotent) {
+			return header, data, err
+		}
+		if err := sleep(ctx, backoff(c.Backoff, attempt, err)); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+//attempt calls the server once.
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte) (http.Header, []byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/octet-stream")
+	}
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxResponseBytes {
+		return nil, nil, errResponseTooLarge
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, nil, newError(method+" "+path, response, data)
+	}
+	return response.Header, data, nil
+}
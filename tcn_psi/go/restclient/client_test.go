@@ -0,0 +1,387 @@
+package restclient
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/ingest"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/rest"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/store"
+	"github.com/openmined/tcn-psi/tcn"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//standIn is a local TCN-PSI server which counts the calls per path and can fail them on demand.
+type standIn struct {
+	*httptest.Server
+	handler http.Handler
+	shards  *server.DayShards
+
+	mu    sync.Mutex
+	calls map[string]int
+	//fail answers the next calls with this status code, while failures is positive.
+	fail     int
+	failures int
+	delay    time.Duration
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.calls[r.URL.Path]++
+	fail := s.failures > 0
+	if fail {
+		s.failures--
+	}
+	status, delay := s.fail, s.delay
+	s.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fail {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"injected failure"}`))
+		return
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *standIn) failNext(status, failures int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail, s.failures = status, failures
+}
+
+func (s *standIn) setDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = delay
+}
+
+func (s *standIn) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[path]
+}
+
+func helperStartServer(t *testing.T) *standIn {
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	t.Cleanup(func() { tcnServer.Close() })
+	shards := server.NewDayShards(tcnServer, 0.001, 1000, 7)
+	handler, err := rest.NewHandler(rest.Config{
+		Shards:  shards,
+		//the servers and the tenants require 64 elements by default.
+		Queries: server.NewQueryGuard(tcnServer, shards, server.QueryPolicy{MinElements: 64}),
+		Ingest:  ingest.NewService(store.NewMemory(), ingest.Config{Sink: shards}),
+	})
+	if err != nil {
+		t.Fatalf("NewHandler failed %v", err)
+	}
+	s := &standIn{handler: handler, shards: shards, calls: map[string]int{}}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func helperNewClient(t *testing.T, s *standIn) *Client {
+	c, err := NewClient(s.URL+"/", s.Client())
+	if err != nil {
+		t.Fatalf("NewClient failed %v", err)
+	}
+	c.Backoff = time.Millisecond
+	return c
+}
+
+func TestNewClient(t *testing.T) {
+	for _, baseURL := range []string{"", "example.org", "ftp://example.org", "http://", ":"} {
+		if _, err := NewClient(baseURL, nil); err == nil {
+			t.Errorf("NewClient should reject %q", baseURL)
+		}
+	}
+	c, err := NewClient("https://example.org/", nil)
+	if err != nil {
+		t.Fatalf("NewClient failed %v", err)
+	}
+	if c.http != http.DefaultClient || c.baseURL != "https://example.org" {
+		t.Errorf("unexpected client %v %v", c.http, c.baseURL)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s := helperStartServer(t)
+	c := helperNewClient(t, s)
+	ctx := context.Background()
+	reports, tcns := reporttest.Reports(t, 10)
+
+	for idx := 0; idx < len(reports)/2; idx++ {
+		receipt, err := c.SubmitReport(ctx, reports[idx])
+		if err != nil {
+			t.Fatalf("SubmitReport failed %v", err)
+		}
+		expected, _ := store.ComputeReportID(reports[idx])
+		if receipt.ID != expected || receipt.Duplicate || receipt.IngestedAt.IsZero() {
+			t.Errorf("unexpected receipt %+v", receipt)
+		}
+	}
+	receipt, err := c.SubmitReport(ctx, reports[0])
+	if err != nil || !receipt.Duplicate {
+		t.Errorf("a duplicate submission should succeed %+v %v", receipt, err)
+	}
+
+	shards, err := c.Manifest(ctx)
+	if err != nil {
+		t.Fatalf("Manifest failed %v", err)
+	}
+	if len(shards) != 1 || shards[0].Reports != 5 || !shards[0].End.After(shards[0].Start) {
+		t.Fatalf("unexpected manifest %+v", shards)
+	}
+
+	cnt, err := c.Check(ctx, tcns)
+	if err != nil {
+		t.Fatalf("Check failed %v", err)
+	}
+	if int(cnt) < len(tcns)/2 || float64(cnt) > float64(len(tcns)/2)*1.1 {
+		t.Errorf("Invalid intersection %v", cnt)
+	}
+
+	c.Padding = client.PadToPowerOfTwo(128)
+	if padded, err := c.Check(ctx, tcns); err != nil || padded != cnt {
+		t.Errorf("padding should not change the intersection %v %v", padded, err)
+	}
+	c.Padding = client.NoPadding
+	if _, err := c.Check(ctx, tcns[:10]); err == nil {
+		t.Errorf("a request below the minimum of the server should be rejected")
+	} else if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusBadRequest {
+		t.Errorf("a request below the minimum of the server should be rejected %v", err)
+	}
+	if cnt, err := c.Check(ctx, nil); err != nil || cnt != 0 {
+		t.Errorf("checking no contacts should not query the server %v %v", cnt, err)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	s := helperStartServer(t)
+	c := helperNewClient(t, s)
+	ctx := context.Background()
+	reports, tcns := reporttest.Reports(t, 2)
+	if _, err := c.SubmitReport(ctx, reports[0]); err != nil {
+		t.Fatalf("SubmitReport failed %v", err)
+	}
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c.Verifier, err = client.NewSetupVerifier(time.Hour, public)
+	if err != nil {
+		t.Fatalf("NewSetupVerifier failed %v", err)
+	}
+	if _, err := c.Check(ctx, tcns); err != message.ErrUnsigned {
+		t.Errorf("an unsigned setup should be rejected %v", err)
+	}
+	if err := s.shards.SetSigningKey(private); err != nil {
+		t.Fatalf("SetSigningKey failed %v", err)
+	}
+	if cnt, err := c.Check(ctx, tcns); err != nil || cnt == 0 {
+		t.Errorf("a signed setup should be accepted %v %v", cnt, err)
+	}
+
+	_, other, _ := ed25519.GenerateKey(nil)
+	if err := s.shards.SetSigningKey(other); err != nil {
+		t.Fatalf("SetSigningKey failed %v", err)
+	}
+	if _, err := c.Check(ctx, tcns); err != message.ErrInvalidSignature {
+		t.Errorf("a setup signed with another key should be rejected %v", err)
+	}
+}
+
+func TestCheckEncounters(t *testing.T) {
+	s := helperStartServer(t)
+	c := helperNewClient(t, s)
+	ctx := context.Background()
+	reports, tcns := reporttest.Reports(t, 2)
+	for _, report := range reports {
+		if _, err := c.SubmitReport(ctx, report); err != nil {
+			t.Fatalf("SubmitReport failed %v", err)
+		}
+	}
+	shards, err := c.Manifest(ctx)
+	if err != nil || len(shards) != 1 {
+		t.Fatalf("Manifest failed %v %v", shards, err)
+	}
+
+	cnt, err := c.CheckEncounters(ctx, map[time.Time][]tcn.TemporaryContactNumber{shards[0].Start: tcns})
+	if err != nil || int(cnt) < len(tcns) {
+		t.Errorf("CheckEncounters failed %v %v", cnt, err)
+	}
+
+	//contacts observed after the shard ends cannot be in its reports.
+	queries := s.count("/v1/query")
+	cnt, err = c.CheckEncounters(ctx, map[time.Time][]tcn.TemporaryContactNumber{shards[0].End: tcns})
+	if err != nil || cnt != 0 {
+		t.Errorf("CheckEncounters failed %v %v", cnt, err)
+	}
+	if s.count("/v1/query") != queries {
+		t.Errorf("a shard ending before the encounters should not be queried")
+	}
+}
+
+func TestSetupCache(t *testing.T) {
+	s := helperStartServer(t)
+	c := helperNewClient(t, s)
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "restclient")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	c.Cache, err = client.NewSetupCache(dir, 0)
+	if err != nil {
+		t.Fatalf("NewSetupCache failed %v", err)
+	}
+
+	reports, tcns := reporttest.Reports(t, 1)
+	if _, err := c.SubmitReport(ctx, reports[0]); err != nil {
+		t.Fatalf("SubmitReport failed %v", err)
+	}
+	shards, err := c.Manifest(ctx)
+	if err != nil || len(shards) != 1 {
+		t.Fatalf("Manifest failed %v %v", shards, err)
+	}
+	path := "/v1/setup/" + shards[0].ID.String()
+	for idx := 0; idx < 3; idx++ {
+		if cnt, err := c.Check(ctx, tcns); err != nil || int(cnt) < len(tcns) {
+			t.Fatalf("Check failed %v %v", cnt, err)
+		}
+	}
+	if s.count(path) != 1 {
+		t.Errorf("the setup message should be downloaded once, got %v", s.count(path))
+	}
+	if entries := c.Cache.Entries(); len(entries) != 1 || entries[0].ID != shards[0].ID.String() {
+		t.Errorf("unexpected cache entries %+v", entries)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	s := helperStartServer(t)
+	c := helperNewClient(t, s)
+	ctx := context.Background()
+
+	s.failNext(http.StatusServiceUnavailable, 2)
+	if _, err := c.Manifest(ctx); err != nil {
+		t.Errorf("temporary failures should be retried %v", err)
+	}
+	if s.count("/v1/setup") != 3 {
+		t.Errorf("unexpected number of attempts %v", s.count("/v1/setup"))
+	}
+
+	s.failNext(http.StatusTooManyRequests, c.Retries+1)
+	_, err := c.Manifest(ctx)
+	e, ok := err.(*Error)
+	if !ok || e.StatusCode != http.StatusTooManyRequests || e.Message != "injected failure" || e.Op != "GET /v1/setup" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if s.count("/v1/setup") != 3+c.Retries+1 {
+		t.Errorf("unexpected number of attempts %v", s.count("/v1/setup"))
+	}
+
+	//client errors are not retried.
+	s.failNext(http.StatusBadRequest, 1)
+	if _, err := c.Manifest(ctx); err == nil {
+		t.Errorf("Manifest should fail")
+	}
+	_, err = c.Setup(ctx, message.SetupID{})
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected error %v", err)
+	}
+	if s.count("/v1/setup/"+message.SetupID{}.String()) != 1 {
+		t.Errorf("a missing setup message should not be retried")
+	}
+	if _, err := c.SubmitReport(ctx, &tcn.SignedReport{}); err == nil {
+		t.Errorf("SubmitReport should reject an invalid report")
+	}
+
+	//the server may have charged a query which failed ambiguously.
+	reports, tcns := reporttest.Reports(t, 1)
+	if _, err := c.SubmitReport(ctx, reports[0]); err != nil {
+		t.Fatalf("SubmitReport failed %v", err)
+	}
+	shards, err := c.Manifest(ctx)
+	if err != nil || len(shards) != 1 {
+		t.Fatalf("Manifest failed %v %v", shards, err)
+	}
+	setup, err := c.Setup(ctx, shards[0].ID)
+	if err != nil {
+		t.Fatalf("Setup failed %v", err)
+	}
+	psiClient, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer psiClient.Close()
+	request, err := psiClient.CreatePaddedRequest(setup, tcns, client.PadToPowerOfTwo(DefaultMinElements))
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	s.failNext(http.StatusGatewayTimeout, 1)
+	if _, err := c.ProcessRequest(ctx, request); err == nil {
+		t.Errorf("ProcessRequest should fail")
+	}
+	if s.count("/v1/query") != 1 {
+		t.Errorf("an ambiguous query failure should not be retried %v", s.count("/v1/query"))
+	}
+	s.failNext(http.StatusServiceUnavailable, 1)
+	if _, err := c.ProcessRequest(ctx, request); err != nil {
+		t.Errorf("a refused query should be retried %v", err)
+	}
+	if s.count("/v1/query") != 3 {
+		t.Errorf("unexpected number of attempts %v", s.count("/v1/query"))
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := helperStartServer(t)
+	c := helperNewClient(t, s)
+	c.Timeout = 20 * time.Millisecond
+	c.Retries = 1
+	s.setDelay(time.Second)
+
+	start := time.Now()
+	if _, err := c.Manifest(context.Background()); err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Errorf("Manifest should time out %v", err)
+	}
+	if s.count("/v1/setup") != 2 {
+		t.Errorf("a timed out attempt should be retried %v", s.count("/v1/setup"))
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("the timeout was not enforced")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Retries = 5
+	if _, err := c.Manifest(ctx); err == nil {
+		t.Errorf("Manifest should fail with a cancelled context")
+	}
+	if s.count("/v1/setup") > 3 {
+		t.Errorf("a cancelled call should not be retried %v", s.count("/v1/setup"))
+	}
+}
@@ -0,0 +1,107 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//maxBackoff bounds the delay between two attempts.
+const maxBackoff = 30 * time.Second
+
+//Error is returned when the server answers a call with an error status code.
+type Error struct {
+	//Op is the failed call, e.g. "POST /v1/query".
+	Op string
+	//StatusCode is the HTTP status code of the response.
+	StatusCode int
+	//Message is the error reported by the server.
+	Message string
+	//RetryAfter is the delay requested by the server before the next attempt, if any.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v %v", e.Op, e.StatusCode, e.Message)
+}
+
+//Temporary returns true if the call may succeed once retried: the server was overloaded,
+//unavailable or rate limited the client.
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+//newError returns the error reported by a response with an error status code and body data.
+func newError(op string, response *http.Response, data []byte) *Error {
+	e := &Error{Op: op, StatusCode: response.StatusCode}
+	body := struct {
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(data, &body); err == nil && body.Error != "" {
+		e.Message = body.Error
+	} else {
+		e.Message = strings.TrimSpace(string(data))
+	}
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+//retryable returns true if a call which failed with err should be attempted again. A call which
+//is not idempotent, like a query charged against the budgets, is only attempted again if the
+//server answered that it did not process it.
+func retryable(ctx context.Context, err error, idempotent bool) bool {
+	if ctx.Err() != nil || err == errResponseTooLarge {
+		return false
+	}
+	if e, ok := err.(*Error); ok {
+		if !idempotent {
+			return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+		}
+		return e.Temporary()
+	}
+	//transport errors, including the timeout of a single attempt, may happen after the server
+	//processed the call.
+	return idempotent
+}
+
+//backoff returns the delay before the attempt following attempt, which failed with err. The
+//delay doubles with every attempt, with jitter, and honours the Retry-After header.
+func backoff(base time.Duration, attempt int, err error) time.Duration {
+	delay := base
+	for idx := 0; idx < attempt && delay < maxBackoff; idx++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+	}
+	if e, ok := err.(*Error); ok && e.RetryAfter > delay {
+		delay = e.RetryAfter
+	}
+	return delay
+}
+
+//sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewError(t *testing.T) {
+	response := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	response.Header.Set("Retry-After", "7")
+	e := newError("POST /v1/reports", response, []byte(`{"error":"too many reports submitted"}`))
+	if e.Message != "too many reports submitted" || e.RetryAfter != 7*time.Second || !e.Temporary() {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if e.Error() != "POST /v1/reports: 429 too many reports submitted" {
+		t.Errorf("unexpected message %v", e.Error())
+	}
+
+	response = &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}}
+	e = newError("GET /v1/setup", response, []byte("bad request\n"))
+	if e.Message != "bad request" || e.RetryAfter != 0 || e.Temporary() {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	ctx := context.Background()
+	if !retryable(ctx, errors.New("connection reset"), true) {
+		t.Errorf("transport errors should be retried")
+	}
+	if retryable(ctx, &Error{StatusCode: http.StatusNotFound}, true) || !retryable(ctx, &Error{StatusCode: http.StatusBadGateway}, true) {
+		t.Errorf("only temporary errors should be retried")
+	}
+	if retryable(ctx, errResponseTooLarge, true) {
+		t.Errorf("a response too large should not be retried")
+	}
+	if retryable(ctx, errors.New("connection reset"), false) || retryable(ctx, &Error{StatusCode: http.StatusGatewayTimeout}, false) {
+		t.Errorf("ambiguous failures of a call which is not idempotent should not be retried")
+	}
+	if !retryable(ctx, &Error{StatusCode: http.StatusServiceUnavailable}, false) || retryable(ctx, &Error{StatusCode: http.StatusForbidden}, false) {
+		t.Errorf("only the calls refused by the server should be retried")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if retryable(cancelled, errors.New("connection reset"), true) {
+		t.Errorf("a cancelled call should not be retried")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		expected := time.Second << uint(attempt)
+		if expected > maxBackoff {
+			expected = maxBackoff
+		}
+		delay := backoff(time.Second, attempt, nil)
+		if delay < expected/2 || delay > expected {
+			t.Errorf("attempt %v: delay %v out of [%v, %v]", attempt, delay, expected/2, expected)
+		}
+	}
+	if delay := backoff(time.Millisecond, 0, &Error{RetryAfter: time.Minute}); delay != time.Minute {
+		t.Errorf("Retry-After should be honoured, got %v", delay)
+	}
+	if delay := backoff(0, 3, nil); delay != 0 {
+		t.Errorf("unexpected delay %v", delay)
+	}
+}
@@ -0,0 +1,154 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/store"
+	pb "github.com/openmined/tcn-psi/tcnpsipb"
+	"google.golang.org/grpc"
+	"time"
+)
+
+//DefaultChunkSize is the size of the chunks of the requests sent with ProcessRequestStream. It
+//stays below the default 4 MiB message size limit of gRPC.
+const DefaultChunkSize = 1 << 20
+
+//Client calls a TCNPSI service and converts its messages to the types of the message package.
+type Client struct {
+	client pb.TCNPSIClient
+	//ChunkSize is the size of the chunks of large requests. Requests up to ChunkSize bytes are
+	//sent in a single call.
+	ChunkSize int
+}
+
+//NewClient returns a client calling the service over conn.
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{client: pb.NewTCNPSIClient(conn), ChunkSize: DefaultChunkSize}
+}
+
+//Manifest returns the published setup messages, oldest first.
+func (c *Client) Manifest(ctx context.Context) ([]server.ShardInfo, error) {
+	manifest, err := c.client.GetManifest(ctx, &pb.GetManifestRequest{})
+	if err != nil {
+		return nil, err
+	}
+	infos := []server.ShardInfo{}
+	for _, shard := range manifest.GetShards() {
+		info := server.ShardInfo{
+			Start:   time.Unix(shard.GetStart(), 0).UTC(),
+			End:     time.Unix(shard.GetEnd(), 0).UTC(),
+			Reports: int(shard.GetReports()),
+			Size:    int(shard.GetSize()),
+		}
+		if len(shard.GetId()) != len(info.ID) {
+			return nil, errors.New("invalid setup ID in the manifest")
+		}
+		copy(info.ID[:], shard.GetId())
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+//Setup downloads a setup message. The zero ID selects the most recent setup message.
+func (c *Client) Setup(ctx context.Context, id message.SetupID) (*message.SetupMessage, error) {
+	in := &pb.GetSetupRequest{}
+	if id != (message.SetupID{}) {
+		in.Id = id[:]
+	}
+	out, err := c.client.GetSetup(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	setup := &message.SetupMessage{}
+	if err := setup.UnmarshalBinary(out.GetMessage()); err != nil {
+		return nil, err
+	}
+	if id != (message.SetupID{}) && setup.ID != id {
+		return nil, errors.New("the server returned another setup message")
+	}
+	return setup, nil
+}
+
+//ProcessRequest sends request and returns the response of the server. Requests larger than
+//ChunkSize are streamed in chunks.
+func (c *Client) ProcessRequest(ctx context.Context, request *message.Request) (*message.Response, error) {
+	if request == nil {
+		return nil, errors.New("invalid request")
+	}
+	data, err := request.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	var out *pb.Response
+	if c.ChunkSize <= 0 || len(data) <= c.ChunkSize {
+		out, err = c.client.ProcessRequest(ctx, &pb.Request{Message: data})
+	} else {
+		out, err = c.stream(ctx, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	response := &message.Response{}
+	if err := response.UnmarshalBinary(out.GetMessage()); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+//stream sends data in chunks.
+func (c *Client) stream(ctx context.Context, data []byte) (*pb.Response, error) {
+	stream, err := c.client.ProcessRequestStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for len(data) > 0 {
+		size := c.ChunkSize
+		if size > len(data) {
+			size = len(data)
+		}
+		if err := stream.Send(&pb.RequestChunk{Data: data[:size]}); err != nil {
+			//the status of the call explains the failure.
+			_, err = stream.CloseAndRecv()
+			return nil, err
+		}
+		data = data[size:]
+	}
+	return stream.CloseAndRecv()
+}
+
+//ReportResult is the outcome of the submission of a report.
+type ReportResult struct {
+	ID         store.ReportID
+	IngestedAt time.Time
+	Duplicate  bool
+	//Err is the reason of the rejection, nil if the report was accepted.
+	Err error
+}
+
+//SubmitReports submits serialized signed reports and returns their results, in order.
+func (c *Client) SubmitReports(ctx context.Context, reports [][]byte) ([]ReportResult, error) {
+	out, err := c.client.SubmitReports(ctx, &pb.SubmitReportsRequest{Reports: reports})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.GetResults()) != len(reports) {
+		return nil, errors.New("invalid number of results")
+	}
+	results := make([]ReportResult, len(reports))
+	for idx, result := range out.GetResults() {
+		if result.GetError() != "" {
+			results[idx].Err = errors.New(result.GetError())
+			continue
+		}
+		if len(result.GetId()) != len(results[idx].ID) {
+			return nil, errors.New("invalid report ID")
+		}
+		copy(results[idx].ID[:], result.GetId())
+		results[idx].IngestedAt = time.Unix(0, result.GetIngestedAt()).UTC()
+		results[idx].Duplicate = result.GetDuplicate()
+	}
+	return results, nil
+}
@@ -0,0 +1,191 @@
+package rpc
+
+import (
+	"context"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/ingest"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+)
+
+//helperStartService serves a service on an in-process listener and returns a client for it.
+func helperStartService(t *testing.T, policy server.QueryPolicy, maxRequestBytes int) *Client {
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	t.Cleanup(func() { tcnServer.Close() })
+	shards := server.NewDayShards(tcnServer, 0.001, 100, 7)
+	service, err := NewService(Config{
+		Shards:          shards,
+		Queries:         server.NewQueryGuard(tcnServer, shards, policy),
+		Ingest:          ingest.NewService(store.NewMemory(), ingest.Config{Sink: shards}),
+		MaxRequestBytes: maxRequestBytes,
+	})
+	if err != nil {
+		t.Fatalf("NewService failed %v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	service.Register(grpcServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("failed to dial %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewClient(conn)
+}
+
+func helperCheckCode(t *testing.T, err error, code codes.Code) {
+	if status.Code(err) != code {
+		t.Errorf("expected code %v, got %v", code, err)
+	}
+}
+
+//helperRound runs a full round with chunkSize and checks the intersection.
+func helperRound(t *testing.T, chunkSize int) {
+	c := helperStartService(t, server.QueryPolicy{}, 0)
+	c.ChunkSize = chunkSize
+	ctx := context.Background()
+	reports, tcns := reporttest.Serialized(t, 20)
+
+	serverReports := [][]byte{}
+	for idx := 0; idx < len(reports); idx += 2 {
+		serverReports = append(serverReports, reports[idx])
+	}
+	results, err := c.SubmitReports(ctx, serverReports)
+	if err != nil {
+		t.Fatalf("SubmitReports failed %v", err)
+	}
+	for _, result := range results {
+		if result.Err != nil || result.Duplicate {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+
+	manifest, err := c.Manifest(ctx)
+	if err != nil || len(manifest) != 1 || manifest[0].Reports != len(serverReports) {
+		t.Fatalf("unexpected manifest %v %v", manifest, err)
+	}
+	setup, err := c.Setup(ctx, manifest[0].ID)
+	if err != nil {
+		t.Fatalf("Setup failed %v", err)
+	}
+	latest, err := c.Setup(ctx, message.SetupID{})
+	if err != nil || latest.ID != setup.ID {
+		t.Errorf("the latest setup message should be returned without an ID %v", err)
+	}
+
+	psiClient, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer psiClient.Close()
+	request, err := psiClient.CreateRequest(setup, tcns)
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	response, err := c.ProcessRequest(ctx, request)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed %v", err)
+	}
+	cnt, err := psiClient.GetIntersectionSize(setup, response)
+	if err != nil {
+		t.Fatalf("failed to compute intersection %v", err)
+	}
+	if int(cnt) < len(tcns)/2 || float64(cnt) > float64(len(tcns)/2)*1.1 {
+		t.Errorf("Invalid intersection %v", cnt)
+	}
+}
+
+func TestRoundUnary(t *testing.T) {
+	helperRound(t, DefaultChunkSize)
+}
+
+func TestRoundStream(t *testing.T) {
+	helperRound(t, 256)
+}
+
+func TestSubmitReports(t *testing.T) {
+	c := helperStartService(t, server.QueryPolicy{}, 0)
+	ctx := context.Background()
+	reports, _ := reporttest.Serialized(t, 2)
+
+	results, err := c.SubmitReports(ctx, [][]byte{reports[0], reports[1][:50], reports[0]})
+	if err != nil {
+		t.Fatalf("SubmitReports failed %v", err)
+	}
+	if results[0].Err != nil || results[0].Duplicate {
+		t.Errorf("the first report should be accepted %+v", results[0])
+	}
+	if results[1].Err == nil {
+		t.Errorf("the truncated report should be rejected")
+	}
+	if results[2].Err != nil || !results[2].Duplicate || results[2].ID != results[0].ID {
+		t.Errorf("the third report should be a duplicate %+v", results[2])
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := helperStartService(t, server.QueryPolicy{MinElements: 100}, 512)
+	c.ChunkSize = 128
+	ctx := context.Background()
+
+	_, err := c.Setup(ctx, message.SetupID{})
+	helperCheckCode(t, err, codes.NotFound)
+	_, err = c.Setup(ctx, message.SetupID{1})
+	helperCheckCode(t, err, codes.NotFound)
+
+	reports, tcns := reporttest.Serialized(t, 1)
+	if _, err := c.SubmitReports(ctx, reports); err != nil {
+		t.Fatalf("SubmitReports failed %v", err)
+	}
+	setup, err := c.Setup(ctx, message.SetupID{})
+	if err != nil {
+		t.Fatalf("Setup failed %v", err)
+	}
+	psiClient, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer psiClient.Close()
+
+	request, err := psiClient.CreateRequest(setup, tcns[:1])
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	_, err = c.ProcessRequest(ctx, request)
+	helperCheckCode(t, err, codes.InvalidArgument)
+
+	request, err = psiClient.CreatePaddedRequest(setup, tcns, client.PadToFixed(128))
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	_, err = c.ProcessRequest(ctx, request)
+	helperCheckCode(t, err, codes.ResourceExhausted)
+
+	_, err = c.ProcessRequest(ctx, nil)
+	if err == nil {
+		t.Errorf("ProcessRequest without a request should fail")
+	}
+}
+
+func TestNewService(t *testing.T) {
+	if _, err := NewService(Config{}); err == nil {
+		t.Errorf("NewService without shards and queries should fail")
+	}
+}
@@ -0,0 +1,222 @@
+//Package rpc exposes a TCN-PSI server over gRPC, with the service defined in
+//tcn_psi/proto/tcn_psi.proto, and wraps the generated client.
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/openmined/tcn-psi/ingest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	pb "github.com/openmined/tcn-psi/tcnpsipb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+)
+
+//DefaultMaxRequestBytes is the default maximum size of a request sent in chunks.
+const DefaultMaxRequestBytes = 64 << 20
+
+//Config configures the service.
+type Config struct {
+	//Shards publishes the setup messages. Required.
+	Shards *server.DayShards
+	//Queries answers the requests of the clients. Use a zero QueryPolicy to answer every
+	//request. Required.
+	Queries *server.QueryGuard
+	//Ingest accepts the submitted reports. Nil makes SubmitReports fail with Unimplemented.
+	Ingest *ingest.Service
+	//ClientID identifies the sender of a call, for the query budgets and the rate limits.
+	//Defaults to the IP address of the peer.
+	ClientID func(ctx context.Context) string
+	//MaxRequestBytes bounds the size of a request sent in chunks. Defaults to
+	//DefaultMaxRequestBytes.
+	MaxRequestBytes int
+}
+
+//Service implements the TCNPSI gRPC service.
+type Service struct {
+	pb.UnimplementedTCNPSIServer
+	config Config
+}
+
+//NewService returns the service described by config.
+//
+//Returns an error if a required component is missing.
+func NewService(config Config) (*Service, error) {
+	if config.Shards == nil || config.Queries == nil {
+		return nil, errors.New("shards and queries are required")
+	}
+	if config.ClientID == nil {
+		config.ClientID = PeerIP
+	}
+	if config.MaxRequestBytes <= 0 {
+		config.MaxRequestBytes = DefaultMaxRequestBytes
+	}
+	return &Service{config: config}, nil
+}
+
+//Register registers the service on grpcServer.
+func (s *Service) Register(grpcServer *grpc.Server) {
+	pb.RegisterTCNPSIServer(grpcServer, s)
+}
+
+//PeerIP returns the IP address of the peer of a call.
+func PeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+//GetManifest lists the published setup messages.
+func (s *Service) GetManifest(ctx context.Context, in *pb.GetManifestRequest) (*pb.Manifest, error) {
+	infos, err := s.config.Shards.Manifest()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	manifest := &pb.Manifest{}
+	for _, info := range infos {
+		id := info.ID
+		manifest.Shards = append(manifest.Shards, &pb.ShardInfo{
+			Id:      id[:],
+			Start:   info.Start.Unix(),
+			End:     info.End.Unix(),
+			Reports: int32(info.Reports),
+			Size:    int64(info.Size),
+		})
+	}
+	return manifest, nil
+}
+
+//GetSetup returns a published setup message, or the most recent one if no ID is given.
+func (s *Service) GetSetup(ctx context.Context, in *pb.GetSetupRequest) (*pb.SetupMessage, error) {
+	var id message.SetupID
+	switch len(in.GetId()) {
+	case 0:
+		infos, err := s.config.Shards.Manifest()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if len(infos) == 0 {
+			return nil, status.Error(codes.NotFound, "no setup message published")
+		}
+		id = infos[len(infos)-1].ID
+	case len(id):
+		copy(id[:], in.GetId())
+	default:
+		return nil, status.Error(codes.InvalidArgument, "invalid setup ID")
+	}
+	setup, err := s.config.Shards.Shard(id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	data, err := setup.MarshalBinary()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.SetupMessage{Message: data}, nil
+}
+
+//process answers an encoded request.
+func (s *Service) process(ctx context.Context, data []byte) (*pb.Response, error) {
+	request := &message.Request{}
+	if err := request.UnmarshalBinary(data); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	response, err := s.config.Queries.ProcessRequest(s.config.ClientID(ctx), request)
+	if err != nil {
+		return nil, status.Error(queryCode(err), err.Error())
+	}
+	data, err = response.MarshalBinary()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.Response{Message: data}, nil
+}
+
+//queryCode returns the status code reporting a failed query.
+func queryCode(err error) codes.Code {
+	if rejected, ok := err.(*server.QueryRejectedError); ok {
+		switch rejected.Reason {
+		case server.QueryClientBudget, server.QueryGlobalBudget, server.QuerySuspiciousClient:
+			return codes.ResourceExhausted
+		case server.QueryUnknownSetup:
+			return codes.NotFound
+		}
+		return codes.InvalidArgument
+	}
+	if err == server.ErrClosed {
+		return codes.Unavailable
+	}
+	//the remaining errors are caused by invalid requests, e.g. for another library version.
+	return codes.InvalidArgument
+}
+
+//ProcessRequest answers a PSI request.
+func (s *Service) ProcessRequest(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	return s.process(ctx, in.GetMessage())
+}
+
+//ProcessRequestStream answers a PSI request sent in chunks.
+func (s *Service) ProcessRequestStream(stream pb.TCNPSI_ProcessRequestStreamServer) error {
+	var buf bytes.Buffer
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if buf.Len()+len(chunk.GetData()) > s.config.MaxRequestBytes {
+			return status.Error(codes.ResourceExhausted, "request too large")
+		}
+		buf.Write(chunk.GetData())
+	}
+	response, err := s.process(stream.Context(), buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(response)
+}
+
+//SubmitReports ingests the submitted reports, each on its own. The call fails with
+//ResourceExhausted if the first report is rate limited, the later rate limited reports are
+//reported in their result.
+func (s *Service) SubmitReports(ctx context.Context, in *pb.SubmitReportsRequest) (*pb.SubmitReportsResponse, error) {
+	if s.config.Ingest == nil {
+		return nil, status.Error(codes.Unimplemented, "report submission disabled")
+	}
+	client := s.config.ClientID(ctx)
+	response := &pb.SubmitReportsResponse{}
+	for _, data := range in.GetReports() {
+		receipt, err := s.config.Ingest.Submit(client, data)
+		if err == ingest.ErrRateLimited && len(response.Results) == 0 {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		result := &pb.ReportResult{}
+		if err != nil {
+			if _, ok := err.(*ingest.RejectedError); !ok && err != ingest.ErrRateLimited {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			result.Error = err.Error()
+		} else {
+			id := receipt.ID
+			result.Id = id[:]
+			result.IngestedAt = receipt.IngestedAt.UnixNano()
+			result.Duplicate = receipt.Duplicate
+		}
+		response.Results = append(response.Results, result)
+	}
+	return response, nil
+}
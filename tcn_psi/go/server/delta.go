@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/tcn"
+	"sync"
+	"time"
+)
+
+//DeltaPublisher publishes incremental setup messages. Every delta covers only the reports
+//added since the previous one was published, so clients download each report once. Deltas
+//are disjoint by construction: the sum of the cardinalities over all deltas is the cardinality
+//over all reports, up to the false positives of the filters.
+//
+//A DeltaPublisher is safe for concurrent use by multiple goroutines.
+type DeltaPublisher struct {
+	server     SetupBuilder
+	fpr        float64
+	inputCount int64
+	now        func() time.Time
+
+	mu         sync.Mutex
+	pending    []*tcn.SignedReport
+	start      time.Time
+	deltas     []*message.SetupMessage
+	signingKey ed25519.PrivateKey
+}
+
+//NewDeltaPublisher returns a publisher creating its deltas with server, using fpr and
+//inputCount for every setup message. Deltas are bound to the key they were created with, and can
+//no longer be queried once that key is retired.
+func NewDeltaPublisher(server SetupBuilder, fpr float64, inputCount int64) *DeltaPublisher {
+	return &DeltaPublisher{
+		server:     server,
+		fpr:        fpr,
+		inputCount: inputCount,
+		now:        time.Now,
+	}
+}
+
+//SetSigningKey signs the deltas published from now on with the operator signing key, see
+//DayShards.SetSigningKey. A nil key stops signing them.
+//
+//Returns an error if the key is invalid.
+func (p *DeltaPublisher) SetSigningKey(key ed25519.PrivateKey) error {
+	if key != nil && len(key) != ed25519.PrivateKeySize {
+		return errors.New("invalid signing key")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.signingKey = key
+	return nil
+}
+
+//Add queues reports for the next delta.
+func (p *DeltaPublisher) Add(reports ...*tcn.SignedReport) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.start.IsZero() {
+		p.start = p.now().Truncate(time.Second)
+	}
+	p.pending = append(p.pending, reports...)
+}
+
+//Publish creates a delta setup message from the reports added since the previous delta. The
+//delta covers the time range from the end of the previous delta to now.
+//
+//Returns nil if no reports were added, or an error if the setup message cannot be created.
+func (p *DeltaPublisher) Publish() (*message.SetupMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) == 0 {
+		return nil, nil
+	}
+
+	setup, err := p.server.CreateSetupMessage(p.fpr, p.inputCount, p.pending)
+	if err != nil {
+		return nil, err
+	}
+	//the next delta starts where this one ends, so the ranges never overlap.
+	end := p.now().Truncate(time.Second).Add(time.Second)
+	if len(p.deltas) > 0 {
+		setup.Start = p.deltas[len(p.deltas)-1].End
+	} else {
+		setup.Start = p.start
+	}
+	if !end.After(setup.Start) {
+		end = setup.Start.Add(time.Second)
+	}
+	setup.End = end
+	if p.signingKey != nil {
+		if err := setup.SignAt(p.signingKey, p.now()); err != nil {
+			return nil, err
+		}
+	}
+
+	p.deltas = append(p.deltas, setup)
+	p.pending = nil
+	p.start = time.Time{}
+	return setup, nil
+}
+
+//Deltas returns the published deltas covering reports added after since, oldest first. A zero
+//since returns every delta.
+func (p *DeltaPublisher) Deltas(since time.Time) []*message.SetupMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	deltas := []*message.SetupMessage{}
+	for _, delta := range p.deltas {
+		if delta.End.After(since) {
+			deltas = append(deltas, delta)
+		}
+	}
+	return deltas
+}
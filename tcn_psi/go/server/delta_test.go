@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func TestDeltaPublisher(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	publisher := NewDeltaPublisher(server, 0.001, 1000)
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	publisher.now = func() time.Time { return now }
+
+	delta, err := publisher.Publish()
+	if err != nil || delta != nil {
+		t.Errorf("Publish without reports should not create a delta %v", err)
+	}
+
+	for day := 0; day < 3; day++ {
+		serverItems, _, err := helperGetReports(10)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		publisher.Add(serverItems...)
+		now = now.Add(24 * time.Hour)
+		if _, err := publisher.Publish(); err != nil {
+			t.Fatalf("failed to publish delta %v", err)
+		}
+	}
+
+	deltas := publisher.Deltas(time.Time{})
+	if len(deltas) != 3 {
+		t.Fatalf("invalid number of deltas %v", len(deltas))
+	}
+	for idx, delta := range deltas {
+		if !delta.End.After(delta.Start) {
+			t.Errorf("invalid delta range %v %v", delta.Start, delta.End)
+		}
+		if idx > 0 && !delta.Start.Equal(deltas[idx-1].End) {
+			t.Errorf("deltas should be contiguous %v %v", deltas[idx-1].End, delta.Start)
+		}
+		if idx > 0 && delta.ID == deltas[idx-1].ID {
+			t.Errorf("deltas should have distinct IDs")
+		}
+	}
+
+	recent := publisher.Deltas(deltas[1].End)
+	if len(recent) != 1 || recent[0].ID != deltas[2].ID {
+		t.Errorf("invalid deltas since %v: %v", deltas[1].End, len(recent))
+	}
+
+	// publishing twice within the same second still produces disjoint ranges.
+	serverItems, _, err := helperGetReports(2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	publisher.Add(serverItems...)
+	delta, err = publisher.Publish()
+	if err != nil || delta == nil {
+		t.Fatalf("failed to publish delta %v", err)
+	}
+	if !delta.Start.Equal(deltas[2].End) || !delta.End.After(delta.Start) {
+		t.Errorf("invalid delta range %v %v", delta.Start, delta.End)
+	}
+
+	if len(delta.Signature) != 0 {
+		t.Errorf("the delta should not be signed without a key")
+	}
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := publisher.SetSigningKey(private[:10]); err == nil {
+		t.Errorf("an invalid signing key should be rejected")
+	}
+	if err := publisher.SetSigningKey(private); err != nil {
+		t.Fatalf("SetSigningKey failed %v", err)
+	}
+	publisher.Add(serverItems...)
+	delta, err = publisher.Publish()
+	if err != nil || delta == nil {
+		t.Fatalf("failed to publish delta %v", err)
+	}
+	if err := delta.VerifySignature(public); err != nil || delta.Reports != len(serverItems) {
+		t.Errorf("the delta should be signed %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"github.com/openmined/tcn-psi/tcn"
+)
+
+//ReportIterator calls yield for every report of a dataset, in order, and stops at the first
+//error returned by yield, which it returns.
+type ReportIterator func(yield func(report *tcn.SignedReport) error) error
+
+//IterateReports returns an iterator over reports.
+func IterateReports(reports []*tcn.SignedReport) ReportIterator {
+	return func(yield func(report *tcn.SignedReport) error) error {
+		for _, report := range reports {
+			if err := yield(report); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
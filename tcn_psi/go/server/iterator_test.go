@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/tcn"
+	"testing"
+)
+
+func TestIterateReports(t *testing.T) {
+	reports, _, err := helperGetReports(6)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	visited := 0
+	if err := IterateReports(reports)(func(*tcn.SignedReport) error {
+		visited++
+		return nil
+	}); err != nil || visited != len(reports) {
+		t.Errorf("the iterator visited %v reports %v", visited, err)
+	}
+
+	stop := errors.New("stop")
+	visited = 0
+	err = IterateReports(reports)(func(*tcn.SignedReport) error {
+		visited++
+		return stop
+	})
+	if err != stop || visited != 1 {
+		t.Errorf("the iterator should stop at the first error, visited %v %v", visited, err)
+	}
+}
+
+func TestCreateSetupMessageFrom(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer server.Close()
+	c, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer c.Close()
+
+	serverItems, clientItems, err := helperGetReports(100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup, err := server.CreateSetupMessageFrom(0.001, 100, IterateReports(serverItems))
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	request, err := c.CreateRequest(setup, clientItems)
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	response, err := server.ProcessRequest(request)
+	if err != nil {
+		t.Fatalf("failed to process request %v", err)
+	}
+	cnt, err := c.GetIntersectionSize(setup, response)
+	if err != nil {
+		t.Fatalf("failed to compute intersection %v", err)
+	}
+	if int(cnt) < len(clientItems)/2 || float64(cnt) > float64(len(clientItems)/2)*1.1 {
+		t.Errorf("Invalid intersection %v", cnt)
+	}
+
+	failure := errors.New("storage failure")
+	if _, err := server.CreateSetupMessageFrom(0.001, 100, func(func(*tcn.SignedReport) error) error {
+		return failure
+	}); err != failure {
+		t.Errorf("CreateSetupMessageFrom should return the error of the iterator, got %v", err)
+	}
+	if _, err := server.CreateSetupMessageFrom(0.001, 100, nil); err == nil {
+		t.Errorf("CreateSetupMessageFrom without an iterator should fail")
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/tcn"
+	"sync"
+	"time"
+)
+
+//managedKey is a server context handled by a KeyManager.
+type managedKey struct {
+	server *TCNServer
+	//created is the time the key became the current key.
+	created time.Time
+	//replaced is the time the key stopped being the current key.
+	replaced time.Time
+}
+
+//KeyManager handles the rotation of the server key. It keeps the current key, used for new setup
+//messages, and the previous key during an overlap period, so that clients still holding a setup
+//message of the previous key can be answered. Requests are routed by their key ID.
+//
+//A KeyManager is safe for concurrent use by multiple goroutines.
+type KeyManager struct {
+	period  time.Duration
+	overlap time.Duration
+	now     func() time.Time
+
+	//maintainMu serialises Maintain, so that a due rotation happens once.
+	maintainMu sync.Mutex
+
+	mu       sync.RWMutex
+	current  *managedKey
+	previous *managedKey
+	observer Observer
+}
+
+//NewKeyManager returns a key manager starting with the key of server. Maintain rotates the key
+//once it has been current for period, and retires the previous key overlap after it was
+//replaced. A zero period disables automatic rotation.
+func NewKeyManager(server *TCNServer, period, overlap time.Duration) (*KeyManager, error) {
+	if server == nil || server.KeyID() == (message.KeyID{}) {
+		return nil, errors.New("invalid server")
+	}
+	manager := &KeyManager{
+		period:  period,
+		overlap: overlap,
+		now:     time.Now,
+	}
+	manager.current = &managedKey{server: server, created: manager.now()}
+	return manager, nil
+}
+
+//KeyID returns the identifier of the current key.
+func (k *KeyManager) KeyID() message.KeyID {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current.server.KeyID()
+}
+
+//KeyIDs returns the identifiers of the keys requests are accepted for, current key first.
+func (k *KeyManager) KeyIDs() []message.KeyID {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := []message.KeyID{k.current.server.KeyID()}
+	if k.previous != nil {
+		ids = append(ids, k.previous.server.KeyID())
+	}
+	return ids
+}
+
+//SetObserver registers observer on the contexts of the managed keys, including the keys rotated
+//in later.
+func (k *KeyManager) SetObserver(observer Observer) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.observer = observer
+	k.current.server.SetObserver(observer)
+	if k.previous != nil {
+		k.previous.server.SetObserver(observer)
+	}
+}
+
+//Rotate replaces the current key with a fresh one. The replaced key keeps answering requests
+//for the overlap period, and the key it replaced itself is retired immediately.
+//
+//Returns an error if the new key cannot be created.
+func (k *KeyManager) Rotate() (message.KeyID, error) {
+	server, err := CreateWithNewKey()
+	if err != nil {
+		return message.KeyID{}, err
+	}
+	return k.RotateTo(server)
+}
+
+//RotateTo replaces the current key with the key of server, e.g. when all the replicas of a
+//deployment have to switch to the same key.
+//
+//Returns an error if server is invalid or already managed.
+func (k *KeyManager) RotateTo(server *TCNServer) (message.KeyID, error) {
+	if server == nil || server.KeyID() == (message.KeyID{}) {
+		return message.KeyID{}, errors.New("invalid server")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if server.KeyID() == k.current.server.KeyID() {
+		return message.KeyID{}, errors.New("key is already current")
+	}
+
+	now := k.now()
+	if k.previous != nil && k.previous.server != server {
+		k.previous.server.Close()
+	}
+	k.previous = k.current
+	k.previous.replaced = now
+	k.current = &managedKey{server: server, created: now}
+	if k.observer != nil {
+		server.SetObserver(k.observer)
+	}
+	return server.KeyID(), nil
+}
+
+//Maintain rotates the current key if it is older than the rotation period and retires the
+//previous key once the overlap period elapsed. It is meant to be called periodically.
+//
+//Returns an error if the new key cannot be created.
+func (k *KeyManager) Maintain() error {
+	k.maintainMu.Lock()
+	defer k.maintainMu.Unlock()
+	k.retire()
+
+	k.mu.RLock()
+	due := k.period > 0 && k.now().Sub(k.current.created) >= k.period
+	k.mu.RUnlock()
+	if !due {
+		return nil
+	}
+	_, err := k.Rotate()
+	return err
+}
+
+//retire closes the previous key if its overlap period elapsed.
+func (k *KeyManager) retire() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.previous != nil && k.now().Sub(k.previous.replaced) >= k.overlap {
+		k.previous.server.Close()
+		k.previous = nil
+	}
+}
+
+//server returns the context of the key identified by id, or the current one for a zero id.
+func (k *KeyManager) server(id message.KeyID) (*TCNServer, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if id == (message.KeyID{}) || id == k.current.server.KeyID() {
+		return k.current.server, nil
+	}
+	if k.previous != nil && id == k.previous.server.KeyID() && k.now().Sub(k.previous.replaced) < k.overlap {
+		return k.previous.server, nil
+	}
+	return nil, fmt.Errorf("unknown or retired server key %v", id)
+}
+
+//CreateSetupMessage creates a setup message with the current key.
+//
+//Returns an error if the encryption fails.
+func (k *KeyManager) CreateSetupMessage(fpr float64, inputCount int64, reports []*tcn.SignedReport) (*message.SetupMessage, error) {
+	server, err := k.server(message.KeyID{})
+	if err != nil {
+		return nil, err
+	}
+	return server.CreateSetupMessage(fpr, inputCount, reports)
+}
+
+//ProcessRequest processes a client query with the key the request is tagged with.
+//
+//Returns an error if the key is unknown or retired, or if the request is invalid.
+func (k *KeyManager) ProcessRequest(request *message.Request) (*message.Response, error) {
+	if request == nil {
+		return nil, errors.New("invalid request")
+	}
+	server, err := k.server(request.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return server.ProcessRequest(request)
+}
+
+//Close releases the contexts of every managed key.
+func (k *KeyManager) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.previous != nil {
+		k.previous.server.Close()
+	}
+	return k.current.server.Close()
+}
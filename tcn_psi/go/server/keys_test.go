@@ -0,0 +1,213 @@
+package server
+
+import (
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/message"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyManager(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	manager, err := NewKeyManager(server, 30*24*time.Hour, 48*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create a key manager %v", err)
+	}
+	defer manager.Close()
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return now }
+	manager.current.created = now
+
+	serverItems, clientItems, err := helperGetReports(50)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	query := func(setup *message.SetupMessage) (int64, error) {
+		request, err := c.CreateRequest(setup, clientItems)
+		if err != nil {
+			return 0, err
+		}
+		response, err := manager.ProcessRequest(request)
+		if err != nil {
+			return 0, err
+		}
+		return c.GetIntersectionSize(setup, response)
+	}
+
+	oldSetup, err := manager.CreateSetupMessage(0.001, int64(len(clientItems)), serverItems)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	if oldSetup.KeyID != server.KeyID() || oldSetup.KeyID != manager.KeyID() {
+		t.Errorf("setup should be tagged with the current key")
+	}
+
+	// not due yet.
+	if err := manager.Maintain(); err != nil || manager.KeyID() != server.KeyID() {
+		t.Fatalf("key should not be rotated yet %v", err)
+	}
+	now = now.Add(31 * 24 * time.Hour)
+	if err := manager.Maintain(); err != nil {
+		t.Fatalf("failed to rotate key %v", err)
+	}
+	if manager.KeyID() == server.KeyID() || len(manager.KeyIDs()) != 2 {
+		t.Fatalf("key should be rotated")
+	}
+
+	newSetup, err := manager.CreateSetupMessage(0.001, int64(len(clientItems)), serverItems)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	if newSetup.KeyID != manager.KeyID() || newSetup.ID == oldSetup.ID {
+		t.Errorf("setup should be tagged with the new key")
+	}
+
+	// both setups are answered during the overlap period.
+	for _, setup := range []*message.SetupMessage{oldSetup, newSetup} {
+		size, err := query(setup)
+		if err != nil {
+			t.Fatalf("failed to query setup %v", err)
+		}
+		if int(size) < len(clientItems)/2 || float64(size) > float64(len(clientItems)/2)*1.1 {
+			t.Errorf("Invalid intersection. expected about %v. got %v", len(clientItems)/2, size)
+		}
+	}
+
+	// the previous key is retired after the overlap.
+	now = now.Add(49 * time.Hour)
+	if err := manager.Maintain(); err != nil {
+		t.Fatalf("failed to maintain keys %v", err)
+	}
+	if len(manager.KeyIDs()) != 1 {
+		t.Errorf("previous key should be retired")
+	}
+	if _, err := query(oldSetup); err == nil {
+		t.Errorf("requests for a retired key should fail")
+	}
+	if _, err := server.GetPrivateKeyBytes(); err != ErrClosed {
+		t.Errorf("retired key should be closed, got %v", err)
+	}
+	if _, err := query(newSetup); err != nil {
+		t.Errorf("failed to query current setup %v", err)
+	}
+
+	// day shards are rebuilt with the new key.
+	shards := NewDayShards(manager, 0.001, 100, 7)
+	if err := shards.Add(serverItems...); err != nil {
+		t.Fatalf("failed to add reports %v", err)
+	}
+	manifest, err := shards.Manifest()
+	if err != nil {
+		t.Fatalf("failed to create manifest %v", err)
+	}
+	if _, err := manager.Rotate(); err != nil {
+		t.Fatalf("failed to rotate key %v", err)
+	}
+	rotated, err := shards.Manifest()
+	if err != nil {
+		t.Fatalf("failed to create manifest %v", err)
+	}
+	shard, err := shards.Shard(rotated[0].ID)
+	if err != nil || rotated[0].ID == manifest[0].ID || shard.KeyID != manager.KeyID() {
+		t.Errorf("shards should be rebuilt with the new key %v", err)
+	}
+
+	if _, err := manager.RotateTo(nil); err == nil {
+		t.Errorf("RotateTo should fail with an invalid server")
+	}
+	if _, err := NewKeyManager(&TCNServer{}, 0, 0); err == nil {
+		t.Errorf("NewKeyManager should fail with an invalid server")
+	}
+}
+
+func TestConcurrentMaintain(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	manager, err := NewKeyManager(server, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create a key manager %v", err)
+	}
+	defer manager.Close()
+	now := manager.current.created.Add(2 * time.Hour)
+	manager.now = func() time.Time { return now }
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := manager.Maintain(); err != nil {
+				t.Errorf("Maintain failed %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// a single rotation happened, and the replaced key is still in its overlap period.
+	ids := manager.KeyIDs()
+	if len(ids) != 2 || ids[1] != server.KeyID() {
+		t.Fatalf("the key should be rotated once %v", ids)
+	}
+	if _, err := server.GetPrivateKeyBytes(); err != nil {
+		t.Errorf("the replaced key should not be closed during the overlap %v", err)
+	}
+}
+
+func TestServerKeyID(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	key, err := server.GetPrivateKeyBytes()
+	if err != nil {
+		t.Fatalf("Failed to get the PSI server key %v", err)
+	}
+	same, err := CreateFromKey(key)
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server from key %v", err)
+	}
+	other, err := CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	if server.KeyID() != same.KeyID() || server.KeyID() == other.KeyID() || server.KeyID() == (message.KeyID{}) {
+		t.Errorf("invalid key IDs %v %v %v", server.KeyID(), same.KeyID(), other.KeyID())
+	}
+
+	serverItems, clientItems, err := helperGetReports(10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup, err := server.CreateSetupMessage(0.001, int64(len(clientItems)), serverItems)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	c, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	request, err := c.CreateRequest(setup, clientItems)
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	if request.KeyID != server.KeyID() {
+		t.Errorf("request should be tagged with the key of the setup")
+	}
+	if _, err := other.ProcessRequest(request); err == nil {
+		t.Errorf("ProcessRequest should fail for a request tagged with another key")
+	}
+	response, err := same.ProcessRequest(request)
+	if err != nil || response.KeyID != server.KeyID() {
+		t.Errorf("failed to process request with the same key %v", err)
+	}
+}
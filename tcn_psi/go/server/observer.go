@@ -0,0 +1,18 @@
+package server
+
+import (
+	"github.com/openmined/tcn-psi/message"
+	"time"
+)
+
+//Observer is notified of the work done by a server, for example to export metrics. Its methods
+//are called synchronously and must be safe for concurrent use.
+type Observer interface {
+	//SetupBuilt is called once a setup message was built from plan, or failed to be built.
+	//duration covers the planning and the encryption of the setup message.
+	SetupBuilt(plan *SetupPlan, setup *message.SetupMessage, duration time.Duration, err error)
+	//RequestProcessed is called once a request was processed, or failed to be processed.
+	RequestProcessed(request *message.Request, response *message.Response, duration time.Duration, err error)
+	//RequestRejected is called when a QueryGuard rejects a request.
+	RequestRejected(client string, reason QueryRejection)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"github.com/openmined/tcn-psi/message"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	mu       sync.Mutex
+	setups   int
+	requests int
+	rejected []QueryRejection
+}
+
+func (o *recordingObserver) SetupBuilt(plan *SetupPlan, setup *message.SetupMessage, duration time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.setups++
+}
+
+func (o *recordingObserver) RequestProcessed(request *message.Request, response *message.Response, duration time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests++
+}
+
+func (o *recordingObserver) RequestRejected(client string, reason QueryRejection) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.rejected = append(o.rejected, reason)
+}
+
+func TestObserverKeyManager(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	manager, err := NewKeyManager(server, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyManager failed %v", err)
+	}
+	defer manager.Close()
+	observer := &recordingObserver{}
+	manager.SetObserver(observer)
+
+	reports, _, err := helperGetReports(2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := manager.CreateSetupMessage(0.001, 100, reports); err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	if _, err := manager.Rotate(); err != nil {
+		t.Fatalf("Rotate failed %v", err)
+	}
+	if _, err := manager.CreateSetupMessage(0.001, 100, reports); err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	manager.ProcessRequest(&message.Request{Payload: "dummy"})
+	if observer.setups != 2 || observer.requests != 1 {
+		t.Errorf("the observer should follow the rotated key, got %v setups and %v requests", observer.setups, observer.requests)
+	}
+
+	guard := NewQueryGuard(manager, publishedSetups{}, QueryPolicy{})
+	guard.SetObserver(observer)
+	guard.ProcessRequest("a", &message.Request{Payload: "dummy"})
+	if len(observer.rejected) != 1 || observer.rejected[0] != QueryMalformed {
+		t.Errorf("the rejection was not observed %v", observer.rejected)
+	}
+
+	server.SetObserver(nil)
+	if _, err := server.CreateSetupMessage(0.001, 100, reports); err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	if observer.setups != 2 {
+		t.Errorf("a removed observer should not be notified")
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"github.com/openmined/tcn-psi/tcn"
+)
+
+//RejectionReason explains why a report was not included in a setup message.
+type RejectionReason string
+
+//Rejection reasons.
+const (
+	RejectedMalformed        RejectionReason = "malformed report"
+	RejectedInvalidSignature RejectionReason = "invalid signature"
+	RejectedInvalidRange     RejectionReason = "invalid index range"
+	RejectedSpanTooLarge     RejectionReason = "index span too large"
+	RejectedMemoType         RejectionReason = "memo type not allowed"
+	RejectedDuplicateRVK     RejectionReason = "duplicate report verification key"
+)
+
+//DuplicateRVKPolicy decides how several reports signed with the same report verification key
+//are handled.
+type DuplicateRVKPolicy int
+
+const (
+	//AcceptDuplicateRVKs keeps every report, e.g. the successive reports of a user covering
+	//later index ranges. The TCNs revealed by several reports are only included once in the
+	//setup message.
+	AcceptDuplicateRVKs DuplicateRVKPolicy = iota
+	//RejectDuplicateRVKs keeps the first report of every RVK and rejects the others.
+	RejectDuplicateRVKs
+)
+
+//IngestionPolicy decides which reports are included in a setup message. The zero value only
+//checks the signature and the index range of the reports, and accepts duplicate RVKs.
+type IngestionPolicy struct {
+	//MaxSpan is the maximum number of TCNs a report may reveal, J2-J1+1. Zero disables the
+	//check.
+	MaxSpan int
+	//AllowedMemoTypes lists the accepted memo types. An empty list accepts every type.
+	AllowedMemoTypes []uint8
+	//DuplicateRVKs decides how reports sharing the same RVK are handled.
+	DuplicateRVKs DuplicateRVKPolicy
+}
+
+//RejectedReport describes a report which was not included in a setup message.
+type RejectedReport struct {
+	//Index of the report in the input.
+	Index  int
+	Reason RejectionReason
+}
+
+//IngestSummary describes the outcome of applying an IngestionPolicy to a set of reports.
+type IngestSummary struct {
+	//Accepted is the number of reports included in the setup message.
+	Accepted int
+	//TCNs is the number of distinct TCNs revealed by the accepted reports.
+	TCNs int
+	//Rejected lists the reports which were not included, in input order.
+	Rejected []RejectedReport
+}
+
+//check returns the reason why report breaks the policy, or an empty reason if it is accepted.
+func (p *IngestionPolicy) check(report *tcn.SignedReport) RejectionReason {
+	if report == nil || report.Report == nil || len(report.RVK) != ed25519.PublicKeySize || len(report.MemoData) > 255 {
+		return RejectedMalformed
+	}
+	if report.J1 == 0 || report.J2 < report.J1 {
+		return RejectedInvalidRange
+	}
+	if p.MaxSpan > 0 && int(report.J2-report.J1)+1 > p.MaxSpan {
+		return RejectedSpanTooLarge
+	}
+	if len(p.AllowedMemoTypes) > 0 {
+		allowed := false
+		for _, memoType := range p.AllowedMemoTypes {
+			if memoType == report.MemoType {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return RejectedMemoType
+		}
+	}
+	if signed, err := report.Verify(); err != nil || !signed {
+		return RejectedInvalidSignature
+	}
+	return ""
+}
+
+//policyState applies a policy to a stream of reports.
+type policyState struct {
+	policy  *IngestionPolicy
+	summary *IngestSummary
+	seen    map[string]bool
+	index   int
+}
+
+func newPolicyState(policy *IngestionPolicy) *policyState {
+	return &policyState{
+		policy:  policy,
+		summary: &IngestSummary{},
+		seen:    map[string]bool{},
+	}
+}
+
+//add checks the next report of the stream and reports whether it is accepted.
+func (st *policyState) add(report *tcn.SignedReport) bool {
+	idx := st.index
+	st.index++
+	reason := st.policy.check(report)
+	if reason == "" && st.policy.DuplicateRVKs == RejectDuplicateRVKs {
+		if st.seen[string(report.RVK)] {
+			reason = RejectedDuplicateRVK
+		}
+		st.seen[string(report.RVK)] = true
+	}
+	if reason != "" {
+		st.summary.Rejected = append(st.summary.Rejected, RejectedReport{Index: idx, Reason: reason})
+		return false
+	}
+	st.summary.Accepted++
+	return true
+}
+
+//Apply checks every report against the policy and returns the accepted ones, along with a
+//summary of the rejected ones. Duplicate RVKs are only detected within reports.
+func (p *IngestionPolicy) Apply(reports []*tcn.SignedReport) ([]*tcn.SignedReport, *IngestSummary) {
+	accepted := []*tcn.SignedReport{}
+	state := newPolicyState(p)
+	for _, report := range reports {
+		if state.add(report) {
+			accepted = append(accepted, report)
+		}
+	}
+	return accepted, state.summary
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/tcn"
+	"testing"
+)
+
+func TestIngestionPolicy(t *testing.T) {
+	rak, err := tcn.NewReportAuthorizationKey()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	valid, err := rak.CreateSignedReport(tcn.CoEpiV1Code, []byte{}, 1, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	duplicate, err := rak.CreateSignedReport(tcn.CoEpiV1Code, []byte{}, 5, 12)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	wide, err := rak.CreateSignedReport(tcn.CoEpiV1Code, []byte{}, 1, 500)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	memo, err := rak.CreateSignedReport(tcn.ITOMemoCode, []byte("ito"), 1, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	inverted, err := rak.CreateSignedReport(tcn.CoEpiV1Code, []byte{}, 10, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	forged, err := rak.CreateSignedReport(tcn.CoEpiV1Code, []byte{}, 1, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	forged.J2 = 20
+
+	policy := &IngestionPolicy{
+		MaxSpan:          100,
+		AllowedMemoTypes: []uint8{tcn.CoEpiV1Code, tcn.CovidWatchV1Code},
+		DuplicateRVKs:    RejectDuplicateRVKs,
+	}
+	reports := []*tcn.SignedReport{valid, duplicate, wide, memo, inverted, forged, nil}
+	accepted, summary := policy.Apply(reports)
+	if len(accepted) != 1 || accepted[0] != valid || summary.Accepted != 1 {
+		t.Fatalf("only the valid report should be accepted, got %v", len(accepted))
+	}
+	expected := []RejectionReason{
+		RejectedDuplicateRVK,
+		RejectedSpanTooLarge,
+		RejectedMemoType,
+		RejectedInvalidRange,
+		RejectedInvalidSignature,
+		RejectedMalformed,
+	}
+	if len(summary.Rejected) != len(expected) {
+		t.Fatalf("invalid rejections %+v", summary.Rejected)
+	}
+	for idx, rejected := range summary.Rejected {
+		if rejected.Index != idx+1 || rejected.Reason != expected[idx] {
+			t.Errorf("invalid rejection %+v, expected %v", rejected, expected[idx])
+		}
+	}
+
+	policy.DuplicateRVKs = AcceptDuplicateRVKs
+	accepted, _ = policy.Apply(reports)
+	if len(accepted) != 2 {
+		t.Errorf("duplicate RVKs should be accepted, got %v", len(accepted))
+	}
+
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	setup, summary, err := server.CreateSetupMessageWithPolicy(0.001, 100, reports, policy)
+	if err != nil || setup == nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	// the overlapping TCNs of the two reports are only included once.
+	if summary.Accepted != 2 || summary.TCNs != 12 {
+		t.Errorf("invalid summary %+v", summary)
+	}
+
+	// the default policy verifies the signatures.
+	_, summary, err = server.CreateSetupMessageWithPolicy(0.001, 100, []*tcn.SignedReport{valid, forged}, nil)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	if summary.Accepted != 1 || summary.TCNs != 10 || len(summary.Rejected) != 1 {
+		t.Errorf("forged reports should be left out of the setup message %+v", summary)
+	}
+}
+
+func TestSuccessiveReports(t *testing.T) {
+	rak, err := tcn.NewReportAuthorizationKey()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tck, err := rak.InitialTCK()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	//the TCNs of indices 1 to 20.
+	tcns := []tcn.TemporaryContactNumber{}
+	for idx := 0; idx < 20; idx++ {
+		val, err := tck.TemporaryContactNumber()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		tcns = append(tcns, *val)
+		if tck, err = tck.Ratchet(); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	//a user reports again later, with the same key and a later index range.
+	first, err := rak.CreateSignedReport(tcn.CoEpiV1Code, []byte{}, 1, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	second, err := rak.CreateSignedReport(tcn.CoEpiV1Code, []byte{}, 11, 20)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	server, err := CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer server.Close()
+	setup, err := server.CreateSetupMessage(0.001, 100, []*tcn.SignedReport{first, second})
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	if setup.Reports != 2 {
+		t.Errorf("both reports should be included, got %v", setup.Reports)
+	}
+	c, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer c.Close()
+	request, err := c.CreateRequest(setup, tcns[10:])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	response, err := server.ProcessRequest(request)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if size, err := c.GetIntersectionSize(setup, response); err != nil || size != 10 {
+		t.Errorf("the TCNs of the second report should be in the setup message %v %v", size, err)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/tcn"
+	"runtime"
+	"sync"
+)
+
+//Pool dispatches work across several server contexts created from the same private key, so
+//that requests are processed in parallel. Any context of the pool can answer a request for a
+//setup message created by any other.
+//
+//A Pool is safe for concurrent use by multiple goroutines.
+type Pool struct {
+	keyID   message.KeyID
+	servers []*TCNServer
+	free    chan *TCNServer
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+//NewPool creates a pool of size server contexts using the provided private key. If size is
+//not positive, one context per CPU is created.
+//
+//Returns an error if any crypto operations fail.
+func NewPool(key []byte, size int) (*Pool, error) {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	pool := &Pool{
+		free: make(chan *TCNServer, size),
+	}
+	for idx := 0; idx < size; idx++ {
+		server, err := CreateFromKey(key)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.servers = append(pool.servers, server)
+		pool.free <- server
+	}
+	pool.keyID = pool.servers[0].KeyID()
+	return pool, nil
+}
+
+//KeyID returns the public identifier of the private key shared by the contexts of the pool.
+func (p *Pool) KeyID() message.KeyID {
+	return p.keyID
+}
+
+//Size returns the number of server contexts in the pool.
+func (p *Pool) Size() int {
+	return len(p.servers)
+}
+
+//SetObserver registers observer on every context of the pool.
+func (p *Pool) SetObserver(observer Observer) {
+	for _, server := range p.servers {
+		server.SetObserver(observer)
+	}
+}
+
+//do runs fn on the first available server context.
+func (p *Pool) do(fn func(*TCNServer) error) error {
+	p.mu.RLock()
+	closed := p.closed
+	p.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	server := <-p.free
+	defer func() { p.free <- server }()
+	return fn(server)
+}
+
+//CreateSetupMessage creates a setup message from the server's dataset using one of the
+//contexts of the pool.
+//
+//Returns an error if the pool is closed or if the encryption fails.
+func (p *Pool) CreateSetupMessage(fpr float64, inputCount int64, reports []*tcn.SignedReport) (*message.SetupMessage, error) {
+	var setup *message.SetupMessage
+	err := p.do(func(server *TCNServer) error {
+		var err error
+		setup, err = server.CreateSetupMessage(fpr, inputCount, reports)
+		return err
+	})
+	return setup, err
+}
+
+//ProcessRequest processes a client query on the first available context of the pool.
+//
+//Returns an error if the pool is closed or if the request is invalid.
+func (p *Pool) ProcessRequest(request *message.Request) (*message.Response, error) {
+	var response *message.Response
+	err := p.do(func(server *TCNServer) error {
+		var err error
+		response, err = server.ProcessRequest(request)
+		return err
+	})
+	return response, err
+}
+
+//Close releases every context of the pool. Requests in flight complete, later ones fail with
+//ErrClosed.
+//
+//Close is safe to call more than once.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	var result error
+	for _, server := range p.servers {
+		if err := server.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/message"
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	key, err := server.GetPrivateKeyBytes()
+	if err != nil {
+		t.Fatalf("Failed to get the PSI server key %v", err)
+	}
+	pool, err := NewPool(key, 4)
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server pool %v", err)
+	}
+	if pool.Size() != 4 {
+		t.Errorf("invalid pool size %v", pool.Size())
+	}
+
+	serverItems, clientItems, err := helperGetReports(100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// the setup message of a single context is valid for the whole pool.
+	setup, err := server.CreateSetupMessage(0.001, int64(len(clientItems)), serverItems)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 16; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := client.Create()
+			if err != nil {
+				t.Errorf("Failed to create a PSI client %v", err)
+				return
+			}
+			defer c.Close()
+			request, err := c.CreateRequest(setup, clientItems)
+			if err != nil {
+				t.Errorf("failed to create request %v", err)
+				return
+			}
+			serverResp, err := pool.ProcessRequest(request)
+			if err != nil {
+				t.Errorf("failed to process request %v", err)
+				return
+			}
+			intersectionCnt, err := c.GetIntersectionSize(setup, serverResp)
+			if err != nil {
+				t.Errorf("failed to compute intersection %v", err)
+				return
+			}
+			if int(intersectionCnt) < len(clientItems)/2 || float64(intersectionCnt) > float64(len(clientItems)/2)*1.1 {
+				t.Errorf("Invalid intersection. expected about %v. got %v", len(clientItems)/2, intersectionCnt)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := pool.Close(); err != nil {
+		t.Errorf("Close failed %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Errorf("second Close failed %v", err)
+	}
+	if _, err := pool.ProcessRequest(&message.Request{Payload: "dummy"}); err != ErrClosed {
+		t.Errorf("ProcessRequest on a closed pool should fail with ErrClosed, got %v", err)
+	}
+	if _, err := pool.CreateSetupMessage(0.001, 100, serverItems); err != ErrClosed {
+		t.Errorf("CreateSetupMessage on a closed pool should fail with ErrClosed, got %v", err)
+	}
+
+	if _, err := NewPool([]byte("invalid key"), 2); err == nil {
+		t.Errorf("NewPool should fail with an invalid key")
+	}
+}
+
+func benchmarkPoolProcessRequest(size int, b *testing.B) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		b.Fatalf("failed to get server")
+	}
+	key, err := server.GetPrivateKeyBytes()
+	if err != nil {
+		b.Fatalf("failed to get server key")
+	}
+	pool, err := NewPool(key, size)
+	if err != nil {
+		b.Fatalf("failed to get server pool")
+	}
+	defer pool.Close()
+
+	c, err := client.Create()
+	if err != nil || c == nil {
+		b.Fatalf("failed to get client")
+	}
+	serverItems, clientItems, err := helperGetReports(100)
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+	setup, err := server.CreateSetupMessage(0.001, int64(len(clientItems)), serverItems)
+	if err != nil {
+		b.Fatalf("failed to create setup msg %v", err)
+	}
+	request, err := c.CreateRequest(setup, clientItems)
+	if err != nil {
+		b.Fatalf("failed to create request %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := pool.ProcessRequest(request); err != nil {
+				b.Errorf("failed to process request %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkPoolProcessRequest1(b *testing.B)      { benchmarkPoolProcessRequest(1, b) }
+func BenchmarkPoolProcessRequest4(b *testing.B)      { benchmarkPoolProcessRequest(4, b) }
+func BenchmarkPoolProcessRequestNumCPU(b *testing.B) { benchmarkPoolProcessRequest(0, b) }
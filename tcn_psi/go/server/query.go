@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/message"
+	"sync"
+)
+
+//QueryRejection explains why a request was not processed.
+type QueryRejection string
+
+//Query rejections.
+const (
+	QueryMalformed        QueryRejection = "malformed request"
+	QueryUnknownSetup     QueryRejection = "unknown setup message"
+	QueryTooFewElements   QueryRejection = "too few elements"
+	QueryTooManyElements  QueryRejection = "too many elements"
+	QueryClientBudget     QueryRejection = "client query budget exhausted"
+	QueryGlobalBudget     QueryRejection = "global query budget exhausted"
+	QuerySuspiciousClient QueryRejection = "suspicious query pattern"
+)
+
+//QueryRejectedError is returned by QueryGuard.ProcessRequest when a request breaks the
+//QueryPolicy.
+type QueryRejectedError struct {
+	Reason QueryRejection
+}
+
+func (e *QueryRejectedError) Error() string {
+	return fmt.Sprintf("request rejected: %v", e.Reason)
+}
+
+//QueryPolicy limits the requests answered by a server. A request for a single TCN tells its
+//sender whether that TCN was reported, so the policy bounds the size of the requests and the
+//number of requests a client may send against the same setup message. The zero value accepts
+//every request.
+type QueryPolicy struct {
+	//MinElements and MaxElements bound the number of elements of a request. Zero disables the
+	//bound. Clients reach MinElements with TCNClient.CreatePaddedRequest.
+	MinElements int
+	MaxElements int
+	//ClientBudget is the number of requests a client may send for a setup message. Zero
+	//disables the budget.
+	ClientBudget int
+	//GlobalBudget is the number of requests answered for a setup message, across all clients.
+	//Zero disables the budget.
+	GlobalBudget int
+	//Check, if set, is called for every request within the bounds and budgets, with the number
+	//of requests the client already sent for the setup message. Returning false rejects the
+	//request as suspicious, for example to throttle clients sending many small requests.
+	Check func(client string, setup message.SetupID, elements int, sent int) bool
+}
+
+//RequestProcessor answers the requests of clients. It is implemented by TCNServer, Pool and
+//KeyManager.
+type RequestProcessor interface {
+	ProcessRequest(request *message.Request) (*message.Response, error)
+}
+
+//PublishedSetups tells which setup messages clients may query. It is implemented by DayShards.
+type PublishedSetups interface {
+	Published(id message.SetupID) bool
+}
+
+//QueryGuard enforces a QueryPolicy in front of a RequestProcessor. The policy is checked before
+//any crypto work is done, the requests for setup messages which are not published are rejected,
+//and every admitted request counts against the budgets.
+//
+//A QueryGuard is safe for concurrent use by multiple goroutines.
+type QueryGuard struct {
+	processor RequestProcessor
+	setups    PublishedSetups
+	policy    QueryPolicy
+	observer  Observer
+
+	mu      sync.Mutex
+	clients map[message.SetupID]map[string]int
+	global  map[message.SetupID]int
+}
+
+//NewQueryGuard returns a guard answering with processor the requests for the setup messages
+//published by setups which policy admits.
+func NewQueryGuard(processor RequestProcessor, setups PublishedSetups, policy QueryPolicy) *QueryGuard {
+	return &QueryGuard{
+		processor: processor,
+		setups:    setups,
+		policy:    policy,
+		clients:   map[message.SetupID]map[string]int{},
+		global:    map[message.SetupID]int{},
+	}
+}
+
+//SetObserver registers observer to be notified of the rejected requests. It is not safe to call
+//concurrently with ProcessRequest.
+func (g *QueryGuard) SetObserver(observer Observer) {
+	g.observer = observer
+}
+
+//RequestElements returns the number of elements of request, read from the encoded PSI payload
+//without decrypting it.
+//
+//Returns an error if the payload is malformed.
+func RequestElements(request *message.Request) (int, error) {
+	if request == nil {
+		return 0, errors.New("invalid request")
+	}
+	var payload struct {
+		EncryptedElements []json.RawMessage `json:"encrypted_elements"`
+	}
+	if err := json.Unmarshal([]byte(request.Payload), &payload); err != nil {
+		return 0, err
+	}
+	return len(payload.EncryptedElements), nil
+}
+
+//admit checks request against the policy and counts it against the budgets.
+func (g *QueryGuard) admit(client string, request *message.Request) error {
+	elements, err := RequestElements(request)
+	if err != nil {
+		return &QueryRejectedError{Reason: QueryMalformed}
+	}
+	if g.policy.MinElements > 0 && elements < g.policy.MinElements {
+		return &QueryRejectedError{Reason: QueryTooFewElements}
+	}
+	if g.policy.MaxElements > 0 && elements > g.policy.MaxElements {
+		return &QueryRejectedError{Reason: QueryTooManyElements}
+	}
+	//the budgets are kept per setup message, unknown ones would be unlimited.
+	if !g.setups.Published(request.SetupID) {
+		return &QueryRejectedError{Reason: QueryUnknownSetup}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	clients, ok := g.clients[request.SetupID]
+	if !ok {
+		//a new setup message is published, drop the budgets of the withdrawn ones.
+		g.prune()
+		clients = map[string]int{}
+		g.clients[request.SetupID] = clients
+	}
+	sent := clients[client]
+	if g.policy.ClientBudget > 0 && sent >= g.policy.ClientBudget {
+		return &QueryRejectedError{Reason: QueryClientBudget}
+	}
+	if g.policy.GlobalBudget > 0 && g.global[request.SetupID] >= g.policy.GlobalBudget {
+		return &QueryRejectedError{Reason: QueryGlobalBudget}
+	}
+	if g.policy.Check != nil && !g.policy.Check(client, request.SetupID, elements, sent) {
+		return &QueryRejectedError{Reason: QuerySuspiciousClient}
+	}
+	clients[client] = sent + 1
+	g.global[request.SetupID]++
+	return nil
+}
+
+//ProcessRequest answers the request sent by client if the policy admits it. client identifies
+//the sender for the budgets, for example an authenticated user or an IP address.
+//
+//Returns a *QueryRejectedError if the policy rejects the request, or the error of the
+//processor.
+func (g *QueryGuard) ProcessRequest(client string, request *message.Request) (*message.Response, error) {
+	if err := g.admit(client, request); err != nil {
+		if g.observer != nil {
+			g.observer.RequestRejected(client, err.(*QueryRejectedError).Reason)
+		}
+		return nil, err
+	}
+	return g.processor.ProcessRequest(request)
+}
+
+//Sent returns the number of requests admitted for setup, from client and in total.
+func (g *QueryGuard) Sent(client string, setup message.SetupID) (int, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.clients[setup][client], g.global[setup]
+}
+
+//Prune drops the budgets of the setup messages which are no longer published. The budgets are
+//pruned whenever a request for a new setup message is admitted, call Prune to release them
+//sooner.
+func (g *QueryGuard) Prune() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.prune()
+}
+
+func (g *QueryGuard) prune() {
+	for setup := range g.clients {
+		if !g.setups.Published(setup) {
+			delete(g.clients, setup)
+			delete(g.global, setup)
+		}
+	}
+}
+
+//Forget drops the budgets of setup. Call it when the setup message is no longer published.
+func (g *QueryGuard) Forget(setup message.SetupID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.clients, setup)
+	delete(g.global, setup)
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"crypto/rand"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/message"
+	"sync"
+	"testing"
+)
+
+type countingProcessor struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *countingProcessor) ProcessRequest(request *message.Request) (*message.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return &message.Response{SetupID: request.SetupID}, nil
+}
+
+//publishedSetups publishes the setup messages set to true.
+type publishedSetups map[message.SetupID]bool
+
+func (p publishedSetups) Published(id message.SetupID) bool {
+	return p[id]
+}
+
+func helperGetRequest(t *testing.T, setup message.SetupID, elements int) *message.Request {
+	payload := `{"reveal_intersection":false,"encrypted_elements":[`
+	for idx := 0; idx < elements; idx++ {
+		if idx > 0 {
+			payload += ","
+		}
+		payload += `"AAAA"`
+	}
+	payload += "]}"
+	return &message.Request{SetupID: setup, Payload: payload}
+}
+
+func helperCheckRejection(t *testing.T, err error, reason QueryRejection) {
+	rejected, ok := err.(*QueryRejectedError)
+	if !ok || rejected.Reason != reason {
+		t.Errorf("expected rejection %v, got %v", reason, err)
+	}
+}
+
+func TestRequestElements(t *testing.T) {
+	for _, cnt := range []int{0, 1, 10} {
+		elements, err := RequestElements(helperGetRequest(t, message.SetupID{}, cnt))
+		if err != nil || elements != cnt {
+			t.Errorf("expected %v elements, got %v %v", cnt, elements, err)
+		}
+	}
+	if _, err := RequestElements(&message.Request{Payload: "dummy"}); err == nil {
+		t.Errorf("RequestElements with a malformed payload should fail")
+	}
+	if _, err := RequestElements(nil); err == nil {
+		t.Errorf("RequestElements without a request should fail")
+	}
+
+	c, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer c.Close()
+	server, err := CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer server.Close()
+	serverItems, clientItems, err := helperGetReports(3)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup, err := server.CreateSetupMessage(0.001, 100, serverItems)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	request, err := c.CreatePaddedRequest(setup, clientItems, client.PadToFixed(64))
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	if elements, err := RequestElements(request); err != nil || elements != 64 {
+		t.Errorf("expected 64 elements in a real request, got %v %v", elements, err)
+	}
+}
+
+func TestQueryGuardElements(t *testing.T) {
+	processor := &countingProcessor{}
+	guard := NewQueryGuard(processor, publishedSetups{{1}: true}, QueryPolicy{MinElements: 8, MaxElements: 16})
+	setup := message.SetupID{1}
+
+	_, err := guard.ProcessRequest("a", helperGetRequest(t, setup, 1))
+	helperCheckRejection(t, err, QueryTooFewElements)
+	_, err = guard.ProcessRequest("a", helperGetRequest(t, setup, 17))
+	helperCheckRejection(t, err, QueryTooManyElements)
+	_, err = guard.ProcessRequest("a", &message.Request{SetupID: setup, Payload: "dummy"})
+	helperCheckRejection(t, err, QueryMalformed)
+	if processor.calls != 0 {
+		t.Errorf("rejected requests should not reach the processor")
+	}
+	if _, err := guard.ProcessRequest("a", helperGetRequest(t, setup, 8)); err != nil {
+		t.Errorf("ProcessRequest failed %v", err)
+	}
+	if processor.calls != 1 {
+		t.Errorf("the admitted request should reach the processor")
+	}
+}
+
+func TestQueryGuardBudgets(t *testing.T) {
+	processor := &countingProcessor{}
+	guard := NewQueryGuard(processor, publishedSetups{{1}: true, {2}: true}, QueryPolicy{ClientBudget: 2, GlobalBudget: 3})
+	setup := message.SetupID{1}
+	other := message.SetupID{2}
+
+	for idx := 0; idx < 2; idx++ {
+		if _, err := guard.ProcessRequest("a", helperGetRequest(t, setup, 1)); err != nil {
+			t.Fatalf("ProcessRequest failed %v", err)
+		}
+	}
+	_, err := guard.ProcessRequest("a", helperGetRequest(t, setup, 1))
+	helperCheckRejection(t, err, QueryClientBudget)
+	if _, err := guard.ProcessRequest("a", helperGetRequest(t, other, 1)); err != nil {
+		t.Errorf("the budget of another setup message should be independent %v", err)
+	}
+	if _, err := guard.ProcessRequest("b", helperGetRequest(t, setup, 1)); err != nil {
+		t.Errorf("the budget of another client should be independent %v", err)
+	}
+	_, err = guard.ProcessRequest("c", helperGetRequest(t, setup, 1))
+	helperCheckRejection(t, err, QueryGlobalBudget)
+
+	if client, total := guard.Sent("a", setup); client != 2 || total != 3 {
+		t.Errorf("invalid counters %v %v", client, total)
+	}
+	guard.Forget(setup)
+	if client, total := guard.Sent("a", setup); client != 0 || total != 0 {
+		t.Errorf("Forget should reset the counters, got %v %v", client, total)
+	}
+	if _, err := guard.ProcessRequest("c", helperGetRequest(t, setup, 1)); err != nil {
+		t.Errorf("ProcessRequest after Forget failed %v", err)
+	}
+}
+
+func TestQueryGuardUnknownSetup(t *testing.T) {
+	processor := &countingProcessor{}
+	setups := publishedSetups{{1}: true}
+	guard := NewQueryGuard(processor, setups, QueryPolicy{ClientBudget: 1})
+
+	for idx := 0; idx < 10; idx++ {
+		var setup message.SetupID
+		if _, err := rand.Read(setup[:]); err != nil {
+			t.Fatal(err.Error())
+		}
+		_, err := guard.ProcessRequest("a", helperGetRequest(t, setup, 1))
+		helperCheckRejection(t, err, QueryUnknownSetup)
+		if _, total := guard.Sent("a", setup); total != 0 {
+			t.Errorf("a request for an unknown setup message should not be counted")
+		}
+	}
+	if processor.calls != 0 {
+		t.Errorf("rejected requests should not reach the processor")
+	}
+	if len(guard.clients) != 0 || len(guard.global) != 0 {
+		t.Errorf("unknown setup messages should not be tracked")
+	}
+
+	//the budgets of a withdrawn setup message are dropped.
+	if _, err := guard.ProcessRequest("a", helperGetRequest(t, message.SetupID{1}, 1)); err != nil {
+		t.Fatalf("ProcessRequest failed %v", err)
+	}
+	delete(setups, message.SetupID{1})
+	setups[message.SetupID{2}] = true
+	_, err := guard.ProcessRequest("a", helperGetRequest(t, message.SetupID{1}, 1))
+	helperCheckRejection(t, err, QueryUnknownSetup)
+	if _, err := guard.ProcessRequest("a", helperGetRequest(t, message.SetupID{2}, 1)); err != nil {
+		t.Fatalf("ProcessRequest failed %v", err)
+	}
+	if _, total := guard.Sent("a", message.SetupID{1}); total != 0 || len(guard.clients) != 1 {
+		t.Errorf("the budgets of a withdrawn setup message should be dropped")
+	}
+	delete(setups, message.SetupID{2})
+	guard.Prune()
+	if len(guard.clients) != 0 || len(guard.global) != 0 {
+		t.Errorf("Prune should drop the budgets of the withdrawn setup messages")
+	}
+}
+
+func TestQueryGuardConcurrentBudget(t *testing.T) {
+	processor := &countingProcessor{}
+	guard := NewQueryGuard(processor, publishedSetups{{1}: true}, QueryPolicy{ClientBudget: 5})
+	setup := message.SetupID{1}
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 20; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			guard.ProcessRequest("a", helperGetRequest(t, setup, 1))
+		}()
+	}
+	wg.Wait()
+	if processor.calls != 5 {
+		t.Errorf("the budget should admit exactly 5 requests, admitted %v", processor.calls)
+	}
+}
+
+func TestQueryGuardCheck(t *testing.T) {
+	processor := &countingProcessor{}
+	//throttle clients sending several small requests.
+	guard := NewQueryGuard(processor, publishedSetups{{1}: true}, QueryPolicy{Check: func(client string, setup message.SetupID, elements int, sent int) bool {
+		return elements >= 10 || sent == 0
+	}})
+	setup := message.SetupID{1}
+
+	if _, err := guard.ProcessRequest("a", helperGetRequest(t, setup, 2)); err != nil {
+		t.Errorf("the first small request should be admitted %v", err)
+	}
+	_, err := guard.ProcessRequest("a", helperGetRequest(t, setup, 2))
+	helperCheckRejection(t, err, QuerySuspiciousClient)
+	if _, err := guard.ProcessRequest("a", helperGetRequest(t, setup, 10)); err != nil {
+		t.Errorf("a large request should be admitted %v", err)
+	}
+	if sent, _ := guard.Sent("a", setup); sent != 2 {
+		t.Errorf("rejected requests should not count against the budget, got %v", sent)
+	}
+}
+
+func TestQueryGuardServer(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer server.Close()
+	c, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer c.Close()
+
+	serverItems, clientItems, err := helperGetReports(10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setup, err := server.CreateSetupMessage(0.001, 100, serverItems)
+	if err != nil {
+		t.Fatalf("failed to create setup msg %v", err)
+	}
+	guard := NewQueryGuard(server, publishedSetups{setup.ID: true}, QueryPolicy{MinElements: 128, ClientBudget: 1})
+
+	request, err := c.CreateRequest(setup, clientItems[:1])
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	_, err = guard.ProcessRequest("a", request)
+	helperCheckRejection(t, err, QueryTooFewElements)
+
+	request, err = c.CreatePaddedRequest(setup, clientItems, client.PadToPowerOfTwo(128))
+	if err != nil {
+		t.Fatalf("failed to create request %v", err)
+	}
+	response, err := guard.ProcessRequest("a", request)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed %v", err)
+	}
+	if cnt, err := c.GetIntersectionSize(setup, response); err != nil || int(cnt) < len(clientItems)/2 {
+		t.Errorf("invalid intersection %v %v", cnt, err)
+	}
+	_, err = guard.ProcessRequest("a", request)
+	helperCheckRejection(t, err, QueryClientBudget)
+}
@@ -2,87 +2,219 @@ package server
 
 import (
 	"errors"
+	"fmt"
 	psiserver "github.com/openmined/psi/server"
+	"github.com/openmined/tcn-psi/message"
 	"github.com/openmined/tcn-psi/tcn"
+	"sync"
+	"time"
 )
 
+//ErrClosed is returned by the methods of a server which has been closed.
+var ErrClosed = errors.New("server context closed")
+
+//SetupBuilder creates setup messages tagged with a server key. It is implemented by TCNServer,
+//Pool and KeyManager.
+type SetupBuilder interface {
+	CreateSetupMessage(fpr float64, inputCount int64, reports []*tcn.SignedReport) (*message.SetupMessage, error)
+	KeyID() message.KeyID
+}
+
 //TCNServer context for the server side of a TCN-Private Set Intersection-Cardinality protocol.
+//
+//A TCNServer is safe for concurrent use by multiple goroutines, but calls on the same context
+//are serialised. Use a Pool to process requests in parallel.
 type TCNServer struct {
-	context *psiserver.PsiServer
+	mu       sync.Mutex
+	context  *psiserver.PsiServer
+	closed   bool
+	keyID    message.KeyID
+	observer Observer
 }
 
 //CreateWithNewKey creates and returns a new server instance with a fresh private key.
 //
 //Returns an error if any crypto operations fail.
 func CreateWithNewKey() (*TCNServer, error) {
-	tcnServer := new(TCNServer)
-
 	psiServer, err := psiserver.CreateWithNewKey(false)
 	if err != nil {
 		return nil, err
 	}
-	tcnServer.context = psiServer
-	return tcnServer, nil
+	return newServer(psiServer)
 }
 
 //CreateFromKey creates and returns a new server instance with the provided private key.
 //
 //Returns an error if any crypto operations fail.
 func CreateFromKey(key []byte) (*TCNServer, error) {
-	tcnServer := new(TCNServer)
-
 	psiServer, err := psiserver.CreateFromKey(key, false)
 	if err != nil {
 		return nil, err
 	}
-	tcnServer.context = psiServer
+	return newServer(psiServer)
+}
+
+func newServer(psiServer *psiserver.PsiServer) (*TCNServer, error) {
+	key, err := psiServer.GetPrivateKeyBytes()
+	if err != nil {
+		psiServer.Destroy()
+		return nil, err
+	}
+	tcnServer := &TCNServer{
+		context: psiServer,
+		keyID:   message.ComputeKeyID(key),
+	}
+	for idx := range key {
+		key[idx] = 0
+	}
 	return tcnServer, nil
 }
 
+//KeyID returns the public identifier of the server's private key. Setup messages are tagged
+//with it, and requests tagged with another key are rejected.
+func (s *TCNServer) KeyID() message.KeyID {
+	return s.keyID
+}
+
+//SetObserver registers observer to be notified of the setup messages built and the requests
+//processed by the server. A nil observer disables the notifications.
+func (s *TCNServer) SetObserver(observer Observer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observer = observer
+}
+
+//contextError returns the error reported when the context cannot be used. Must be called
+//with s.mu held.
+func (s *TCNServer) contextError() error {
+	if s.closed {
+		return ErrClosed
+	}
+	return errors.New("invalid context")
+}
+
 //CreateSetupMessage creates a setup message from the server's dataset to be sent to the
-//client.
+//client. fpr is the false-positive rate of a client request of inputCount elements. The filter
+//is sized for the number of TCNs revealed by the reports. Reports breaking the default
+//IngestionPolicy, e.g. with an invalid signature, are left out.
+//
+//Returns an error if the context is invalid or if the encryption fails.
+func (s *TCNServer) CreateSetupMessage(fpr float64, inputCount int64, reports []*tcn.SignedReport) (*message.SetupMessage, error) {
+	setup, _, err := s.CreateSetupMessageWithPolicy(fpr, inputCount, reports, &IngestionPolicy{})
+	return setup, err
+}
+
+//CreateSetupMessageWithPolicy creates a setup message from the reports accepted by policy, and
+//returns a summary of the accepted and rejected reports.
 //
 //Returns an error if the context is invalid or if the encryption fails.
-func (s *TCNServer) CreateSetupMessage(fpr float64, inputCount int64, reports []*tcn.SignedReport) (string, error) {
+func (s *TCNServer) CreateSetupMessageWithPolicy(fpr float64, inputCount int64, reports []*tcn.SignedReport, policy *IngestionPolicy) (*message.SetupMessage, *IngestSummary, error) {
+	s.mu.Lock()
 	if s.context == nil {
-		return "", errors.New("invalid context")
+		err := s.contextError()
+		s.mu.Unlock()
+		return nil, nil, err
 	}
+	s.mu.Unlock()
 
-	contacts := []string{}
-	for idx := range reports {
-		candidates, err := reports[idx].Report.TemporaryContactNumbers()
-		if err != nil {
-			return "", err
-		}
-		for jdx := range candidates {
-			contacts = append(contacts, candidates[jdx].ToString())
-		}
+	//the reports are verified and expanded without holding the context.
+	plan, err := PlanSetup(reports, policy, SizeTarget{FPR: fpr, ClientInputs: inputCount})
+	if err != nil {
+		return nil, nil, err
 	}
-	return s.context.CreateSetupMessage(fpr, inputCount, contacts)
+	setup, err := s.BuildSetup(plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	return setup, plan.Summary, nil
+}
+
+//CreateSetupMessageFrom is like CreateSetupMessage, but reads the reports from an iterator, for
+//example over a report store, instead of holding all of them in memory.
+//
+//Returns an error if the context is invalid, if the encryption fails or the error of the
+//iterator.
+func (s *TCNServer) CreateSetupMessageFrom(fpr float64, inputCount int64, reports ReportIterator) (*message.SetupMessage, error) {
+	plan, err := PlanSetupFrom(reports, &IngestionPolicy{}, SizeTarget{FPR: fpr, ClientInputs: inputCount})
+	if err != nil {
+		return nil, err
+	}
+	return s.BuildSetup(plan)
 }
 
 //ProcessRequest processes a client query and returns the corresponding server response to
 //be sent to the client.
 //
-//Returns an error if the context is invalid.
-func (s *TCNServer) ProcessRequest(request string) (string, error) {
+//Returns an error if the context is invalid, if the request was created by another version of
+//the PSI library or if the request is malformed.
+func (s *TCNServer) ProcessRequest(request *message.Request) (*message.Response, error) {
+	start := time.Now()
+	s.mu.Lock()
+	response, err := s.processRequest(request)
+	observer := s.observer
+	s.mu.Unlock()
+	if observer != nil {
+		observer.RequestProcessed(request, response, time.Since(start), err)
+	}
+	return response, err
+}
+
+//processRequest processes a request. Must be called with s.mu held.
+func (s *TCNServer) processRequest(request *message.Request) (*message.Response, error) {
 	if s.context == nil {
-		return "", errors.New("invalid context")
+		return nil, s.contextError()
+	}
+	if request == nil {
+		return nil, errors.New("invalid request")
 	}
-	return s.context.ProcessRequest(request)
+
+	version := s.context.Version()
+	if err := message.CheckLibraryVersion(message.KindRequest, version, request.LibraryVersion); err != nil {
+		return nil, err
+	}
+	if request.KeyID != (message.KeyID{}) && request.KeyID != s.keyID {
+		return nil, fmt.Errorf("request is for server key %v, this server uses %v", request.KeyID, s.keyID)
+	}
+	response, err := s.context.ProcessRequest(request.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return message.NewResponse(request, version, response), nil
 }
 
 //GetPrivateKeyBytes returns this instance's private key. This key should only be used to
 //create other server instances. DO NOT SEND THIS KEY TO ANY OTHER PARTY!
 func (s *TCNServer) GetPrivateKeyBytes() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.context == nil {
-		return nil, errors.New("invalid context")
+		return nil, s.contextError()
 	}
 
 	return s.context.GetPrivateKeyBytes()
 }
 
-//Version of the library.
+//Version of the library. Returns an empty string if the context is invalid.
 func (s *TCNServer) Version() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.context == nil {
+		return ""
+	}
 	return s.context.Version()
 }
+
+//Close releases the native context, including the private key it holds. Any further call
+//on the server returns ErrClosed.
+//
+//Close is safe to call more than once.
+func (s *TCNServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.context != nil {
+		s.context.Destroy()
+		s.context = nil
+	}
+	s.closed = true
+	return nil
+}
@@ -3,8 +3,10 @@ package server
 import (
 	"bytes"
 	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/message"
 	"github.com/openmined/tcn-psi/tcn"
 	"regexp"
+	"sync"
 	"testing"
 )
 
@@ -90,16 +92,78 @@ func TestServerFailure(t *testing.T) {
 		t.Errorf("CreateSetupMessage should fail with an invalid context %v", err)
 	}
 
-	_, err = server.ProcessRequest("dummy")
+	_, err = server.ProcessRequest(&message.Request{Payload: "dummy"})
 	if err == nil {
 		t.Errorf("ProcessRequest should fail with an invalid context %v", err)
 	}
 
 	server, _ = CreateWithNewKey()
-	_, err = server.ProcessRequest("dummy")
+	_, err = server.ProcessRequest(&message.Request{LibraryVersion: server.Version(), Payload: "dummy"})
 	if err == nil {
 		t.Errorf("ProcessRequest should fail with an invalid input %v", err)
 	}
+	_, err = server.ProcessRequest(nil)
+	if err == nil {
+		t.Errorf("ProcessRequest should fail without a request %v", err)
+	}
+	_, err = server.ProcessRequest(&message.Request{LibraryVersion: "0.0.1", Payload: "dummy"})
+	if err == nil {
+		t.Errorf("ProcessRequest should fail with a request from another library version %v", err)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	serverItems, _, err := helperGetReports(10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// every call either succeeds or fails cleanly with ErrClosed.
+			if _, err := server.CreateSetupMessage(0.01, 100, serverItems); err != nil && err != ErrClosed {
+				t.Errorf("unexpected error %v", err)
+			}
+			if _, err := server.GetPrivateKeyBytes(); err != nil && err != ErrClosed {
+				t.Errorf("unexpected error %v", err)
+			}
+			server.Version()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Close(); err != nil {
+			t.Errorf("Close failed %v", err)
+		}
+	}()
+	wg.Wait()
+
+	if err := server.Close(); err != nil {
+		t.Errorf("second Close failed %v", err)
+	}
+	if _, err := server.CreateSetupMessage(0.01, 100, serverItems); err != ErrClosed {
+		t.Errorf("CreateSetupMessage on a closed server should fail with ErrClosed, got %v", err)
+	}
+	if _, err := server.ProcessRequest(&message.Request{Payload: "dummy"}); err != ErrClosed {
+		t.Errorf("ProcessRequest on a closed server should fail with ErrClosed, got %v", err)
+	}
+	if _, err := server.GetPrivateKeyBytes(); err != ErrClosed {
+		t.Errorf("GetPrivateKeyBytes on a closed server should fail with ErrClosed, got %v", err)
+	}
+	if server.Version() != "" {
+		t.Errorf("Version on a closed server should be empty")
+	}
+	if (&TCNServer{}).Version() != "" {
+		t.Errorf("Version with an invalid context should be empty")
+	}
 }
 
 func TestServerClient(t *testing.T) {
@@ -123,7 +187,7 @@ func TestServerClient(t *testing.T) {
 	if err != nil {
 		t.Errorf("failed to create setup msg %v", err)
 	}
-	request, err := client.CreateRequest(clientItems)
+	request, err := client.CreateRequest(setup, clientItems)
 	if err != nil {
 		t.Errorf("failed to create request %v", err)
 	}
@@ -166,7 +230,7 @@ func benchmarkServerSetup(cnt int, fpr float64, b *testing.B) {
 		}
 		total += cnt
 		//ugly hack for preventing compiler optimizations
-		dummyString = setup
+		dummyString = setup.Payload
 	}
 	b.ReportMetric(float64(total), "ElementsProcessed")
 }
@@ -204,11 +268,17 @@ func benchmarkServerProcessRequest(cnt int, b *testing.B) {
 			inputs = append(inputs, "Element "+string(i))
 		}
 
-		_, clientItems, err := helperGetReports(cnt)
+		serverItems, clientItems, err := helperGetReports(cnt)
 		if err != nil {
 			b.Error(err.Error())
 		}
-		request, err := client.CreateRequest(clientItems)
+		b.StopTimer()
+		setup, err := server.CreateSetupMessage(fpr3, int64(cnt), serverItems)
+		if err != nil {
+			b.Errorf("failed to create setup msg %v", err)
+		}
+		b.StartTimer()
+		request, err := client.CreateRequest(setup, clientItems)
 		if err != nil {
 			b.Errorf("failed to create request %v", err)
 		}
@@ -217,9 +287,9 @@ func benchmarkServerProcessRequest(cnt int, b *testing.B) {
 			b.Errorf("failed to process request %v", err)
 		}
 		total += cnt
-		b.ReportMetric(float64(len(serverResp)), "ResponseSize")
+		b.ReportMetric(float64(len(serverResp.Payload)), "ResponseSize")
 		//ugly hack for preventing compiler optimizations
-		dummyString = serverResp
+		dummyString = serverResp.Payload
 	}
 	b.ReportMetric(float64(total), "ElementsProcessed")
 }
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/tcn"
+	"sort"
+	"sync"
+	"time"
+)
+
+//day is the duration of a shard.
+const day = 24 * time.Hour
+
+//SignatureRefresh is how often DayShards signs its setup messages again, so that the verifiers
+//measuring the age of the signatures accept the shards of the past days, which do not change.
+const SignatureRefresh = time.Hour
+
+//supersededGrace is how long the setup message of a rebuilt shard is still answered, so that
+//the clients which downloaded it before the rebuild can query it.
+const supersededGrace = day
+
+//ShardInfo describes a published day shard.
+type ShardInfo struct {
+	//ID of the setup message of the shard.
+	ID message.SetupID
+	//Start and End delimit the ingestion day covered by the shard, in UTC.
+	Start time.Time
+	End   time.Time
+	//Reports is the number of reports in the shard.
+	Reports int
+	//Size of the setup message payload in bytes.
+	Size int
+}
+
+type dayShard struct {
+	start   time.Time
+	reports []*tcn.SignedReport
+	setup   *message.SetupMessage
+	//signed is set once the signature of setup matches the current signing key.
+	signed bool
+}
+
+//supersededSetup records the setup message of a rebuilt shard.
+type supersededSetup struct {
+	start    time.Time
+	replaced time.Time
+}
+
+//DayShards maintains one setup message per ingestion day and keeps only the shards of the last
+//retention days, so that the published data follows a rolling retention window.
+//
+//A DayShards is safe for concurrent use by multiple goroutines.
+type DayShards struct {
+	server     SetupBuilder
+	fpr        float64
+	inputCount int64
+	retention  int
+	now        func() time.Time
+
+	mu         sync.Mutex
+	shards     map[int64]*dayShard
+	signingKey ed25519.PrivateKey
+	//superseded holds the setup messages of the rebuilt shards still answered.
+	superseded map[message.SetupID]supersededSetup
+}
+
+//NewDayShards returns day shards built with server, using fpr and inputCount for every setup
+//message and keeping the shards of the last retention days, today included. When the key of
+//server is rotated, every shard is rebuilt with the new key on the next call to Manifest.
+//
+//Panics if retention is lower than 1, which would drop every report.
+func NewDayShards(server SetupBuilder, fpr float64, inputCount int64, retention int) *DayShards {
+	if retention < 1 {
+		panic(fmt.Sprintf("invalid retention of %v days", retention))
+	}
+	return &DayShards{
+		server:     server,
+		fpr:        fpr,
+		inputCount: inputCount,
+		retention:  retention,
+		now:        time.Now,
+		shards:     map[int64]*dayShard{},
+		superseded: map[message.SetupID]supersededSetup{},
+	}
+}
+
+//SetSigningKey signs the setup messages with the operator signing key, so that clients can
+//authenticate them wherever they were downloaded from. The published setup messages are
+//signed again on the next call to Manifest, and then once their signature is older than
+//SignatureRefresh. A nil key stops signing them.
+//
+//Returns an error if the key is invalid.
+func (d *DayShards) SetSigningKey(key ed25519.PrivateKey) error {
+	if key != nil && len(key) != ed25519.PrivateKeySize {
+		return errors.New("invalid signing key")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.signingKey = key
+	for _, shard := range d.shards {
+		shard.signed = false
+	}
+	return nil
+}
+
+//oldest returns the start of the oldest day kept in the retention window.
+func (d *DayShards) oldest() time.Time {
+	today := d.now().UTC().Truncate(day)
+	return today.Add(-time.Duration(d.retention-1) * day)
+}
+
+//Add adds reports ingested now to the shard of the current day.
+func (d *DayShards) Add(reports ...*tcn.SignedReport) error {
+	return d.AddAt(d.now(), reports...)
+}
+
+//AddAt adds reports ingested at t to the shard of the corresponding day. The setup message of
+//the shard is rebuilt on the next call to Manifest.
+//
+//Returns an error if t is outside of the retention window.
+func (d *DayShards) AddAt(t time.Time, reports ...*tcn.SignedReport) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	start := t.UTC().Truncate(day)
+	if start.Before(d.oldest()) {
+		return errors.New("ingestion time outside of the retention window")
+	}
+	if start.After(d.now().UTC()) {
+		return errors.New("ingestion time in the future")
+	}
+	shard, ok := d.shards[start.Unix()]
+	if !ok {
+		shard = &dayShard{start: start}
+		d.shards[start.Unix()] = shard
+	}
+	shard.reports = append(shard.reports, reports...)
+	d.supersede(shard)
+	return nil
+}
+
+//Expire drops the shards older than the retention window and returns how many were dropped.
+func (d *DayShards) Expire() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expire()
+}
+
+func (d *DayShards) expire() int {
+	oldest := d.oldest()
+	expired := 0
+	for key, shard := range d.shards {
+		if shard.start.Before(oldest) {
+			delete(d.shards, key)
+			expired++
+		}
+	}
+	for id, setup := range d.superseded {
+		if setup.start.Before(oldest) || d.now().Sub(setup.replaced) >= supersededGrace {
+			delete(d.superseded, id)
+		}
+	}
+	return expired
+}
+
+//supersede drops the setup message of shard, which is rebuilt on the next call to Manifest.
+func (d *DayShards) supersede(shard *dayShard) {
+	if shard.setup != nil {
+		d.superseded[shard.setup.ID] = supersededSetup{start: shard.start, replaced: d.now()}
+		shard.setup = nil
+	}
+}
+
+//Manifest drops the expired shards, rebuilds the setup messages of the shards which changed or
+//were built with a previous key, refreshes the signatures older than SignatureRefresh and
+//returns the description of the current shards, oldest first.
+//
+//Returns an error if a setup message cannot be created.
+func (d *DayShards) Manifest() ([]ShardInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire()
+
+	keyID := d.server.KeyID()
+	manifest := []ShardInfo{}
+	for _, shard := range d.shards {
+		if shard.setup != nil && shard.setup.KeyID != keyID {
+			d.supersede(shard)
+		}
+		if shard.setup == nil {
+			setup, err := d.server.CreateSetupMessage(d.fpr, d.inputCount, shard.reports)
+			if err != nil {
+				return nil, err
+			}
+			setup.Start = shard.start
+			setup.End = shard.start.Add(day)
+			shard.setup = setup
+			shard.signed = false
+		}
+		stale := d.signingKey != nil && d.now().Sub(shard.setup.SignedAt) >= SignatureRefresh
+		if !shard.signed || stale {
+			//the published message may be in use, sign a copy.
+			signed := *shard.setup
+			signed.SignedAt = time.Time{}
+			signed.Signature = nil
+			if d.signingKey != nil {
+				if err := signed.SignAt(d.signingKey, d.now()); err != nil {
+					return nil, err
+				}
+			}
+			shard.setup = &signed
+			shard.signed = true
+		}
+		manifest = append(manifest, ShardInfo{
+			ID:      shard.setup.ID,
+			Start:   shard.setup.Start,
+			End:     shard.setup.End,
+			Reports: len(shard.reports),
+			Size:    len(shard.setup.Payload),
+		})
+	}
+	sort.Slice(manifest, func(i, j int) bool {
+		return manifest[i].Start.Before(manifest[j].Start)
+	})
+	return manifest, nil
+}
+
+//Shard returns the setup message of a shard listed in the manifest.
+//
+//Returns an error if the shard expired or was rebuilt since the manifest was created.
+func (d *DayShards) Shard(id message.SetupID) (*message.SetupMessage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, shard := range d.shards {
+		if shard.setup != nil && shard.setup.ID == id {
+			return shard.setup, nil
+		}
+	}
+	return nil, errors.New("unknown shard")
+}
+
+//Published reports whether the setup message identified by id may be queried: it is the setup
+//message of a current shard, or the shard was rebuilt less than a day ago. The setup messages of
+//the expired shards are no longer published.
+func (d *DayShards) Published(id message.SetupID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	oldest := d.oldest()
+	if setup, ok := d.superseded[id]; ok {
+		return !setup.start.Before(oldest) && d.now().Sub(setup.replaced) < supersededGrace
+	}
+	for _, shard := range d.shards {
+		if shard.setup != nil && shard.setup.ID == id {
+			return !shard.start.Before(oldest)
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"github.com/openmined/tcn-psi/message"
+	"testing"
+	"time"
+)
+
+func TestDayShards(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	shards := NewDayShards(server, 0.001, 1000, 3)
+	now := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	shards.now = func() time.Time { return now }
+
+	serverItems, _, err := helperGetReports(20)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for idx, day := range []time.Time{now.Add(-2 * day), now.Add(-day), now} {
+		if err := shards.AddAt(day, serverItems[idx*3:idx*3+3]...); err != nil {
+			t.Fatalf("failed to add reports %v", err)
+		}
+	}
+	if err := shards.Add(serverItems[9]); err != nil {
+		t.Fatalf("failed to add reports %v", err)
+	}
+	if err := shards.AddAt(now.Add(-3*day), serverItems[0]); err == nil {
+		t.Errorf("AddAt should fail outside of the retention window")
+	}
+	if err := shards.AddAt(now.Add(day), serverItems[0]); err == nil {
+		t.Errorf("AddAt should fail in the future")
+	}
+
+	manifest, err := shards.Manifest()
+	if err != nil {
+		t.Fatalf("failed to create manifest %v", err)
+	}
+	if len(manifest) != 3 {
+		t.Fatalf("invalid number of shards %v", len(manifest))
+	}
+	for idx, info := range manifest {
+		expectedStart := time.Date(2020, 6, 8+idx, 0, 0, 0, 0, time.UTC)
+		if !info.Start.Equal(expectedStart) || !info.End.Equal(expectedStart.Add(day)) {
+			t.Errorf("invalid shard range %v %v", info.Start, info.End)
+		}
+		setup, err := shards.Shard(info.ID)
+		if err != nil || setup.ID != info.ID || len(setup.Payload) != info.Size {
+			t.Errorf("failed to get shard %v", err)
+		}
+	}
+	if manifest[2].Reports != 4 {
+		t.Errorf("invalid number of reports in the last shard %v", manifest[2].Reports)
+	}
+
+	// adding a report rebuilds only the shard of the day.
+	if err := shards.Add(serverItems[0]); err != nil {
+		t.Fatalf("failed to add reports %v", err)
+	}
+	updated, err := shards.Manifest()
+	if err != nil {
+		t.Fatalf("failed to create manifest %v", err)
+	}
+	if updated[0].ID != manifest[0].ID || updated[2].ID == manifest[2].ID {
+		t.Errorf("only the current shard should be rebuilt")
+	}
+	if _, err := shards.Shard(manifest[2].ID); err == nil {
+		t.Errorf("rebuilt shards should not be served")
+	}
+	// the rebuilt shard is still answered for the clients which downloaded it.
+	for _, info := range append(updated, manifest[2]) {
+		if !shards.Published(info.ID) {
+			t.Errorf("shard %v should be published", info.ID)
+		}
+	}
+	if shards.Published(message.SetupID{1}) {
+		t.Errorf("unknown setup messages should not be published")
+	}
+
+	// the window moves forward.
+	now = now.Add(2 * day)
+	if expired := shards.Expire(); expired != 2 {
+		t.Errorf("expected 2 expired shards, got %v", expired)
+	}
+	if shards.Published(manifest[0].ID) || shards.Published(manifest[2].ID) {
+		t.Errorf("expired and superseded shards should not be published")
+	}
+	manifest, err = shards.Manifest()
+	if err != nil || len(manifest) != 1 {
+		t.Fatalf("invalid manifest after expiry %v %v", len(manifest), err)
+	}
+}
+
+func TestDayShardsRetention(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	for _, retention := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewDayShards should panic with a retention of %v days", retention)
+				}
+			}()
+			NewDayShards(server, 0.001, 100, retention)
+		}()
+	}
+}
+
+func TestDayShardsSigning(t *testing.T) {
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	shards := NewDayShards(server, 0.001, 100, 3)
+	now := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	shards.now = func() time.Time { return now }
+	serverItems, _, err := helperGetReports(6)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := shards.Add(serverItems[:2]...); err != nil {
+		t.Fatalf("failed to add reports %v", err)
+	}
+	if err := shards.SetSigningKey(ed25519.PrivateKey("short")); err == nil {
+		t.Errorf("an invalid signing key should be rejected")
+	}
+
+	manifest, err := shards.Manifest()
+	if err != nil || len(manifest) != 1 {
+		t.Fatalf("failed to create manifest %v", err)
+	}
+	unsigned, _ := shards.Shard(manifest[0].ID)
+	if len(unsigned.Signature) != 0 {
+		t.Errorf("the setup should not be signed without a key")
+	}
+	if unsigned.Reports != 2 || unsigned.FPR != 0.001 || unsigned.CreatedAt.IsZero() {
+		t.Errorf("invalid setup metadata %v %v %v", unsigned.Reports, unsigned.FPR, unsigned.CreatedAt)
+	}
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := shards.SetSigningKey(private); err != nil {
+		t.Fatalf("SetSigningKey failed %v", err)
+	}
+	signedManifest, err := shards.Manifest()
+	if err != nil || signedManifest[0].ID != manifest[0].ID {
+		t.Fatalf("signing should not rebuild the setup %v", err)
+	}
+	signed, _ := shards.Shard(manifest[0].ID)
+	if err := signed.VerifySignature(public); err != nil || !signed.SignedAt.Equal(now) {
+		t.Errorf("the setup should be signed now %v %v", signed.SignedAt, err)
+	}
+	if len(unsigned.Signature) != 0 {
+		t.Errorf("the setup handed out before should not be modified")
+	}
+
+	if err := shards.Add(serverItems[2]); err != nil {
+		t.Fatalf("failed to add reports %v", err)
+	}
+	manifest, err = shards.Manifest()
+	if err != nil {
+		t.Fatalf("failed to create manifest %v", err)
+	}
+	rebuilt, _ := shards.Shard(manifest[0].ID)
+	if rebuilt.Reports != 3 || rebuilt.VerifySignature(public) != nil {
+		t.Errorf("a rebuilt setup should be signed")
+	}
+
+	//the shard does not change anymore, but its signature stays fresh.
+	for _, elapsed := range []time.Duration{time.Minute, SignatureRefresh, 2 * day} {
+		now = now.Add(elapsed)
+		refreshed, err := shards.Manifest()
+		if err != nil || len(refreshed) != 1 || refreshed[0].ID != manifest[0].ID {
+			t.Fatalf("refreshing the signature should not rebuild the setup %v", err)
+		}
+		setup, _ := shards.Shard(manifest[0].ID)
+		if age := now.Sub(setup.SignedAt); age >= SignatureRefresh || setup.VerifySignature(public) != nil {
+			t.Errorf("the signature of an unchanged shard should be refreshed, signed %v ago", age)
+		}
+		if elapsed == time.Minute && setup != rebuilt {
+			t.Errorf("a recent signature should not be refreshed")
+		}
+	}
+	if rebuilt.VerifySignature(public) != nil {
+		t.Errorf("the setup handed out before should not be modified")
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"errors"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/tcn"
+	"math"
+	"strconv"
+	"time"
+)
+
+//SizeTarget constrains the parameters of a setup message. Exactly one of FPR and MaxBytes must
+//be set.
+type SizeTarget struct {
+	//FPR is the target false-positive rate of a client request of ClientInputs elements.
+	FPR float64
+	//MaxBytes is the maximum size of the setup message payload in bytes. The lowest
+	//false-positive rate which fits is picked.
+	MaxBytes int
+	//ClientInputs is the number of elements of the client requests the false-positive rate
+	//applies to. The filter is sized so that the rate is met for the whole request. Defaults to
+	//1, which makes FPR the rate of a single element.
+	ClientInputs int64
+}
+
+//SetupPlan holds the parameters of a setup message before it is built, along with the
+//expanded TCNs of the accepted reports.
+type SetupPlan struct {
+	//Elements is the number of distinct TCNs in the setup message.
+	Elements int
+	//ClientInputs is the number of elements of the client requests FPR applies to.
+	ClientInputs int64
+	//FPR is the false-positive rate of a client request of ClientInputs elements.
+	FPR float64
+	//EstimatedSize is the size of the setup message payload in bytes.
+	EstimatedSize int
+	//Summary describes the reports accepted and rejected by the ingestion policy.
+	Summary *IngestSummary
+
+	contacts []string
+	//planning is the time spent applying the policy and expanding the reports.
+	planning time.Duration
+}
+
+//setupOverhead is the size of the JSON object wrapping the filter in the payload, without the
+//number of hash functions and the base64 encoded bits.
+const setupOverhead = len(`{"num_hash_functions":,"bits":""}`)
+
+//maxHashDigits bounds the number of digits of the number of hash functions of a filter.
+const maxHashDigits = 3
+
+//EstimateSetupSize returns the size in bytes of the payload of a setup message holding elements
+//items, for the false-positive rate fpr of a client request of clientInputs elements. The
+//payload is the Bloom filter, base64 encoded within a JSON object.
+func EstimateSetupSize(elements int, clientInputs int64, fpr float64) int {
+	if clientInputs <= 0 {
+		clientInputs = 1
+	}
+	rate := fpr / float64(clientInputs)
+	hashes := int(math.Ceil(-math.Log2(rate)))
+	size := setupOverhead + len(strconv.Itoa(hashes))
+	if elements <= 0 {
+		return size
+	}
+	bytes := int(math.Ceil(-float64(elements) * math.Log2(rate) / math.Ln2 / 8))
+	return size + 4*((bytes+2)/3)
+}
+
+//FPRForSize returns the lowest false-positive rate of a client request of clientInputs
+//elements for which the payload of a setup message holding elements items fits in size bytes.
+//Returns 1 or more if the size is too small for any rate.
+func FPRForSize(elements int, clientInputs int64, size int) float64 {
+	if elements <= 0 {
+		return 0
+	}
+	if clientInputs <= 0 {
+		clientInputs = 1
+	}
+	//a byte is kept as margin for the rounding of the filter size.
+	bytes := (size-setupOverhead-maxHashDigits)/4*3 - 1
+	if bytes <= 0 {
+		return 1
+	}
+	bits := 8 * float64(bytes)
+	return math.Exp(-bits*math.Ln2*math.Ln2/float64(elements)) * float64(clientInputs)
+}
+
+//PlanSetup applies policy to reports, expands the accepted ones and picks the parameters of the
+//setup message for target. The plan can be inspected before committing to build it with
+//BuildSetup.
+//
+//Returns an error if the target is invalid or cannot be reached, or if a report cannot be
+//expanded.
+func PlanSetup(reports []*tcn.SignedReport, policy *IngestionPolicy, target SizeTarget) (*SetupPlan, error) {
+	return PlanSetupFrom(IterateReports(reports), policy, target)
+}
+
+//PlanSetupFrom is like PlanSetup, but reads the reports from an iterator. Only the expanded TCNs
+//are kept in memory, not the reports.
+//
+//Returns an error if the target is invalid or cannot be reached, if a report cannot be expanded,
+//or the error of the iterator.
+func PlanSetupFrom(reports ReportIterator, policy *IngestionPolicy, target SizeTarget) (*SetupPlan, error) {
+	if (target.FPR > 0) == (target.MaxBytes > 0) {
+		return nil, errors.New("exactly one of the target FPR and size must be set")
+	}
+	if target.FPR >= 1 {
+		return nil, errors.New("invalid target FPR")
+	}
+	if target.ClientInputs < 0 {
+		return nil, errors.New("invalid number of client inputs")
+	}
+	if reports == nil {
+		return nil, errors.New("invalid report iterator")
+	}
+	start := time.Now()
+
+	if policy == nil {
+		policy = &IngestionPolicy{}
+	}
+	state := newPolicyState(policy)
+
+	contacts := []string{}
+	seen := map[tcn.TemporaryContactNumber]bool{}
+	err := reports(func(report *tcn.SignedReport) error {
+		if !state.add(report) {
+			return nil
+		}
+		candidates, err := report.Report.TemporaryContactNumbers()
+		if err != nil {
+			return err
+		}
+		for jdx := range candidates {
+			if seen[candidates[jdx]] {
+				continue
+			}
+			seen[candidates[jdx]] = true
+			contacts = append(contacts, candidates[jdx].ToString())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	summary := state.summary
+	summary.TCNs = len(contacts)
+
+	plan := &SetupPlan{
+		Elements:     len(contacts),
+		ClientInputs: target.ClientInputs,
+		Summary:      summary,
+		contacts:     contacts,
+		planning:     time.Since(start),
+	}
+	if plan.ClientInputs == 0 {
+		plan.ClientInputs = 1
+	}
+	if target.FPR > 0 {
+		plan.FPR = target.FPR
+	} else {
+		plan.FPR = FPRForSize(plan.Elements, plan.ClientInputs, target.MaxBytes)
+		if plan.FPR >= 1 {
+			return nil, errors.New("target size too small for the reports")
+		}
+		if plan.FPR == 0 {
+			//the filter is empty, any rate fits.
+			plan.FPR = 0.5
+		}
+	}
+	plan.EstimatedSize = EstimateSetupSize(plan.Elements, plan.ClientInputs, plan.FPR)
+	if target.MaxBytes > 0 && plan.EstimatedSize > target.MaxBytes {
+		return nil, errors.New("target size too small for the reports")
+	}
+	return plan, nil
+}
+
+//BuildSetup creates the setup message described by plan.
+//
+//Returns an error if the context is invalid or if the encryption fails.
+func (s *TCNServer) BuildSetup(plan *SetupPlan) (*message.SetupMessage, error) {
+	if plan == nil {
+		return nil, errors.New("invalid setup plan")
+	}
+
+	start := time.Now()
+	s.mu.Lock()
+	setup, err := s.buildSetup(plan)
+	observer := s.observer
+	s.mu.Unlock()
+	if observer != nil {
+		observer.SetupBuilt(plan, setup, plan.planning+time.Since(start), err)
+	}
+	return setup, err
+}
+
+//buildSetup creates the setup message described by plan. Must be called with s.mu held.
+func (s *TCNServer) buildSetup(plan *SetupPlan) (*message.SetupMessage, error) {
+	if s.context == nil {
+		return nil, s.contextError()
+	}
+	setup, err := s.context.CreateSetupMessage(plan.FPR, plan.ClientInputs, plan.contacts)
+	if err != nil {
+		return nil, err
+	}
+	result := message.NewSetupMessage(s.context.Version(), setup)
+	result.KeyID = s.keyID
+	result.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	result.FPR = plan.FPR
+	if plan.Summary != nil {
+		result.Reports = plan.Summary.Accepted
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSizing(t *testing.T) {
+	if EstimateSetupSize(0, 1, 0.001) > 40 || FPRForSize(0, 1, 100) != 0 {
+		t.Errorf("an empty filter should be empty")
+	}
+	size := EstimateSetupSize(1000, 1, 0.001)
+	// about 14.4 bits per element for a rate of 1e-3, base64 encoded.
+	if size < 2390 || size > 2450 {
+		t.Errorf("invalid estimated size %v", size)
+	}
+	if fpr := FPRForSize(1000, 1, size); math.Abs(fpr-0.001)/0.001 > 0.05 {
+		t.Errorf("FPRForSize should invert EstimateSetupSize, got %v", fpr)
+	}
+	// the rate of a request of 100 elements needs a larger filter.
+	if EstimateSetupSize(1000, 100, 0.001) <= size {
+		t.Errorf("the size should grow with the client inputs")
+	}
+	if fpr := FPRForSize(1000, 100, size); math.Abs(fpr-0.1)/0.1 > 0.05 {
+		t.Errorf("FPRForSize should scale with the client inputs, got %v", fpr)
+	}
+
+	serverItems, _, err := helperGetReports(20)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	plan, err := PlanSetup(serverItems, nil, SizeTarget{FPR: 0.0001})
+	if err != nil {
+		t.Fatalf("failed to plan setup %v", err)
+	}
+	if plan.Elements != 100 || plan.Summary.TCNs != 100 || plan.FPR != 0.0001 || plan.ClientInputs != 1 {
+		t.Errorf("invalid plan %+v", plan)
+	}
+	if plan.EstimatedSize != EstimateSetupSize(100, 1, 0.0001) {
+		t.Errorf("invalid estimated size %v", plan.EstimatedSize)
+	}
+
+	bounded, err := PlanSetup(serverItems, nil, SizeTarget{MaxBytes: 200, ClientInputs: 10})
+	if err != nil {
+		t.Fatalf("failed to plan setup %v", err)
+	}
+	if bounded.EstimatedSize > 200 || bounded.FPR <= plan.FPR || bounded.FPR >= 1 || bounded.ClientInputs != 10 {
+		t.Errorf("invalid bounded plan %+v", bounded)
+	}
+
+	for _, target := range []SizeTarget{{}, {FPR: 0.1, MaxBytes: 100}, {FPR: 1}, {MaxBytes: 30}, {FPR: 0.1, ClientInputs: -1}} {
+		if _, err := PlanSetup(serverItems, nil, target); err == nil {
+			t.Errorf("PlanSetup should fail with target %+v", target)
+		}
+	}
+
+	server, err := CreateWithNewKey()
+	if err != nil || server == nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	// the estimate matches the payload actually built, which honours the bound.
+	for _, p := range []*SetupPlan{plan, bounded} {
+		setup, err := server.BuildSetup(p)
+		if err != nil || setup.KeyID != server.KeyID() {
+			t.Fatalf("failed to build setup %v", err)
+		}
+		if len(setup.Payload) != p.EstimatedSize {
+			t.Errorf("invalid estimated size %v, the payload is %v bytes", p.EstimatedSize, len(setup.Payload))
+		}
+	}
+	if setup, _ := server.BuildSetup(bounded); len(setup.Payload) > 200 {
+		t.Errorf("the payload should fit in the target size, got %v bytes", len(setup.Payload))
+	}
+	if _, err := server.BuildSetup(nil); err == nil {
+		t.Errorf("BuildSetup should fail without a plan")
+	}
+}
@@ -0,0 +1,100 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+//SummaryHeader lists the columns written by WriteSummaryCSV.
+var SummaryHeader = []string{
+	"fpr", "population", "days", "tcns_per_day", "encounters", "reports", "reported_tcns",
+	"clients", "exposed", "measured_exposed", "false_exposures", "false_exposure_rate",
+	"true_total", "measured_total", "false_tcns", "false_tcn_rate", "missed_tcns",
+	"setup_bytes", "setup_seconds", "request_bytes_mean", "request_seconds_mean",
+	"process_seconds_mean", "intersection_seconds_mean",
+}
+
+//ChecksHeader lists the columns written by WriteChecksCSV.
+var ChecksHeader = []string{
+	"fpr", "client", "contacts", "true", "measured", "request_bytes", "request_seconds",
+	"process_seconds", "intersection_seconds",
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func formatSeconds(d time.Duration) string {
+	return formatFloat(d.Seconds())
+}
+
+//WriteSummaryCSV writes a header and one row per result.
+func WriteSummaryCSV(w io.Writer, results []*Result) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(SummaryHeader); err != nil {
+		return err
+	}
+	for _, r := range results {
+		s := r.Summarize()
+		row := []string{
+			formatFloat(r.FPR),
+			strconv.Itoa(r.Config.Population),
+			strconv.Itoa(r.Config.Days),
+			strconv.Itoa(r.Config.TCNsPerDay),
+			formatFloat(r.Config.Encounters),
+			strconv.Itoa(r.Reports),
+			strconv.Itoa(r.ReportedTCNs),
+			strconv.Itoa(s.Clients),
+			strconv.Itoa(s.Exposed),
+			strconv.Itoa(s.MeasuredExposed),
+			strconv.Itoa(s.FalseExposures),
+			formatFloat(s.FalseExposureRate),
+			strconv.FormatInt(s.TrueTotal, 10),
+			strconv.FormatInt(s.MeasuredTotal, 10),
+			strconv.FormatInt(s.FalseTCNs, 10),
+			formatFloat(s.FalseTCNRate),
+			strconv.FormatInt(s.MissedTCNs, 10),
+			strconv.Itoa(r.SetupSize),
+			formatSeconds(r.SetupTime),
+			formatFloat(s.MeanRequestSize),
+			formatSeconds(s.MeanRequestTime),
+			formatSeconds(s.MeanProcessTime),
+			formatSeconds(s.MeanIntersectionTime),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//WriteChecksCSV writes a header and one row per check of every result.
+func WriteChecksCSV(w io.Writer, results []*Result) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(ChecksHeader); err != nil {
+		return err
+	}
+	for _, r := range results {
+		for _, check := range r.Checks {
+			row := []string{
+				formatFloat(r.FPR),
+				strconv.Itoa(check.Client),
+				strconv.Itoa(check.Contacts),
+				strconv.FormatInt(check.True, 10),
+				strconv.FormatInt(check.Measured, 10),
+				strconv.Itoa(check.RequestSize),
+				formatSeconds(check.RequestTime),
+				formatSeconds(check.ProcessTime),
+				formatSeconds(check.IntersectionTime),
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
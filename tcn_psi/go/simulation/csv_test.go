@@ -0,0 +1,55 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestWriteCSV(t *testing.T) {
+	results := []*Result{}
+	for _, fpr := range []float64{0.01, 1e-6} {
+		results = append(results, &Result{
+			Config:    helperConfig(),
+			FPR:       fpr,
+			Reports:   3,
+			SetupSize: 1024,
+			SetupTime: 1500 * time.Millisecond,
+			Checks: []Check{
+				{Client: 0, Contacts: 4, True: 1, Measured: 1, RequestSize: 64, RequestTime: time.Millisecond},
+				{Client: 1, Contacts: 2, True: 0, Measured: 0, RequestSize: 32},
+			},
+		})
+	}
+
+	out := &bytes.Buffer{}
+	if err := WriteSummaryCSV(out, results); err != nil {
+		t.Fatalf("WriteSummaryCSV failed %v", err)
+	}
+	rows, err := csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV %v", err)
+	}
+	if len(rows) != 3 || len(rows[1]) != len(SummaryHeader) {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+	if rows[1][0] != "0.01" || rows[2][0] != "1e-06" || rows[1][1] != "60" || rows[1][17] != "1024" || rows[1][18] != "1.5" || rows[1][19] != "48" {
+		t.Errorf("unexpected summary row %v", rows[1])
+	}
+
+	out.Reset()
+	if err := WriteChecksCSV(out, results); err != nil {
+		t.Fatalf("WriteChecksCSV failed %v", err)
+	}
+	rows, err = csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV %v", err)
+	}
+	if len(rows) != 5 || len(rows[1]) != len(ChecksHeader) {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+	if rows[1][1] != "0" || rows[1][3] != "1" || rows[1][5] != "64" || rows[1][6] != "0.001" {
+		t.Errorf("unexpected check row %v", rows[1])
+	}
+}
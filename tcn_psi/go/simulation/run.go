@@ -0,0 +1,193 @@
+package simulation
+
+import (
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/server"
+	"time"
+)
+
+//Check is the outcome of the exposure check of a single person.
+type Check struct {
+	//Client is the index of the person in the population.
+	Client int
+	//Contacts is the number of distinct TCNs the person observed.
+	Contacts int
+	//True is the number of observed TCNs which were reported.
+	True int64
+	//Measured is the cardinality returned by the PSI protocol.
+	Measured int64
+	//RequestSize is the size of the encoded request, in bytes.
+	RequestSize int
+	//RequestTime, ProcessTime and IntersectionTime are the durations of the three steps of the
+	//round: the creation of the request, its processing by the server and the computation of
+	//the cardinality by the client.
+	RequestTime      time.Duration
+	ProcessTime      time.Duration
+	IntersectionTime time.Duration
+}
+
+//Result is the outcome of a simulation run for a single false-positive rate.
+type Result struct {
+	Config       Config
+	FPR          float64
+	Reports      int
+	ReportedTCNs int
+	//SetupSize is the size of the encoded setup message, in bytes.
+	SetupSize int
+	SetupTime time.Duration
+	Checks    []Check
+}
+
+//Run publishes the reports of the population in a setup message built for fpr and runs the
+//exposure check of every client. fpr is the false-positive rate of the check of the person with
+//the most contacts.
+//
+//Returns an error if any step of the protocol fails.
+func (p *Population) Run(fpr float64) (*Result, error) {
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		return nil, err
+	}
+	defer tcnServer.Close()
+	tcnClient, err := client.Create()
+	if err != nil {
+		return nil, err
+	}
+	defer tcnClient.Close()
+
+	inputCount := int64(1)
+	for _, idx := range p.clients() {
+		if contacts, _ := p.observed(idx); int64(len(contacts)) > inputCount {
+			inputCount = int64(len(contacts))
+		}
+	}
+
+	start := time.Now()
+	setup, err := tcnServer.CreateSetupMessage(fpr, inputCount, p.reports)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{
+		Config:       p.config,
+		FPR:          fpr,
+		Reports:      len(p.reports),
+		ReportedTCNs: len(p.reported),
+		SetupTime:    time.Since(start),
+		Checks:       []Check{},
+	}
+	data, err := setup.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	result.SetupSize = len(data)
+
+	for _, idx := range p.clients() {
+		contacts, reported := p.observed(idx)
+		check := Check{Client: idx, Contacts: len(contacts), True: reported}
+		//people who met nobody have nothing to check.
+		if len(contacts) == 0 {
+			result.Checks = append(result.Checks, check)
+			continue
+		}
+
+		start = time.Now()
+		request, err := tcnClient.CreateRequest(setup, contacts)
+		if err != nil {
+			return nil, err
+		}
+		check.RequestTime = time.Since(start)
+		data, err := request.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		check.RequestSize = len(data)
+
+		start = time.Now()
+		response, err := tcnServer.ProcessRequest(request)
+		if err != nil {
+			return nil, err
+		}
+		check.ProcessTime = time.Since(start)
+
+		start = time.Now()
+		if check.Measured, err = tcnClient.GetIntersectionSize(setup, response); err != nil {
+			return nil, err
+		}
+		check.IntersectionTime = time.Since(start)
+		result.Checks = append(result.Checks, check)
+	}
+	return result, nil
+}
+
+//Summary aggregates the checks of a Result.
+type Summary struct {
+	Clients int
+	//Exposed is the number of clients who observed at least one reported TCN.
+	Exposed int
+	//MeasuredExposed is the number of clients whose measured cardinality is positive.
+	MeasuredExposed int
+	//FalseExposures is the number of clients reported as exposed while they are not.
+	FalseExposures int
+	//FalseExposureRate is FalseExposures over the number of clients who are not exposed.
+	FalseExposureRate float64
+	//FalseTCNs is the number of observed TCNs wrongly counted as reported, and FalseTCNRate
+	//their share of the observed TCNs which were not reported.
+	FalseTCNs    int64
+	FalseTCNRate float64
+	//MissedTCNs is the number of reported TCNs the protocol did not count. It should be zero.
+	MissedTCNs int64
+	//TrueTotal and MeasuredTotal sum the cardinalities of every check.
+	TrueTotal     int64
+	MeasuredTotal int64
+	//MeanRequestSize and the mean durations are averaged over the clients who sent a request.
+	MeanRequestSize      float64
+	MeanRequestTime      time.Duration
+	MeanProcessTime      time.Duration
+	MeanIntersectionTime time.Duration
+}
+
+//Summarize aggregates the checks of r.
+func (r *Result) Summarize() *Summary {
+	s := &Summary{Clients: len(r.Checks)}
+	var contacts, requests int64
+	var requestSize, requestTime, processTime, intersectionTime int64
+	for _, check := range r.Checks {
+		s.TrueTotal += check.True
+		s.MeasuredTotal += check.Measured
+		contacts += int64(check.Contacts)
+		if check.True > 0 {
+			s.Exposed++
+		}
+		if check.Measured > 0 {
+			s.MeasuredExposed++
+			if check.True == 0 {
+				s.FalseExposures++
+			}
+		}
+		if check.Measured > check.True {
+			s.FalseTCNs += check.Measured - check.True
+		} else {
+			s.MissedTCNs += check.True - check.Measured
+		}
+		if check.Contacts > 0 {
+			requests++
+			requestSize += int64(check.RequestSize)
+			requestTime += int64(check.RequestTime)
+			processTime += int64(check.ProcessTime)
+			intersectionTime += int64(check.IntersectionTime)
+		}
+	}
+	if healthy := s.Clients - s.Exposed; healthy > 0 {
+		s.FalseExposureRate = float64(s.FalseExposures) / float64(healthy)
+	}
+	if unreported := contacts - s.TrueTotal; unreported > 0 {
+		s.FalseTCNRate = float64(s.FalseTCNs) / float64(unreported)
+	}
+	if requests > 0 {
+		s.MeanRequestSize = float64(requestSize) / float64(requests)
+		s.MeanRequestTime = time.Duration(requestTime / requests)
+		s.MeanProcessTime = time.Duration(processTime / requests)
+		s.MeanIntersectionTime = time.Duration(intersectionTime / requests)
+	}
+	return s
+}
@@ -0,0 +1,241 @@
+//Package simulation evaluates the accuracy and the cost of TCN-PSI on a synthetic population.
+//
+//Every member of the population broadcasts the TCNs of its own report authorization key, meets
+//other members, may get infected and then publishes a report. The reports are published in a
+//setup message and a sample of the population checks its observed TCNs against it, so the
+//cardinalities measured by the PSI protocol can be compared with the true ones.
+package simulation
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"github.com/openmined/tcn-psi/tcn"
+	"math"
+	"math/rand"
+)
+
+//Config describes the simulated population.
+type Config struct {
+	//Population is the number of simulated people.
+	Population int
+	//Days is the length of the simulation.
+	Days int
+	//TCNsPerDay is the number of TCNs broadcast by a person every day, e.g. 96 for a TCN
+	//every 15 minutes.
+	TCNsPerDay int
+	//Encounters is the average number of people met by a person every day.
+	Encounters float64
+	//InfectionRate is the daily probability for a person to get infected regardless of its
+	//encounters.
+	InfectionRate float64
+	//TransmissionRate is the probability for an encounter with an infected person to infect.
+	TransmissionRate float64
+	//ReportWindow is the number of days of TCNs revealed by a report.
+	ReportWindow int
+	//Clients is the number of people who check their exposure. Zero checks the whole
+	//population.
+	Clients int
+	//Seed makes the simulation reproducible.
+	Seed int64
+}
+
+//DefaultConfig returns a small population, quick to simulate.
+func DefaultConfig() Config {
+	return Config{
+		Population:       200,
+		Days:             14,
+		TCNsPerDay:       96,
+		Encounters:       5,
+		InfectionRate:    0.005,
+		TransmissionRate: 0.05,
+		ReportWindow:     14,
+		Seed:             1,
+	}
+}
+
+//Validate checks the consistency of the configuration.
+func (c *Config) Validate() error {
+	switch {
+	case c.Population < 2:
+		return errors.New("the population must hold at least two people")
+	case c.Days <= 0 || c.TCNsPerDay <= 0:
+		return errors.New("the number of days and of TCNs per day must be positive")
+	case c.Days*c.TCNsPerDay >= math.MaxUint16:
+		return errors.New("too many TCNs for a single report authorization key")
+	case c.Encounters < 0:
+		return errors.New("the number of encounters must not be negative")
+	case c.InfectionRate < 0 || c.InfectionRate > 1 || c.TransmissionRate < 0 || c.TransmissionRate > 1:
+		return errors.New("the rates must be in [0, 1]")
+	case c.ReportWindow <= 0:
+		return errors.New("the report window must be positive")
+	case c.Clients < 0 || c.Clients > c.Population:
+		return errors.New("the number of clients must be in [0, population]")
+	}
+	return nil
+}
+
+//person is a member of the population.
+type person struct {
+	rak *tcn.ReportAuthorizationKey
+	//tcns holds the TCN broadcast at every ratchet index, starting at 1.
+	tcns []tcn.TemporaryContactNumber
+	//observed holds the distinct TCNs received from other people.
+	observed map[tcn.TemporaryContactNumber]bool
+	//infected is the day the person got infected, or -1.
+	infected int
+}
+
+//Population is a simulated population, along with the reports it published.
+type Population struct {
+	config  Config
+	people  []*person
+	reports []*tcn.SignedReport
+	//reported holds the TCNs revealed by the reports.
+	reported map[tcn.TemporaryContactNumber]bool
+}
+
+//Generate simulates config.Days days of encounters and infections.
+//
+//Returns an error if the configuration is invalid or if a report cannot be created.
+func Generate(config Config) (*Population, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	random := rand.New(rand.NewSource(config.Seed))
+	p := &Population{config: config, reported: map[tcn.TemporaryContactNumber]bool{}}
+	for idx := 0; idx < config.Population; idx++ {
+		member, err := newPerson(random, config.Days*config.TCNsPerDay)
+		if err != nil {
+			return nil, err
+		}
+		p.people = append(p.people, member)
+	}
+
+	meetings := int(math.Round(float64(config.Population) * config.Encounters / 2))
+	for day := 0; day < config.Days; day++ {
+		newlyInfected := map[int]bool{}
+		for idx := 0; idx < meetings; idx++ {
+			a, b := random.Intn(config.Population), random.Intn(config.Population-1)
+			if b >= a {
+				b++
+			}
+			index := day*config.TCNsPerDay + random.Intn(config.TCNsPerDay)
+			p.people[a].observed[p.people[b].tcns[index]] = true
+			p.people[b].observed[p.people[a].tcns[index]] = true
+			if p.transmits(random, a, b) {
+				newlyInfected[b] = true
+			}
+			if p.transmits(random, b, a) {
+				newlyInfected[a] = true
+			}
+		}
+		for idx, member := range p.people {
+			if member.infected < 0 && random.Float64() < config.InfectionRate {
+				newlyInfected[idx] = true
+			}
+		}
+		//the people infected today report at the end of the day.
+		for idx := 0; idx < config.Population; idx++ {
+			if !newlyInfected[idx] || p.people[idx].infected >= 0 {
+				continue
+			}
+			if err := p.report(idx, day); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+//newPerson creates a person broadcasting count TCNs, with a key derived from random.
+func newPerson(random *rand.Rand, count int) (*person, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	random.Read(seed)
+	key := ed25519.NewKeyFromSeed(seed)
+	rak := &tcn.ReportAuthorizationKey{RAK: key, RVK: key.Public().(ed25519.PublicKey)}
+
+	member := &person{rak: rak, observed: map[tcn.TemporaryContactNumber]bool{}, infected: -1}
+	tck, err := rak.InitialTCK()
+	if err != nil {
+		return nil, err
+	}
+	for idx := 0; idx < count; idx++ {
+		value, err := tck.TemporaryContactNumber()
+		if err != nil {
+			return nil, err
+		}
+		member.tcns = append(member.tcns, *value)
+		if tck, err = tck.Ratchet(); err != nil {
+			return nil, err
+		}
+	}
+	return member, nil
+}
+
+//transmits returns true if the person from, already infected, infects the person to.
+func (p *Population) transmits(random *rand.Rand, from, to int) bool {
+	return p.people[from].infected >= 0 && p.people[to].infected < 0 && random.Float64() < p.config.TransmissionRate
+}
+
+//report marks the person idx as infected on day and publishes the TCNs it broadcast during
+//the report window.
+func (p *Population) report(idx, day int) error {
+	member := p.people[idx]
+	member.infected = day
+	first := day - p.config.ReportWindow + 1
+	if first < 0 {
+		first = 0
+	}
+	j1, j2 := first*p.config.TCNsPerDay+1, (day+1)*p.config.TCNsPerDay
+	report, err := member.rak.CreateSignedReport(tcn.CoEpiV1Code, []byte{}, uint16(j1), uint16(j2))
+	if err != nil {
+		return err
+	}
+	p.reports = append(p.reports, report)
+	for _, value := range member.tcns[j1-1 : j2] {
+		p.reported[value] = true
+	}
+	return nil
+}
+
+//Reports returns the reports published by the population.
+func (p *Population) Reports() []*tcn.SignedReport {
+	return p.reports
+}
+
+//Infected returns the number of people who got infected.
+func (p *Population) Infected() int {
+	return len(p.reports)
+}
+
+//ReportedTCNs returns the number of distinct TCNs revealed by the reports.
+func (p *Population) ReportedTCNs() int {
+	return len(p.reported)
+}
+
+//clients returns the indices of the people who check their exposure.
+func (p *Population) clients() []int {
+	count := p.config.Clients
+	if count == 0 {
+		count = len(p.people)
+	}
+	//people are created in a random order, so the first ones are a random sample.
+	clients := []int{}
+	for idx := 0; idx < count; idx++ {
+		clients = append(clients, idx)
+	}
+	return clients
+}
+
+//observed returns the TCNs observed by the person idx, and how many of them were reported.
+func (p *Population) observed(idx int) ([]tcn.TemporaryContactNumber, int64) {
+	contacts := []tcn.TemporaryContactNumber{}
+	var reported int64
+	for value := range p.people[idx].observed {
+		contacts = append(contacts, value)
+		if p.reported[value] {
+			reported++
+		}
+	}
+	return contacts, reported
+}
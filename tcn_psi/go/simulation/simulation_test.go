@@ -0,0 +1,141 @@
+package simulation
+
+import (
+	"testing"
+)
+
+func helperConfig() Config {
+	config := DefaultConfig()
+	config.Population = 60
+	config.Days = 5
+	config.TCNsPerDay = 24
+	config.Encounters = 4
+	config.InfectionRate = 0.05
+	config.TransmissionRate = 0.2
+	config.ReportWindow = 3
+	return config
+}
+
+func TestValidate(t *testing.T) {
+	config := helperConfig()
+	if err := config.Validate(); err != nil {
+		t.Errorf("the configuration should be valid %v", err)
+	}
+	for _, change := range []func(c *Config){
+		func(c *Config) { c.Population = 1 },
+		func(c *Config) { c.Days = 0 },
+		func(c *Config) { c.TCNsPerDay = 20000 },
+		func(c *Config) { c.Encounters = -1 },
+		func(c *Config) { c.InfectionRate = 1.5 },
+		func(c *Config) { c.TransmissionRate = -0.1 },
+		func(c *Config) { c.ReportWindow = 0 },
+		func(c *Config) { c.Clients = c.Population + 1 },
+	} {
+		config := helperConfig()
+		change(&config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("the configuration should be invalid %+v", config)
+		}
+		if _, err := Generate(config); err == nil {
+			t.Errorf("Generate should reject %+v", config)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	config := helperConfig()
+	p, err := Generate(config)
+	if err != nil {
+		t.Fatalf("Generate failed %v", err)
+	}
+	if p.Infected() == 0 || p.Infected() > config.Population {
+		t.Fatalf("unexpected number of infections %v", p.Infected())
+	}
+	if len(p.Reports()) != p.Infected() {
+		t.Errorf("every infected person should report")
+	}
+	for _, report := range p.Reports() {
+		if valid, err := report.Verify(); err != nil || !valid {
+			t.Errorf("invalid report signature %v", err)
+		}
+		span := int(report.J2-report.J1) + 1
+		if span%config.TCNsPerDay != 0 || span > config.ReportWindow*config.TCNsPerDay {
+			t.Errorf("unexpected report range %v-%v", report.J1, report.J2)
+		}
+		tcns, err := report.TemporaryContactNumbers()
+		if err != nil {
+			t.Fatalf("TemporaryContactNumbers failed %v", err)
+		}
+		for _, value := range tcns {
+			if !p.reported[value] {
+				t.Fatalf("a reported TCN is missing from the ground truth")
+			}
+		}
+	}
+
+	other, err := Generate(config)
+	if err != nil {
+		t.Fatalf("Generate failed %v", err)
+	}
+	if other.Infected() != p.Infected() || other.ReportedTCNs() != p.ReportedTCNs() {
+		t.Errorf("the simulation should be reproducible with the same seed")
+	}
+	config.Seed++
+	if other, _ := Generate(config); other.people[0].tcns[0] == p.people[0].tcns[0] {
+		t.Errorf("another seed should generate other keys")
+	}
+}
+
+func TestRun(t *testing.T) {
+	config := helperConfig()
+	config.Clients = 20
+	p, err := Generate(config)
+	if err != nil {
+		t.Fatalf("Generate failed %v", err)
+	}
+	result, err := p.Run(0.001)
+	if err != nil {
+		t.Fatalf("Run failed %v", err)
+	}
+	if len(result.Checks) != config.Clients || result.Reports != p.Infected() || result.SetupSize == 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	for _, check := range result.Checks {
+		if check.Measured < check.True {
+			t.Errorf("the protocol should not miss reported TCNs %+v", check)
+		}
+		if check.Contacts > 0 && check.RequestSize == 0 {
+			t.Errorf("missing request size %+v", check)
+		}
+	}
+
+	summary := result.Summarize()
+	if summary.Clients != config.Clients || summary.MissedTCNs != 0 || summary.Exposed == 0 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if summary.MeasuredTotal != summary.TrueTotal+summary.FalseTCNs {
+		t.Errorf("inconsistent totals %+v", summary)
+	}
+	if summary.MeasuredExposed != summary.Exposed+summary.FalseExposures {
+		t.Errorf("inconsistent exposures %+v", summary)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	result := &Result{Checks: []Check{
+		{Contacts: 10, True: 2, Measured: 2, RequestSize: 100},
+		{Contacts: 10, True: 0, Measured: 1, RequestSize: 300},
+		{Contacts: 10, True: 0, Measured: 0, RequestSize: 200},
+		{Contacts: 0},
+	}}
+	s := result.Summarize()
+	if s.Exposed != 1 || s.MeasuredExposed != 2 || s.FalseExposures != 1 || s.FalseExposureRate != 1.0/3 {
+		t.Errorf("unexpected exposures %+v", s)
+	}
+	if s.FalseTCNs != 1 || s.FalseTCNRate != 1.0/28 || s.TrueTotal != 2 || s.MeasuredTotal != 3 {
+		t.Errorf("unexpected TCN counts %+v", s)
+	}
+	if s.MeanRequestSize != 200 {
+		t.Errorf("the mean should only cover the clients who sent a request %v", s.MeanRequestSize)
+	}
+}
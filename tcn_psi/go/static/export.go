@@ -0,0 +1,266 @@
+//Package static publishes setup messages as static files, so that they can be served by any web
+//server or CDN, and loads them on the client side.
+//
+//An export directory holds:
+//
+//	manifest.json        signed JSON manifest of the published setup messages
+//	setup/{sha256}.bin   binary setup message, named after the SHA-256 digest of its content
+//
+//The setup files never change once written and can be cached forever. The manifest must be
+//revalidated by the clients, e.g. served with "Cache-Control: no-cache".
+package static
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//ManifestFile is the name of the manifest in an export directory.
+const ManifestFile = "manifest.json"
+
+//setupDir is the directory holding the setup files in an export directory.
+const setupDir = "setup"
+
+//manifestVersion is the version of the manifest format.
+const manifestVersion = 1
+
+//manifestDomainSep is the domain separator of the manifest signatures.
+var manifestDomainSep = []byte("TCN-PSI static manifest")
+
+//SetupJSON describes a published setup message in the manifest.
+type SetupJSON struct {
+	ID string `json:"id"`
+	//File is the path of the setup file relative to the manifest.
+	File string `json:"file"`
+	//SHA256 is the hexadecimal digest of the setup file.
+	SHA256 string `json:"sha256"`
+	//Size of the setup file in bytes.
+	Size    int       `json:"size"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Reports int       `json:"reports"`
+	FPR     float64   `json:"fpr"`
+	KeyID   string    `json:"key_id"`
+}
+
+//ManifestJSON is the content of the manifest, signed with the operator signing key.
+type ManifestJSON struct {
+	Version   int         `json:"version"`
+	CreatedAt time.Time   `json:"created_at"`
+	Setups    []SetupJSON `json:"setups"`
+	Signature []byte      `json:"signature,omitempty"`
+}
+
+//signedData returns the data covered by the signature: the JSON encoding of the manifest
+//without its signature.
+func (m *ManifestJSON) signedData() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = nil
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, manifestDomainSep...), data...), nil
+}
+
+//Sign signs the manifest with the operator signing key.
+//
+//Returns an error if the key is invalid.
+func (m *ManifestJSON) Sign(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return errors.New("invalid signing key")
+	}
+	data, err := m.signedData()
+	if err != nil {
+		return err
+	}
+	m.Signature = ed25519.Sign(key, data)
+	return nil
+}
+
+//VerifySignature checks that the manifest was signed with the private key of one of keys.
+//
+//Returns message.ErrUnsigned if the manifest carries no signature, and
+//message.ErrInvalidSignature if it was modified or signed with another key.
+func (m *ManifestJSON) VerifySignature(keys ...ed25519.PublicKey) error {
+	if len(m.Signature) == 0 {
+		return message.ErrUnsigned
+	}
+	if len(m.Signature) != ed25519.SignatureSize {
+		return message.ErrInvalidSignature
+	}
+	data, err := m.signedData()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if len(key) == ed25519.PublicKeySize && ed25519.Verify(key, data, m.Signature) {
+			return nil
+		}
+	}
+	return message.ErrInvalidSignature
+}
+
+//Exporter writes setup messages to an export directory.
+//
+//An Exporter must not be used concurrently, and a directory must be written by a single
+//Exporter.
+type Exporter struct {
+	dir        string
+	signingKey ed25519.PrivateKey
+	now        func() time.Time
+}
+
+//NewExporter returns an exporter writing to dir, creating the directory if needed, and signing
+//the manifest with the operator signing key.
+//
+//Returns an error if the key is invalid or if the directory cannot be created.
+func NewExporter(dir string, signingKey ed25519.PrivateKey) (*Exporter, error) {
+	if len(signingKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid signing key")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, setupDir), 0755); err != nil {
+		return nil, err
+	}
+	return &Exporter{dir: dir, signingKey: signingKey, now: time.Now}, nil
+}
+
+//Export writes the setup files of setups, then replaces the manifest. The setup files which are
+//referenced by neither the new nor the previous manifest are removed, so that the clients which
+//just downloaded the previous manifest can still fetch its files.
+//
+//Returns the new manifest, or an error if a setup message is invalid or if a file cannot be
+//written.
+func (e *Exporter) Export(setups []*message.SetupMessage) (*ManifestJSON, error) {
+	manifest := &ManifestJSON{
+		Version:   manifestVersion,
+		CreatedAt: e.now().UTC().Truncate(time.Second),
+		Setups:    []SetupJSON{},
+	}
+	for _, setup := range setups {
+		if setup == nil {
+			return nil, errors.New("invalid setup message")
+		}
+		if err := setup.Verify(); err != nil {
+			return nil, err
+		}
+		data, err := setup.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(data)
+		name := path.Join(setupDir, hex.EncodeToString(digest[:])+".bin")
+		if err := writeOnce(filepath.Join(e.dir, filepath.FromSlash(name)), data); err != nil {
+			return nil, err
+		}
+		manifest.Setups = append(manifest.Setups, SetupJSON{
+			ID:      setup.ID.String(),
+			File:    name,
+			SHA256:  hex.EncodeToString(digest[:]),
+			Size:    len(data),
+			Start:   setup.Start,
+			End:     setup.End,
+			Reports: setup.Reports,
+			FPR:     setup.FPR,
+			KeyID:   setup.KeyID.String(),
+		})
+	}
+	sort.SliceStable(manifest.Setups, func(i, j int) bool {
+		return manifest.Setups[i].Start.Before(manifest.Setups[j].Start)
+	})
+	if err := manifest.Sign(e.signingKey); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	keep := map[string]bool{}
+	for _, setup := range manifest.Setups {
+		keep[setup.File] = true
+	}
+	if previous, err := e.readManifest(); err == nil {
+		for _, setup := range previous.Setups {
+			keep[setup.File] = true
+		}
+	}
+	if err := writeFile(filepath.Join(e.dir, ManifestFile), data); err != nil {
+		return nil, err
+	}
+	e.prune(keep)
+	return manifest, nil
+}
+
+//ExportShards exports the current setup messages of shards.
+//
+//Returns an error if the setup messages cannot be built, or if the export fails.
+func (e *Exporter) ExportShards(shards *server.DayShards) (*ManifestJSON, error) {
+	infos, err := shards.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	setups := []*message.SetupMessage{}
+	for _, info := range infos {
+		setup, err := shards.Shard(info.ID)
+		if err != nil {
+			return nil, err
+		}
+		setups = append(setups, setup)
+	}
+	return e.Export(setups)
+}
+
+//readManifest reads the current manifest of the directory, without verifying it.
+func (e *Exporter) readManifest() (*ManifestJSON, error) {
+	data, err := ioutil.ReadFile(filepath.Join(e.dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &ManifestJSON{}
+	return manifest, json.Unmarshal(data, manifest)
+}
+
+//prune removes the setup files which are not kept. Failures are ignored, the files are removed
+//by the next export.
+func (e *Exporter) prune(keep map[string]bool) {
+	files, err := ioutil.ReadDir(filepath.Join(e.dir, setupDir))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		name := path.Join(setupDir, file.Name())
+		if !keep[name] && (strings.HasSuffix(name, ".bin") || strings.HasSuffix(name, ".tmp")) {
+			os.Remove(filepath.Join(e.dir, filepath.FromSlash(name)))
+		}
+	}
+}
+
+//writeOnce writes a content-addressed file, unless it already exists.
+func writeOnce(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return writeFile(path, data)
+}
+
+//writeFile atomically replaces the content of path, readable by the web server.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
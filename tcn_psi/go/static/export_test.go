@@ -0,0 +1,145 @@
+package static
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/tcn"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+//helperShards returns signed shards holding one report on each of the last three days, along
+//with the TCNs revealed by each report, oldest first.
+func helperShards(t *testing.T) (*server.TCNServer, *server.DayShards, ed25519.PrivateKey, []tcn.TemporaryContactNumber) {
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	t.Cleanup(func() { tcnServer.Close() })
+	_, signingKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	shards := server.NewDayShards(tcnServer, 0.001, 100, 7)
+	if err := shards.SetSigningKey(signingKey); err != nil {
+		t.Fatal(err.Error())
+	}
+	reports, tcns := reporttest.Reports(t, 3)
+	now := time.Now()
+	for idx, report := range reports {
+		if err := shards.AddAt(now.Add(-time.Duration(2-idx)*day), report); err != nil {
+			t.Fatalf("AddAt failed %v", err)
+		}
+	}
+	return tcnServer, shards, signingKey, tcns
+}
+
+func helperExporter(t *testing.T, signingKey ed25519.PrivateKey) (*Exporter, string) {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	exporter, err := NewExporter(dir, signingKey)
+	if err != nil {
+		t.Fatalf("NewExporter failed %v", err)
+	}
+	return exporter, dir
+}
+
+func TestExport(t *testing.T) {
+	tcnServer, shards, signingKey, _ := helperShards(t)
+	exporter, dir := helperExporter(t, signingKey)
+	manifest, err := exporter.ExportShards(shards)
+	if err != nil {
+		t.Fatalf("ExportShards failed %v", err)
+	}
+	if len(manifest.Setups) != 3 {
+		t.Fatalf("unexpected setups %+v", manifest.Setups)
+	}
+	for idx, entry := range manifest.Setups {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(entry.File)))
+		if err != nil {
+			t.Fatalf("missing setup file %v", err)
+		}
+		digest := sha256.Sum256(data)
+		if entry.SHA256 != hex.EncodeToString(digest[:]) || entry.File != "setup/"+entry.SHA256+".bin" || entry.Size != len(data) {
+			t.Errorf("the setup file should be addressed by its content %+v", entry)
+		}
+		setup := &message.SetupMessage{}
+		if err := setup.UnmarshalBinary(data); err != nil {
+			t.Fatalf("invalid setup file %v", err)
+		}
+		if entry.ID != setup.ID.String() || entry.KeyID != tcnServer.KeyID().String() || entry.Reports != 1 || entry.FPR != 0.001 || !entry.End.Equal(entry.Start.Add(day)) {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+		if idx > 0 && !entry.Start.After(manifest.Setups[idx-1].Start) {
+			t.Errorf("the setups should be sorted oldest first")
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		t.Fatalf("missing manifest %v", err)
+	}
+	stored := &ManifestJSON{}
+	if err := json.Unmarshal(data, stored); err != nil {
+		t.Fatalf("invalid manifest %v", err)
+	}
+	public := signingKey.Public().(ed25519.PublicKey)
+	if err := stored.VerifySignature(public); err != nil {
+		t.Errorf("the manifest should be signed %v", err)
+	}
+	stored.Setups[0].Reports++
+	if err := stored.VerifySignature(public); err != message.ErrInvalidSignature {
+		t.Errorf("a modified manifest should not verify %v", err)
+	}
+	stored.Signature = nil
+	if err := stored.VerifySignature(public); err != message.ErrUnsigned {
+		t.Errorf("an unsigned manifest should not verify %v", err)
+	}
+
+	//the files of the previous manifest are kept for one more export.
+	reports, _ := reporttest.Reports(t, 1)
+	if err := shards.Add(reports...); err != nil {
+		t.Fatal(err.Error())
+	}
+	replaced := manifest.Setups[2].File
+	updated, err := exporter.ExportShards(shards)
+	if err != nil {
+		t.Fatalf("ExportShards failed %v", err)
+	}
+	if updated.Setups[2].File == replaced || updated.Setups[0].File != manifest.Setups[0].File {
+		t.Errorf("only the changed setup message should be replaced")
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(replaced))); err != nil {
+		t.Errorf("the replaced file should be kept %v", err)
+	}
+	if _, err := exporter.ExportShards(shards); err != nil {
+		t.Fatalf("ExportShards failed %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(replaced))); !os.IsNotExist(err) {
+		t.Errorf("the replaced file should be removed %v", err)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, setupDir))
+	if err != nil || len(files) != 3 {
+		t.Errorf("unexpected setup files %v %v", len(files), err)
+	}
+
+	if _, err := NewExporter(dir, nil); err == nil {
+		t.Errorf("an invalid signing key should be rejected")
+	}
+	if _, err := exporter.Export([]*message.SetupMessage{nil}); err == nil {
+		t.Errorf("an invalid setup message should be rejected")
+	}
+}
@@ -0,0 +1,220 @@
+package static
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/message"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultMaxSetupBytes is the default maximum size of a setup file.
+const DefaultMaxSetupBytes = 256 << 20
+
+//maxManifestBytes bounds the size of a manifest.
+const maxManifestBytes = 1 << 20
+
+//Loader downloads the setup messages exported to a static location, e.g. a CDN. It
+//authenticates the manifest with the pinned operator keys, only downloads the setup files it
+//needs and does not already hold, and checks every file against the digest of the manifest.
+//
+//A Loader is safe for concurrent use by multiple goroutines, as long as its fields are not
+//modified.
+type Loader struct {
+	baseURL string
+	http    *http.Client
+	keys    []ed25519.PublicKey
+
+	//MaxAge is the age beyond which a manifest is rejected as stale, 0 to accept any age.
+	MaxAge time.Duration
+	//MaxSetupBytes bounds the size of a setup file. Defaults to DefaultMaxSetupBytes.
+	MaxSetupBytes int64
+	//Verifier, if set, also authenticates every setup message.
+	Verifier *client.SetupVerifier
+
+	now func() time.Time
+
+	mu sync.Mutex
+	//setups holds the downloaded setup messages of the last manifest, by digest.
+	setups map[string]*message.SetupMessage
+}
+
+//NewLoader returns a loader of the export published at baseURL, e.g.
+//"https://cdn.example.org/tcn", using httpClient, or http.DefaultClient if nil. The manifest
+//must be signed with one of keys.
+//
+//Returns an error if baseURL is not an absolute HTTP(S) URL or if no valid key is provided.
+func NewLoader(baseURL string, httpClient *http.Client, keys ...ed25519.PublicKey) (*Loader, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errors.New("the export URL must be an absolute http or https URL")
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("at least one operator key is required")
+	}
+	pinned := []ed25519.PublicKey{}
+	for _, key := range keys {
+		if len(key) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid operator key")
+		}
+		pinned = append(pinned, append(ed25519.PublicKey{}, key...))
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Loader{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		http:          httpClient,
+		keys:          pinned,
+		MaxSetupBytes: DefaultMaxSetupBytes,
+		now:           time.Now,
+		setups:        map[string]*message.SetupMessage{},
+	}, nil
+}
+
+//get downloads the file at name, relative to the base URL, of at most limit bytes.
+func (l *Loader) get(ctx context.Context, name string, limit int64) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, l.baseURL+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := l.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(response.Body, 4096))
+		return nil, fmt.Errorf("GET %v: %v", name, response.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("GET %v: file too large", name)
+	}
+	return data, nil
+}
+
+//Manifest downloads and authenticates the manifest.
+//
+//Returns an error if the download fails, if the manifest is malformed, is not signed with a
+//pinned key, or is stale.
+func (l *Loader) Manifest(ctx context.Context) (*ManifestJSON, error) {
+	data, err := l.get(ctx, ManifestFile, maxManifestBytes)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &ManifestJSON{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if manifest.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+	if err := manifest.VerifySignature(l.keys...); err != nil {
+		return nil, err
+	}
+	now := l.now()
+	if manifest.CreatedAt.After(now.Add(client.DefaultMaxClockSkew)) {
+		return nil, fmt.Errorf("manifest created in the future at %v", manifest.CreatedAt)
+	}
+	if l.MaxAge > 0 && now.Sub(manifest.CreatedAt) > l.MaxAge {
+		return nil, fmt.Errorf("stale manifest created %v ago", now.Sub(manifest.CreatedAt).Truncate(time.Second))
+	}
+	return manifest, nil
+}
+
+//Load returns the published setup messages covering reports ingested after since, oldest
+//first, e.g. the start of the oldest day with contacts. A zero since loads every setup message.
+//Only the setup files which were not downloaded by a previous call are downloaded.
+//
+//Returns an error if the manifest cannot be loaded, or if a setup file cannot be downloaded or
+//does not match the manifest.
+func (l *Loader) Load(ctx context.Context, since time.Time) ([]*message.SetupMessage, error) {
+	manifest, err := l.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	known := l.setups
+	l.mu.Unlock()
+	current := map[string]*message.SetupMessage{}
+	setups := []*message.SetupMessage{}
+	for _, entry := range manifest.Setups {
+		if !since.IsZero() && !entry.End.IsZero() && !entry.End.After(since) {
+			continue
+		}
+		setup, ok := known[entry.SHA256]
+		if !ok {
+			if setup, err = l.fetch(ctx, &entry); err != nil {
+				return nil, err
+			}
+		}
+		current[entry.SHA256] = setup
+		setups = append(setups, setup)
+	}
+
+	//keep the setup messages of the manifest, including those skipped by this call.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range manifest.Setups {
+		if setup, ok := l.setups[entry.SHA256]; ok && current[entry.SHA256] == nil {
+			current[entry.SHA256] = setup
+		}
+	}
+	l.setups = current
+	return setups, nil
+}
+
+//fetch downloads the setup file of entry and checks it against the manifest.
+func (l *Loader) fetch(ctx context.Context, entry *SetupJSON) (*message.SetupMessage, error) {
+	if entry.Size <= 0 || int64(entry.Size) > l.MaxSetupBytes {
+		return nil, fmt.Errorf("invalid size of setup file %v", entry.File)
+	}
+	name := strings.TrimPrefix(entry.File, "/")
+	if strings.Contains(name, "..") || strings.Contains(name, "://") {
+		return nil, fmt.Errorf("invalid setup file name %v", entry.File)
+	}
+	data, err := l.get(ctx, name, int64(entry.Size))
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	if len(data) != entry.Size || hex.EncodeToString(digest[:]) != strings.ToLower(entry.SHA256) {
+		return nil, fmt.Errorf("setup file %v does not match the manifest", entry.File)
+	}
+
+	setup := &message.SetupMessage{}
+	if err := setup.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if err := setup.Verify(); err != nil {
+		return nil, err
+	}
+	if setup.ID.String() != entry.ID || setup.KeyID.String() != entry.KeyID || !setup.Start.Equal(entry.Start) || !setup.End.Equal(entry.End) {
+		return nil, fmt.Errorf("setup message %v does not match the manifest", entry.File)
+	}
+	if l.Verifier != nil {
+		if err := l.Verifier.Verify(setup); err != nil {
+			return nil, err
+		}
+	}
+	return setup, nil
+}
@@ -0,0 +1,171 @@
+package static
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/message"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//helperServe serves dir and counts the downloaded setup files.
+func helperServe(t *testing.T, dir string) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	downloads := 0
+	files := http.FileServer(http.Dir(dir))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/cdn/setup/") {
+			mu.Lock()
+			downloads++
+			mu.Unlock()
+		}
+		http.StripPrefix("/cdn", files).ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return downloads
+	}
+}
+
+func TestLoader(t *testing.T) {
+	tcnServer, shards, signingKey, tcns := helperShards(t)
+	exporter, dir := helperExporter(t, signingKey)
+	manifest, err := exporter.ExportShards(shards)
+	if err != nil {
+		t.Fatalf("ExportShards failed %v", err)
+	}
+	srv, downloads := helperServe(t, dir)
+	public := signingKey.Public().(ed25519.PublicKey)
+	loader, err := NewLoader(srv.URL+"/cdn/", srv.Client(), public)
+	if err != nil {
+		t.Fatalf("NewLoader failed %v", err)
+	}
+	loader.Verifier, err = client.NewSetupVerifier(0, public)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx := context.Background()
+	setups, err := loader.Load(ctx, time.Time{})
+	if err != nil {
+		t.Fatalf("Load failed %v", err)
+	}
+	if len(setups) != 3 || downloads() != 3 {
+		t.Fatalf("unexpected setups %v %v", len(setups), downloads())
+	}
+	for idx, setup := range setups {
+		if setup.ID.String() != manifest.Setups[idx].ID {
+			t.Errorf("unexpected setup message %v", idx)
+		}
+	}
+
+	//the loaded setup messages run a PSI round.
+	tcnClient, err := client.Create()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI client %v", err)
+	}
+	defer tcnClient.Close()
+	queries := []client.ShardQuery{}
+	for _, setup := range setups {
+		queries = append(queries, client.ShardQuery{Setup: setup, Contacts: tcns[:15]})
+	}
+	total, err := client.QueryShards(tcnClient, queries, client.NoPadding, tcnServer.ProcessRequest)
+	if err != nil {
+		t.Fatalf("QueryShards failed %v", err)
+	}
+	if total != 15 {
+		t.Errorf("Invalid intersection %v", total)
+	}
+
+	//only the new or needed setup files are downloaded.
+	if _, err := loader.Load(ctx, time.Time{}); err != nil || downloads() != 3 {
+		t.Errorf("the loaded setup files should not be downloaded again %v %v", downloads(), err)
+	}
+	reports, _ := reporttest.Reports(t, 1)
+	if err := shards.Add(reports...); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := exporter.ExportShards(shards); err != nil {
+		t.Fatalf("ExportShards failed %v", err)
+	}
+	today := time.Now().UTC().Truncate(day)
+	setups, err = loader.Load(ctx, today)
+	if err != nil || len(setups) != 1 || setups[0].Reports != 2 {
+		t.Fatalf("unexpected setups %v %v", len(setups), err)
+	}
+	if downloads() != 4 {
+		t.Errorf("only the changed setup file should be downloaded %v", downloads())
+	}
+
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	untrusted, err := NewLoader(srv.URL+"/cdn", srv.Client(), other)
+	if err != nil {
+		t.Fatalf("NewLoader failed %v", err)
+	}
+	if _, err := untrusted.Load(ctx, time.Time{}); err != message.ErrInvalidSignature {
+		t.Errorf("a manifest signed with another key should be rejected %v", err)
+	}
+	stale, err := NewLoader(srv.URL+"/cdn", srv.Client(), public)
+	if err != nil {
+		t.Fatalf("NewLoader failed %v", err)
+	}
+	stale.MaxAge = time.Hour
+	stale.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := stale.Manifest(ctx); err == nil {
+		t.Errorf("a stale manifest should be rejected")
+	}
+
+	for _, args := range []string{"", "ftp://cdn.example.org", "/cdn"} {
+		if _, err := NewLoader(args, nil, public); err == nil {
+			t.Errorf("the URL %q should be rejected", args)
+		}
+	}
+	if _, err := NewLoader(srv.URL, nil); err == nil {
+		t.Errorf("a loader without keys should be rejected")
+	}
+}
+
+func TestLoaderTampering(t *testing.T) {
+	_, shards, signingKey, _ := helperShards(t)
+	exporter, dir := helperExporter(t, signingKey)
+	manifest, err := exporter.ExportShards(shards)
+	if err != nil {
+		t.Fatalf("ExportShards failed %v", err)
+	}
+	srv, _ := helperServe(t, dir)
+	loader, err := NewLoader(srv.URL+"/cdn", srv.Client(), signingKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatalf("NewLoader failed %v", err)
+	}
+
+	path := filepath.Join(dir, filepath.FromSlash(manifest.Setups[1].File))
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data[len(data)/2]++
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := loader.Load(context.Background(), time.Time{}); err == nil || !strings.Contains(err.Error(), "does not match the manifest") {
+		t.Errorf("a modified setup file should be rejected %v", err)
+	}
+	//the modified file is not needed for the recent contacts.
+	setups, err := loader.Load(context.Background(), manifest.Setups[2].Start)
+	if err != nil || len(setups) != 1 {
+		t.Errorf("the setup files which are not needed should not be downloaded %v", err)
+	}
+}
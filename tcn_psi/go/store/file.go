@@ -0,0 +1,303 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/openmined/tcn-psi/tcn"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//Operations of the file log.
+const (
+	opPut    = 1
+	opDelete = 2
+)
+
+//FileStore keeps reports in a single append-only file, and an index of the reports in memory.
+//Every change is synced to disk before it is acknowledged. A record partially written by a
+//crash is discarded when the file is opened again. Deleted records stay in the file until it
+//is compacted.
+//
+//A FileStore is safe for concurrent use by multiple goroutines.
+type FileStore struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	records map[ReportID]*Record
+	//garbage is the number of log entries which Compact would drop.
+	garbage int
+}
+
+//OpenFile opens the store kept in path, creating the file if needed.
+//
+//Returns an error if the file cannot be opened or is corrupted.
+func OpenFile(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		path:    path,
+		file:    file,
+		records: map[ReportID]*Record{},
+	}
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//load replays the log and truncates a trailing partial entry.
+func (s *FileStore) load() error {
+	reader := bufio.NewReader(s.file)
+	offset := int64(0)
+	for {
+		length, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return s.truncate(offset)
+		}
+		entry := make([]byte, length)
+		if _, err := io.ReadFull(reader, entry); err != nil {
+			return s.truncate(offset)
+		}
+		if err := s.apply(entry); err != nil {
+			return err
+		}
+		offset += int64(uvarintSize(length)) + int64(length)
+	}
+	_, err := s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (s *FileStore) truncate(offset int64) error {
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func uvarintSize(value uint64) int {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(buf, value)
+}
+
+//apply replays a single log entry.
+func (s *FileStore) apply(entry []byte) error {
+	if len(entry) == 0 {
+		return errors.New("corrupted store entry")
+	}
+	switch entry[0] {
+	case opPut:
+		if len(entry) < 9 {
+			return errors.New("corrupted store entry")
+		}
+		ingestedAt := time.Unix(0, int64(binary.LittleEndian.Uint64(entry[1:9]))).UTC()
+		report, err := tcn.GetSignedReport(entry[9:])
+		if err != nil {
+			return err
+		}
+		record, err := NewRecord(report, ingestedAt)
+		if err != nil {
+			return err
+		}
+		if _, ok := s.records[record.ID]; ok {
+			s.garbage++
+		}
+		s.records[record.ID] = record
+	case opDelete:
+		var id ReportID
+		if len(entry) != 1+len(id) {
+			return errors.New("corrupted store entry")
+		}
+		copy(id[:], entry[1:])
+		delete(s.records, id)
+		//the deletion and the record it removes.
+		s.garbage += 2
+	default:
+		return errors.New("unknown store entry")
+	}
+	return nil
+}
+
+//putEntry returns the log entry storing record.
+func putEntry(record *Record) ([]byte, error) {
+	data, err := record.Report.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	entry := make([]byte, 9, 9+len(data))
+	entry[0] = opPut
+	binary.LittleEndian.PutUint64(entry[1:9], uint64(unixNano(record.IngestedAt)))
+	return append(entry, data...), nil
+}
+
+//writeEntry writes a length-prefixed entry to w.
+func writeEntry(w io.Writer, entry []byte) error {
+	length := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(length, uint64(len(entry)))
+	_, err := w.Write(append(length[:n], entry...))
+	return err
+}
+
+//append writes entry to the log and syncs it.
+func (s *FileStore) append(entry []byte) error {
+	if s.file == nil {
+		return errors.New("store closed")
+	}
+	if err := writeEntry(s.file, entry); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+//Put stores record.
+func (s *FileStore) Put(record *Record) error {
+	entry, err := putEntry(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(entry); err != nil {
+		return err
+	}
+	if _, ok := s.records[record.ID]; ok {
+		s.garbage++
+	}
+	stored := *record
+	stored.IngestedAt = record.IngestedAt.UTC()
+	s.records[record.ID] = &stored
+	return nil
+}
+
+//Get returns the record identified by id, or ErrNotFound.
+func (s *FileStore) Get(id ReportID) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+//List returns the records ingested in [from, to), oldest first.
+func (s *FileStore) List(from, to time.Time) ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return between(s.records, from, to), nil
+}
+
+//Iterate calls fn for every record ingested in [from, to), oldest first. The records are those
+//stored when Iterate is called, and fn may modify the store. The records are held in memory, so
+//Iterate only lists the matching ones.
+func (s *FileStore) Iterate(from, to time.Time, fn func(record *Record) error) error {
+	records, err := s.List(from, to)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Delete removes the record identified by id, or returns ErrNotFound.
+func (s *FileStore) Delete(id ReportID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[id]; !ok {
+		return ErrNotFound
+	}
+	if err := s.append(append([]byte{opDelete}, id[:]...)); err != nil {
+		return err
+	}
+	delete(s.records, id)
+	s.garbage += 2
+	return nil
+}
+
+//Garbage returns the number of log entries which Compact would drop.
+func (s *FileStore) Garbage() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.garbage
+}
+
+//Compact rewrites the file with the stored records only, dropping the deleted and replaced
+//ones. The new file replaces the old one atomically.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errors.New("store closed")
+	}
+
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := s.writeRecords(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	s.file.Close()
+	s.file = tmp
+	s.garbage = 0
+	return nil
+}
+
+//writeRecords writes the stored records to file, syncs it and leaves it positioned at its end.
+func (s *FileStore) writeRecords(file *os.File) error {
+	records := make([]*Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sortRecords(records)
+
+	writer := bufio.NewWriter(file)
+	for _, record := range records {
+		entry, err := putEntry(record)
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(writer, entry); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+//Close closes the file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
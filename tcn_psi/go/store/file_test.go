@@ -0,0 +1,191 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func helperOpenFile(t *testing.T) (*FileStore, string) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "reports.log")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed %v", err)
+	}
+	return s, path
+}
+
+func TestFileStore(t *testing.T) {
+	s, _ := helperOpenFile(t)
+	defer s.Close()
+	testReportStore(t, s)
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	s, path := helperOpenFile(t)
+	base := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	records := []*Record{}
+	for idx := 0; idx < 3; idx++ {
+		record, err := NewRecord(helperGetReport(t), base.Add(time.Duration(idx)*time.Hour))
+		if err != nil {
+			t.Fatalf("NewRecord failed %v", err)
+		}
+		if err := s.Put(record); err != nil {
+			t.Fatalf("Put failed %v", err)
+		}
+		records = append(records, record)
+	}
+	if err := s.Delete(records[1].ID); err != nil {
+		t.Fatalf("Delete failed %v", err)
+	}
+	records = append(records[:1], records[2:]...)
+	if err := s.Close(); err != nil {
+		t.Errorf("Close failed %v", err)
+	}
+	if err := s.Put(records[0]); err == nil {
+		t.Errorf("Put on a closed store should fail")
+	}
+
+	reopened, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed %v", err)
+	}
+	defer reopened.Close()
+	listed, err := reopened.List(base, base.Add(24*time.Hour))
+	if err != nil || len(listed) != len(records) {
+		t.Fatalf("the reopened store lost records %v %v", listed, err)
+	}
+	for idx, record := range listed {
+		if record.ID != records[idx].ID || !record.IngestedAt.Equal(records[idx].IngestedAt) {
+			t.Errorf("invalid record %v", idx)
+		}
+		got, _ := record.Report.Bytes()
+		want, _ := records[idx].Report.Bytes()
+		if !bytes.Equal(got, want) {
+			t.Errorf("the report %v was not restored", idx)
+		}
+	}
+	if reopened.Garbage() != 2 {
+		t.Errorf("the deleted record should be garbage, got %v", reopened.Garbage())
+	}
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	s, path := helperOpenFile(t)
+	now := time.Now()
+	ids := []ReportID{}
+	for idx := 0; idx < 4; idx++ {
+		record, err := NewRecord(helperGetReport(t), now)
+		if err != nil {
+			t.Fatalf("NewRecord failed %v", err)
+		}
+		if err := s.Put(record); err != nil {
+			t.Fatalf("Put failed %v", err)
+		}
+		ids = append(ids, record.ID)
+	}
+	before, _ := os.Stat(path)
+	for _, id := range ids[:2] {
+		if err := s.Delete(id); err != nil {
+			t.Fatalf("Delete failed %v", err)
+		}
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact failed %v", err)
+	}
+	if s.Garbage() != 0 {
+		t.Errorf("Compact should drop the garbage")
+	}
+	after, _ := os.Stat(path)
+	if after.Size() != before.Size()/2 {
+		t.Errorf("the compacted file should hold half of the records: %v != %v", after.Size(), before.Size()/2)
+	}
+
+	record, err := NewRecord(helperGetReport(t), now)
+	if err != nil {
+		t.Fatalf("NewRecord failed %v", err)
+	}
+	if err := s.Put(record); err != nil {
+		t.Fatalf("Put after Compact failed %v", err)
+	}
+	s.Close()
+
+	reopened, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed %v", err)
+	}
+	defer reopened.Close()
+	for _, id := range append(ids[2:], record.ID) {
+		if _, err := reopened.Get(id); err != nil {
+			t.Errorf("the compacted store lost a record %v", err)
+		}
+	}
+	for _, id := range ids[:2] {
+		if _, err := reopened.Get(id); err != ErrNotFound {
+			t.Errorf("the compacted store restored a deleted record %v", err)
+		}
+	}
+}
+
+func TestFileStoreTruncatedTail(t *testing.T) {
+	s, path := helperOpenFile(t)
+	record, err := NewRecord(helperGetReport(t), time.Now())
+	if err != nil {
+		t.Fatalf("NewRecord failed %v", err)
+	}
+	if err := s.Put(record); err != nil {
+		t.Fatalf("Put failed %v", err)
+	}
+	s.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// a partial entry, as left by a crash during a write.
+	file.Write([]byte{200, 1, opPut, 0, 0})
+	file.Close()
+
+	reopened, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile should discard a partial entry %v", err)
+	}
+	if _, err := reopened.Get(record.ID); err != nil {
+		t.Errorf("the complete record was lost %v", err)
+	}
+	if err := reopened.Put(record); err != nil {
+		t.Errorf("Put failed %v", err)
+	}
+	reopened.Close()
+
+	truncated, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if truncated.Size() != 2*info.Size() {
+		t.Errorf("the partial entry was not truncated: %v != %v", truncated.Size(), 2*info.Size())
+	}
+}
+
+func TestFileStoreCorrupted(t *testing.T) {
+	_, path := helperOpenFile(t)
+	if err := ioutil.WriteFile(path, []byte{10, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0}, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := OpenFile(path); err == nil {
+		t.Errorf("OpenFile of a corrupted store should fail")
+	}
+}
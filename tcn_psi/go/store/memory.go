@@ -0,0 +1,104 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//MemoryStore keeps reports in memory. It is meant for tests and for deployments which rebuild
+//their dataset on start.
+//
+//A MemoryStore is safe for concurrent use by multiple goroutines.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[ReportID]*Record
+}
+
+//NewMemory returns an empty MemoryStore.
+func NewMemory() *MemoryStore {
+	return &MemoryStore{records: map[ReportID]*Record{}}
+}
+
+//Put stores record.
+func (s *MemoryStore) Put(record *Record) error {
+	stored := *record
+	stored.IngestedAt = record.IngestedAt.UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.ID] = &stored
+	return nil
+}
+
+//Get returns the record identified by id, or ErrNotFound.
+func (s *MemoryStore) Get(id ReportID) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+//List returns the records ingested in [from, to), oldest first.
+func (s *MemoryStore) List(from, to time.Time) ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return between(s.records, from, to), nil
+}
+
+//Iterate calls fn for every record ingested in [from, to), oldest first. The records are those
+//stored when Iterate is called, and fn may modify the store. The records are held in memory, so
+//Iterate only lists the matching ones.
+func (s *MemoryStore) Iterate(from, to time.Time, fn func(record *Record) error) error {
+	records, err := s.List(from, to)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Delete removes the record identified by id, or returns ErrNotFound.
+func (s *MemoryStore) Delete(id ReportID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.records, id)
+	return nil
+}
+
+//Close does nothing.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+//between returns the records of an index ingested in [from, to), oldest first.
+func between(records map[ReportID]*Record, from, to time.Time) []*Record {
+	result := []*Record{}
+	for _, record := range records {
+		if !record.IngestedAt.Before(from) && record.IngestedAt.Before(to) {
+			result = append(result, record)
+		}
+	}
+	sortRecords(result)
+	return result
+}
+
+//sortRecords sorts records by ingestion time, then by ID.
+func sortRecords(records []*Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].IngestedAt.Equal(records[j].IngestedAt) {
+			return string(records[i].ID[:]) < string(records[j].ID[:])
+		}
+		return records[i].IngestedAt.Before(records[j].IngestedAt)
+	})
+}
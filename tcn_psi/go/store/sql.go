@@ -0,0 +1,160 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/tcn"
+	"strings"
+	"time"
+)
+
+//SQLDialect selects the SQL syntax of a database.
+type SQLDialect int
+
+const (
+	//SQLite uses ? placeholders and BLOB columns.
+	SQLite SQLDialect = iota
+	//Postgres uses $1, $2, ... placeholders and BYTEA columns.
+	Postgres
+)
+
+//sqlTable is the table holding the reports.
+const sqlTable = "tcn_reports"
+
+//SQLStore keeps reports in a database reached through database/sql. The caller registers the
+//driver and owns the connection pool.
+//
+//A SQLStore is safe for concurrent use by multiple goroutines.
+type SQLStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+}
+
+//OpenSQL returns a store backed by db, creating its table if needed.
+//
+//Returns an error if the table cannot be created.
+func OpenSQL(db *sql.DB, dialect SQLDialect) (*SQLStore, error) {
+	if db == nil {
+		return nil, errors.New("invalid database")
+	}
+	s := &SQLStore{db: db, dialect: dialect}
+	blob := "BLOB"
+	if dialect == Postgres {
+		blob = "BYTEA"
+	}
+	schema := []string{
+		"CREATE TABLE IF NOT EXISTS " + sqlTable + " (id " + blob + " PRIMARY KEY, ingested_at BIGINT NOT NULL, report " + blob + " NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS " + sqlTable + "_ingested_at ON " + sqlTable + " (ingested_at)",
+	}
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+//query rewrites the ? placeholders of query for the dialect of the store.
+func (s *SQLStore) query(query string) string {
+	if s.dialect != Postgres {
+		return query
+	}
+	var builder strings.Builder
+	arg := 0
+	for _, c := range query {
+		if c == '?' {
+			arg++
+			fmt.Fprintf(&builder, "$%d", arg)
+			continue
+		}
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
+//Put stores record.
+func (s *SQLStore) Put(record *Record) error {
+	data, err := record.Report.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.query("INSERT INTO "+sqlTable+" (id, ingested_at, report) VALUES (?, ?, ?) "+
+		"ON CONFLICT (id) DO UPDATE SET ingested_at = excluded.ingested_at, report = excluded.report"),
+		record.ID[:], unixNano(record.IngestedAt), data)
+	return err
+}
+
+//scanRecord reads a record from a row holding its ingestion time and report.
+func scanRecord(scan func(dest ...interface{}) error) (*Record, error) {
+	var ingestedAt int64
+	var data []byte
+	if err := scan(&ingestedAt, &data); err != nil {
+		return nil, err
+	}
+	report, err := tcn.GetSignedReport(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecord(report, time.Unix(0, ingestedAt).UTC())
+}
+
+//Get returns the record identified by id, or ErrNotFound.
+func (s *SQLStore) Get(id ReportID) (*Record, error) {
+	row := s.db.QueryRow(s.query("SELECT ingested_at, report FROM "+sqlTable+" WHERE id = ?"), id[:])
+	record, err := scanRecord(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return record, err
+}
+
+//List returns the records ingested in [from, to), oldest first.
+func (s *SQLStore) List(from, to time.Time) ([]*Record, error) {
+	return collect(func(fn func(record *Record) error) error {
+		return s.Iterate(from, to, fn)
+	})
+}
+
+//Iterate calls fn for every record ingested in [from, to), oldest first, reading them from the
+//database as fn consumes them. fn must not use the store if the connection pool is limited to
+//a single connection.
+func (s *SQLStore) Iterate(from, to time.Time, fn func(record *Record) error) error {
+	rows, err := s.db.Query(s.query("SELECT ingested_at, report FROM "+sqlTable+
+		" WHERE ingested_at >= ? AND ingested_at < ? ORDER BY ingested_at, id"), unixNano(from), unixNano(to))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		record, err := scanRecord(rows.Scan)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+//Delete removes the record identified by id, or returns ErrNotFound.
+func (s *SQLStore) Delete(id ReportID) error {
+	result, err := s.db.Exec(s.query("DELETE FROM "+sqlTable+" WHERE id = ?"), id[:])
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//Close does nothing: the database belongs to the caller.
+func (s *SQLStore) Close() error {
+	return nil
+}
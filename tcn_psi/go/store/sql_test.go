@@ -0,0 +1,41 @@
+package store
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+)
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open the database %v", err)
+	}
+	defer db.Close()
+	//every connection to :memory: opens a distinct database.
+	db.SetMaxOpenConns(1)
+
+	s, err := OpenSQL(db, SQLite)
+	if err != nil {
+		t.Fatalf("OpenSQL failed %v", err)
+	}
+	testReportStore(t, s)
+
+	if _, err := OpenSQL(db, SQLite); err != nil {
+		t.Errorf("OpenSQL on an existing table failed %v", err)
+	}
+	if _, err := OpenSQL(nil, SQLite); err == nil {
+		t.Errorf("OpenSQL without a database should fail")
+	}
+}
+
+func TestSQLDialect(t *testing.T) {
+	s := &SQLStore{dialect: Postgres}
+	if query := s.query("SELECT a FROM b WHERE c = ? AND d < ?"); query != "SELECT a FROM b WHERE c = $1 AND d < $2" {
+		t.Errorf("invalid Postgres query %v", query)
+	}
+	s.dialect = SQLite
+	if query := s.query("SELECT a FROM b WHERE c = ?"); query != "SELECT a FROM b WHERE c = ?" {
+		t.Errorf("invalid SQLite query %v", query)
+	}
+}
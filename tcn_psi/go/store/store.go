@@ -0,0 +1,119 @@
+//Package store persists the signed reports accepted by the server until they are published in
+//setup messages.
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/openmined/tcn-psi/tcn"
+	"math"
+	"time"
+)
+
+//ErrNotFound is returned when a report is not in the store.
+var ErrNotFound = errors.New("report not found")
+
+//ReportID identifies a report. It is the SHA-256 digest of the report without its signature,
+//so the same report signed twice has the same identity.
+type ReportID [sha256.Size]byte
+
+//ComputeReportID returns the identity of report.
+func ComputeReportID(report *tcn.SignedReport) (ReportID, error) {
+	if report == nil || report.Report == nil {
+		return ReportID{}, errors.New("invalid report")
+	}
+	data, err := report.Report.Bytes()
+	if err != nil {
+		return ReportID{}, err
+	}
+	return ReportID(sha256.Sum256(data)), nil
+}
+
+//String returns the hexadecimal representation of the identifier.
+func (id ReportID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+//ParseReportID parses the hexadecimal representation of an identifier.
+func ParseReportID(s string) (ReportID, error) {
+	var id ReportID
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return id, err
+	}
+	if len(raw) != len(id) {
+		return id, errors.New("invalid report ID length")
+	}
+	copy(id[:], raw)
+	return id, nil
+}
+
+//Record is a report held by a store.
+type Record struct {
+	ID         ReportID
+	Report     *tcn.SignedReport
+	IngestedAt time.Time
+}
+
+//NewRecord returns the record of report ingested at t.
+func NewRecord(report *tcn.SignedReport, t time.Time) (*Record, error) {
+	id, err := ComputeReportID(report)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{ID: id, Report: report, IngestedAt: t}, nil
+}
+
+//ReportStore persists reports. The package provides a MemoryStore, a FileStore embedded in a
+//single file and a SQLStore over database/sql.
+type ReportStore interface {
+	//Put stores record. Storing a record whose ID is already stored replaces it.
+	Put(record *Record) error
+	//Get returns the record identified by id, or ErrNotFound.
+	Get(id ReportID) (*Record, error)
+	//List returns the records ingested in [from, to), oldest first.
+	List(from, to time.Time) ([]*Record, error)
+	//Iterate calls fn for every record ingested in [from, to), oldest first. It stops at the
+	//first error returned by fn, which it returns. A SQLStore streams the records from the
+	//database; the other stores already hold all of their records in memory.
+	Iterate(from, to time.Time, fn func(record *Record) error) error
+	//Delete removes the record identified by id, or returns ErrNotFound.
+	Delete(id ReportID) error
+	//Close releases the resources held by the store.
+	Close() error
+}
+
+//Reports returns an iterator over the reports of s ingested in [from, to), which can be passed
+//to server.PlanSetupFrom or TCNServer.CreateSetupMessageFrom.
+func Reports(s ReportStore, from, to time.Time) func(yield func(report *tcn.SignedReport) error) error {
+	return func(yield func(report *tcn.SignedReport) error) error {
+		return s.Iterate(from, to, func(record *Record) error {
+			return yield(record.Report)
+		})
+	}
+}
+
+//collect lists the records of an iteration.
+func collect(iterate func(fn func(record *Record) error) error) ([]*Record, error) {
+	records := []*Record{}
+	err := iterate(func(record *Record) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+//unixNano returns t in nanoseconds since the epoch, clamped to the range of int64.
+func unixNano(t time.Time) int64 {
+	if t.Before(time.Unix(0, math.MinInt64)) {
+		return math.MinInt64
+	}
+	if t.After(time.Unix(0, math.MaxInt64)) {
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"errors"
+	"github.com/openmined/tcn-psi/server"
+	"github.com/openmined/tcn-psi/tcn"
+	"testing"
+	"time"
+)
+
+func helperGetReport(t *testing.T) *tcn.SignedReport {
+	rak, err := tcn.NewReportAuthorizationKey()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	report, err := rak.CreateSignedReport(tcn.CoEpiV1Code, []byte("symptoms"), 1, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return report
+}
+
+func TestComputeReportID(t *testing.T) {
+	report := helperGetReport(t)
+	id, err := ComputeReportID(report)
+	if err != nil {
+		t.Fatalf("ComputeReportID failed %v", err)
+	}
+	resigned := *report
+	resigned.Sig = make([]byte, len(report.Sig))
+	other, err := ComputeReportID(&resigned)
+	if err != nil {
+		t.Fatalf("ComputeReportID failed %v", err)
+	}
+	if id != other {
+		t.Errorf("the report ID should not depend on the signature")
+	}
+	if other, _ := ComputeReportID(helperGetReport(t)); id == other {
+		t.Errorf("distinct reports should have distinct IDs")
+	}
+	if len(id.String()) != 64 {
+		t.Errorf("invalid ID string %v", id.String())
+	}
+	if parsed, err := ParseReportID(id.String()); err != nil || parsed != id {
+		t.Errorf("ParseReportID failed %v", err)
+	}
+	if _, err := ParseReportID("abcd"); err == nil {
+		t.Errorf("ParseReportID should reject a short ID")
+	}
+	if _, err := ComputeReportID(nil); err == nil {
+		t.Errorf("ComputeReportID without a report should fail")
+	}
+}
+
+//testReportStore checks the behaviour shared by every ReportStore.
+func testReportStore(t *testing.T, s ReportStore) {
+	base := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	records := []*Record{}
+	for idx := 0; idx < 4; idx++ {
+		record, err := NewRecord(helperGetReport(t), base.Add(time.Duration(idx)*time.Hour))
+		if err != nil {
+			t.Fatalf("NewRecord failed %v", err)
+		}
+		if err := s.Put(record); err != nil {
+			t.Fatalf("Put failed %v", err)
+		}
+		records = append(records, record)
+	}
+
+	got, err := s.Get(records[1].ID)
+	if err != nil || got.ID != records[1].ID || !got.IngestedAt.Equal(records[1].IngestedAt) {
+		t.Errorf("Get failed %v", err)
+	}
+	if _, err := s.Get(ReportID{}); err != ErrNotFound {
+		t.Errorf("Get of a missing report should fail with ErrNotFound, got %v", err)
+	}
+
+	listed, err := s.List(base.Add(time.Hour), base.Add(3*time.Hour))
+	if err != nil || len(listed) != 2 || listed[0].ID != records[1].ID || listed[1].ID != records[2].ID {
+		t.Errorf("List returned unexpected records %v %v", listed, err)
+	}
+
+	visited := []ReportID{}
+	err = s.Iterate(time.Time{}, base.Add(24*time.Hour), func(record *Record) error {
+		visited = append(visited, record.ID)
+		return nil
+	})
+	if err != nil || len(visited) != len(records) {
+		t.Fatalf("Iterate visited %v records %v", len(visited), err)
+	}
+	for idx := range records {
+		if visited[idx] != records[idx].ID {
+			t.Errorf("Iterate should visit the oldest records first")
+		}
+	}
+	stop := errors.New("stop")
+	visited = visited[:0]
+	err = s.Iterate(base, base.Add(24*time.Hour), func(record *Record) error {
+		visited = append(visited, record.ID)
+		return stop
+	})
+	if err != stop || len(visited) != 1 {
+		t.Errorf("Iterate should stop at the first error, visited %v %v", len(visited), err)
+	}
+
+	if err := s.Delete(records[0].ID); err != nil {
+		t.Errorf("Delete failed %v", err)
+	}
+	if err := s.Delete(records[0].ID); err != ErrNotFound {
+		t.Errorf("Delete of a missing report should fail with ErrNotFound, got %v", err)
+	}
+	if _, err := s.Get(records[0].ID); err != ErrNotFound {
+		t.Errorf("Get of a deleted report should fail with ErrNotFound, got %v", err)
+	}
+
+	moved := *records[1]
+	moved.IngestedAt = base.Add(10 * time.Hour)
+	if err := s.Put(&moved); err != nil {
+		t.Errorf("Put of a stored report failed %v", err)
+	}
+	listed, err = s.List(base, base.Add(24*time.Hour))
+	if err != nil || len(listed) != 3 || listed[2].ID != records[1].ID {
+		t.Errorf("Put should replace a stored report %v %v", listed, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testReportStore(t, NewMemory())
+}
+
+func TestReportsIterator(t *testing.T) {
+	builder, err := server.CreateWithNewKey()
+	if err != nil {
+		t.Fatalf("Failed to create a PSI server %v", err)
+	}
+	defer builder.Close()
+
+	s := NewMemory()
+	now := time.Now()
+	for idx := 0; idx < 3; idx++ {
+		record, err := NewRecord(helperGetReport(t), now)
+		if err != nil {
+			t.Fatalf("NewRecord failed %v", err)
+		}
+		s.Put(record)
+	}
+	plan, err := server.PlanSetupFrom(Reports(s, now, now.Add(time.Second)), nil, server.SizeTarget{FPR: 0.001})
+	if err != nil {
+		t.Fatalf("PlanSetupFrom failed %v", err)
+	}
+	if plan.Summary.Accepted != 3 || plan.Elements != 30 {
+		t.Errorf("invalid plan %v %v", plan.Summary.Accepted, plan.Elements)
+	}
+	if _, err := builder.CreateSetupMessageFrom(0.001, 100, Reports(s, now, now.Add(time.Second))); err != nil {
+		t.Errorf("CreateSetupMessageFrom failed %v", err)
+	}
+}
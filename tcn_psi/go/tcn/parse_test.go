@@ -50,3 +50,24 @@ func TestGetSignedReport(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, signedReport, retSignedReport)
 }
+
+func TestGetReportTruncatedMemo(t *testing.T) {
+	rak, err := tcn.NewReportAuthorizationKey()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	report, err := rak.CreateReport(tcn.CoEpiV1Code, []byte("symptom data"), 0, 1)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	rb, err := report.Bytes()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	_, _, err = tcn.GetReport(rb[:len(rb)-1])
+	assert.Error(t, err)
+}
@@ -15,6 +15,9 @@ func GetReport(data []byte) (*Report, uint16, error) {
 	copy(tckBytes[:], data[32:64])
 
 	memoDataLen := uint8(data[69])
+	if len(data) < ReportMinLength+int(memoDataLen) {
+		return nil, 0, errors.New("Data too short for the report memo")
+	}
 
 	return &Report{
 		RVK:      ed25519.PublicKey(data[:32]),
@@ -0,0 +1,165 @@
+package tenant
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/openmined/tcn-psi/rest"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+//maxAdminBytes bounds the body of an admin request.
+const maxAdminBytes = 64 << 10
+
+//TenantJSON describes a tenant in the admin API.
+type TenantJSON struct {
+	ID       string    `json:"id"`
+	Settings *Settings `json:"settings,omitempty"`
+	KeyID    string    `json:"key_id,omitempty"`
+	//SigningKey is the hexadecimal public key authenticating the setup messages.
+	SigningKey string `json:"signing_key,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func infoJSON(info Info) TenantJSON {
+	if info.Err != nil {
+		return TenantJSON{ID: info.ID, Error: info.Err.Error()}
+	}
+	settings := info.Settings
+	return TenantJSON{ID: info.ID, Settings: &settings, KeyID: info.KeyID.String(), SigningKey: hex.EncodeToString(info.SigningKey)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &rest.ErrorJSON{Error: err.Error()})
+}
+
+//Handler routes the requests for /t/{id}/... to the REST API of the tenant id, e.g.
+//GET /t/{id}/v1/setup.
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/t/")
+		if path == r.URL.Path {
+			writeError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		idx := strings.IndexByte(path, '/')
+		if idx < 0 {
+			writeError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		t, err := m.Get(path[:idx])
+		if err == ErrNotFound {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		//the tenant handler sees the path of the single-tenant API.
+		inner := r.Clone(r.Context())
+		inner.URL.Path = path[idx:]
+		inner.URL.RawPath = ""
+		t.ServeHTTP(w, inner)
+	})
+}
+
+//AdminHandler returns the handler of the admin API, which must only be exposed to operators:
+//
+//	GET    /admin/tenants       list the tenants
+//	POST   /admin/tenants       create a tenant from {"id": ..., "settings": {...}}, the missing
+//	                            settings taking their default value
+//	GET    /admin/tenants/{id}  describe a tenant
+//	DELETE /admin/tenants/{id}  retire a tenant
+func (m *Manager) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/tenants", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			tenants := []TenantJSON{}
+			for _, info := range m.List() {
+				tenants = append(tenants, infoJSON(info))
+			}
+			writeJSON(w, http.StatusOK, tenants)
+		case http.MethodPost:
+			m.serveCreate(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+	mux.HandleFunc("/admin/tenants/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/admin/tenants/")
+		switch r.Method {
+		case http.MethodGet:
+			for _, info := range m.List() {
+				if info.ID == id {
+					writeJSON(w, http.StatusOK, infoJSON(info))
+					return
+				}
+			}
+			writeError(w, http.StatusNotFound, ErrNotFound)
+		case http.MethodDelete:
+			err := m.Retire(id)
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+	return mux
+}
+
+//serveCreate serves POST /admin/tenants.
+func (m *Manager) serveCreate(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAdminBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+		return
+	}
+	settings := DefaultSettings()
+	body := struct {
+		ID       string    `json:"id"`
+		Settings *Settings `json:"settings"`
+	}{Settings: &settings}
+	if err := json.Unmarshal(data, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Settings == nil {
+		body.Settings = &settings
+	}
+	if err := body.Settings.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	t, err := m.Create(body.ID, *body.Settings)
+	switch {
+	case err == ErrInvalidID:
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err == ErrExists:
+		writeError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, infoJSON(Info{ID: t.ID(), Settings: t.Settings(), KeyID: t.KeyID(), SigningKey: t.SigningKey()}))
+}
@@ -0,0 +1,305 @@
+package tenant
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/keystore"
+	"github.com/openmined/tcn-psi/message"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//retiredDir is the directory, under the root of a Manager, receiving the data of the retired
+//tenants.
+const retiredDir = ".retired"
+
+var (
+	//ErrNotFound is returned for an unknown tenant.
+	ErrNotFound = errors.New("tenant not found")
+	//ErrExists is returned when creating a tenant which already exists.
+	ErrExists = errors.New("tenant already exists")
+	//ErrInvalidID is returned for a tenant ID rejected by ValidID.
+	ErrInvalidID = errors.New("invalid tenant ID")
+)
+
+//Info describes a tenant.
+type Info struct {
+	ID       string
+	Settings Settings
+	KeyID    message.KeyID
+	//SigningKey authenticates the setup messages of the tenant, nil if they are not signed.
+	SigningKey ed25519.PublicKey
+	//Err is the reason why the tenant could not be started, nil if it runs.
+	Err error
+}
+
+//Manager runs the tenants stored under a directory, each in a subdirectory named after its ID.
+//
+//A Manager is safe for concurrent use by multiple goroutines.
+type Manager struct {
+	dir string
+	//passphrase encrypts the server keys of the tenants.
+	passphrase keystore.Passphrase
+
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+	//failed holds the tenants which could not be started, and why.
+	failed map[string]error
+	//pending holds the IDs of the tenants being created or retired, whose files are in use
+	//outside of mu.
+	pending map[string]bool
+	closed  bool
+}
+
+//Open starts the tenants stored in dir, creating the directory if needed. The server keys of the
+//tenants are stored encrypted with the passphrase supplied by passphrase. A tenant which cannot
+//be started, e.g. because its files are corrupted, is reported by List and does not prevent the
+//others from starting.
+//
+//Returns an error if passphrase is nil or if the directory cannot be read.
+func Open(dir string, passphrase keystore.Passphrase) (*Manager, error) {
+	if passphrase == nil {
+		return nil, errors.New("no passphrase source")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{dir: dir, passphrase: passphrase, tenants: map[string]*Tenant{}, failed: map[string]error{}, pending: map[string]bool{}}
+	for _, entry := range entries {
+		if !entry.IsDir() || !ValidID(entry.Name()) {
+			continue
+		}
+		id := entry.Name()
+		t, err := m.start(id)
+		if err != nil {
+			m.failed[id] = err
+			continue
+		}
+		m.tenants[id] = t
+	}
+	return m, nil
+}
+
+//start opens the stored tenant id.
+func (m *Manager) start(id string) (*Tenant, error) {
+	dir := filepath.Join(m.dir, id)
+	settings, err := readSettings(dir)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, err := loadSigningKey(dir)
+	if err != nil {
+		return nil, err
+	}
+	tcnServer, err := loadKey(dir, m.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return open(id, dir, settings, tcnServer, signingKey)
+}
+
+//Create creates the tenant id with a fresh server key and a fresh operator signing key. The
+//other tenants are served while the keys are generated and the files written.
+//
+//Returns an error if the ID is invalid or taken, if the settings are invalid or if the files of
+//the tenant cannot be created.
+func (m *Manager) Create(id string, settings Settings) (*Tenant, error) {
+	if !ValidID(id) {
+		return nil, ErrInvalidID
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	if err := m.reserve(id); err != nil {
+		return nil, err
+	}
+	t, err := m.create(id, settings)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, id)
+	if err == nil && m.closed {
+		t.close()
+		err = errors.New("manager closed")
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.tenants[id] = t
+	return t, nil
+}
+
+//reserve marks the new tenant id as pending.
+//
+//Returns ErrExists if the ID is taken.
+func (m *Manager) reserve(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errors.New("manager closed")
+	}
+	_, running := m.tenants[id]
+	_, failed := m.failed[id]
+	if running || failed || m.pending[id] {
+		return ErrExists
+	}
+	m.pending[id] = true
+	return nil
+}
+
+//create writes the files of the new tenant id and starts it. The files are removed if it
+//fails.
+func (m *Manager) create(id string, settings Settings) (*Tenant, error) {
+	dir := filepath.Join(m.dir, id)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		if os.IsExist(err) {
+			return nil, ErrExists
+		}
+		return nil, err
+	}
+	t, err := m.createIn(id, dir, settings)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return t, nil
+}
+
+func (m *Manager) createIn(id, dir string, settings Settings) (*Tenant, error) {
+	if err := writeSettings(dir, settings); err != nil {
+		return nil, err
+	}
+	signingKey, err := createSigningKey(dir)
+	if err != nil {
+		return nil, err
+	}
+	tcnServer, err := createKey(dir, m.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return open(id, dir, settings, tcnServer, signingKey)
+}
+
+//Get returns the running tenant id.
+//
+//Returns ErrNotFound if the tenant does not exist, or the reason why it could not be started.
+func (m *Manager) Get(id string) (*Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if t, ok := m.tenants[id]; ok {
+		return t, nil
+	}
+	if err, ok := m.failed[id]; ok {
+		return nil, fmt.Errorf("tenant %v failed to start: %v", id, err)
+	}
+	return nil, ErrNotFound
+}
+
+//List describes the tenants, running or failed, sorted by ID.
+func (m *Manager) List() []Info {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	infos := []Info{}
+	for id, t := range m.tenants {
+		infos = append(infos, Info{ID: id, Settings: t.Settings(), KeyID: t.KeyID(), SigningKey: t.SigningKey()})
+	}
+	for id, err := range m.failed {
+		infos = append(infos, Info{ID: id, Err: err})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+//Retire stops the tenant id and destroys its server and signing keys, so its setup messages can
+//no longer be answered nor forged. The rest of its files, including the reports, are moved to the
+//.retired directory for the operator to archive. The ID can then be reused by a new tenant. The
+//other tenants are served while the files are destroyed and moved.
+//
+//Returns ErrNotFound if the tenant does not exist.
+func (m *Manager) Retire(id string) error {
+	m.mu.Lock()
+	t, running := m.tenants[id]
+	_, failed := m.failed[id]
+	if !running && !failed {
+		m.mu.Unlock()
+		return ErrNotFound
+	}
+	delete(m.tenants, id)
+	delete(m.failed, id)
+	m.pending[id] = true
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.pending, id)
+	}()
+	if running {
+		t.close()
+	}
+	dir := filepath.Join(m.dir, id)
+	for _, name := range []string{keyFile, signingKeyFile} {
+		if err := shredKey(dir, name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(m.dir, retiredDir), 0700); err != nil {
+		return err
+	}
+	archive := fmt.Sprintf("%v-%v", id, time.Now().UnixNano())
+	return os.Rename(dir, filepath.Join(m.dir, retiredDir, archive))
+}
+
+//Maintain runs the maintenance of every tenant, see Tenant.Maintain. A failing tenant does not
+//prevent the maintenance of the others.
+//
+//Returns the errors of the failing tenants.
+func (m *Manager) Maintain() error {
+	m.mu.RLock()
+	tenants := []*Tenant{}
+	for _, t := range m.tenants {
+		tenants = append(tenants, t)
+	}
+	m.mu.RUnlock()
+
+	failures := []string{}
+	for _, t := range tenants {
+		if err := t.Maintain(); err != nil {
+			failures = append(failures, fmt.Sprintf("tenant %v: %v", t.ID(), err))
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+//Close stops every tenant.
+//
+//Returns the first error encountered.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var first error
+	for id, t := range m.tenants {
+		if err := t.close(); err != nil && first == nil {
+			first = err
+		}
+		delete(m.tenants, id)
+	}
+	m.closed = true
+	return first
+}
@@ -0,0 +1,244 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/keystore"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	m, dir := helperOpen(t)
+	settings := DefaultSettings()
+	settings.Retention = 7
+	created, err := m.Create("north", settings)
+	if err != nil {
+		t.Fatalf("Failed to create the tenant %v", err)
+	}
+	if _, err := m.Create("north", settings); err != ErrExists {
+		t.Errorf("the ID should be taken %v", err)
+	}
+	if _, err := m.Create("No", settings); err != ErrInvalidID {
+		t.Errorf("the ID should be invalid %v", err)
+	}
+	invalid := DefaultSettings()
+	invalid.FPR = 2
+	if _, err := m.Create("south", invalid); err == nil {
+		t.Errorf("the settings should be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "south")); !os.IsNotExist(err) {
+		t.Errorf("a rejected tenant should not leave files %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "north", keyFile))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the key should only be readable by the owner %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "north", keyFile))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	passphrase, _ := helperPassphrase()
+	if _, err := keystore.Decrypt(data, passphrase); err != nil {
+		t.Errorf("the key should be encrypted with the passphrase %v", err)
+	}
+	if got, err := m.Get("north"); err != nil || got != created {
+		t.Errorf("Get failed %v", err)
+	}
+	if _, err := m.Get("south"); err != ErrNotFound {
+		t.Errorf("the tenant should not exist %v", err)
+	}
+
+	//the keys cannot be read without the passphrase.
+	m.Close()
+	if _, err := Open(dir, nil); err == nil {
+		t.Errorf("a manager without passphrase should be rejected")
+	}
+	wrong, err := Open(dir, func() ([]byte, error) { return []byte("wrong"), nil })
+	if err != nil {
+		t.Fatalf("Failed to reopen the tenants %v", err)
+	}
+	if infos := wrong.List(); len(infos) != 1 || infos[0].Err == nil {
+		t.Errorf("the tenant should not start with a wrong passphrase %+v", infos)
+	}
+	wrong.Close()
+
+	//the tenants survive a restart with the same key and settings.
+	m, err = Open(dir, helperPassphrase)
+	if err != nil {
+		t.Fatalf("Failed to reopen the tenants %v", err)
+	}
+	defer m.Close()
+	infos := m.List()
+	if len(infos) != 1 || infos[0].ID != "north" || infos[0].Err != nil {
+		t.Fatalf("unexpected tenants %+v", infos)
+	}
+	if infos[0].KeyID != created.KeyID() || !infos[0].SigningKey.Equal(created.SigningKey()) || infos[0].Settings.Retention != 7 {
+		t.Errorf("the tenant should be restored %+v", infos[0])
+	}
+}
+
+func TestFailedTenant(t *testing.T) {
+	m, dir := helperOpen(t)
+	for _, id := range []string{"north", "south"} {
+		if _, err := m.Create(id, DefaultSettings()); err != nil {
+			t.Fatalf("Failed to create the tenant %v %v", id, err)
+		}
+	}
+	m.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "south", settingsFile), []byte("{"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m, err := Open(dir, helperPassphrase)
+	if err != nil {
+		t.Fatalf("a corrupted tenant should not prevent the others from starting %v", err)
+	}
+	defer m.Close()
+	infos := m.List()
+	if len(infos) != 2 || infos[0].Err != nil || infos[1].Err == nil {
+		t.Fatalf("unexpected tenants %+v", infos)
+	}
+	if _, err := m.Get("south"); err == nil || err == ErrNotFound {
+		t.Errorf("Get should report the failure %v", err)
+	}
+	if _, err := m.Create("south", DefaultSettings()); err != ErrExists {
+		t.Errorf("the ID of a failed tenant should remain taken %v", err)
+	}
+
+	handler := m.Handler()
+	for path, status := range map[string]int{
+		"/t/north/v1/setup": http.StatusOK,
+		"/t/south/v1/setup": http.StatusServiceUnavailable,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Errorf("unexpected status for %v %v", path, rec.Code)
+		}
+	}
+
+	if err := m.Retire("south"); err != nil {
+		t.Errorf("a failed tenant should be retired %v", err)
+	}
+	if len(m.List()) != 1 {
+		t.Errorf("the failed tenant should be gone")
+	}
+}
+
+func TestRetire(t *testing.T) {
+	m, dir := helperOpen(t)
+	old, err := m.Create("north", DefaultSettings())
+	if err != nil {
+		t.Fatalf("Failed to create the tenant %v", err)
+	}
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	report, _ := reporttest.Report(t)
+	if _, err := helperClient(t, srv.URL+"/t/north").SubmitReport(context.Background(), report); err != nil {
+		t.Fatalf("Failed to submit the report %v", err)
+	}
+
+	if err := m.Retire("north"); err != nil {
+		t.Fatalf("Retire failed %v", err)
+	}
+	if err := m.Retire("north"); err != ErrNotFound {
+		t.Errorf("the tenant should be retired %v", err)
+	}
+	if _, err := m.Get("north"); err != ErrNotFound {
+		t.Errorf("the tenant should be gone %v", err)
+	}
+	resp, err := http.Get(srv.URL + "/t/north/v1/setup")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("the retired tenant should not be served %v", resp.StatusCode)
+	}
+
+	archives, err := ioutil.ReadDir(filepath.Join(dir, retiredDir))
+	if err != nil || len(archives) != 1 {
+		t.Fatalf("the tenant should be archived %v", err)
+	}
+	archive := filepath.Join(dir, retiredDir, archives[0].Name())
+	for _, name := range []string{keyFile, signingKeyFile} {
+		if _, err := os.Stat(filepath.Join(archive, name)); !os.IsNotExist(err) {
+			t.Errorf("the key %v should be destroyed %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(archive, storeFile)); err != nil {
+		t.Errorf("the reports should be archived %v", err)
+	}
+
+	renewed, err := m.Create("north", DefaultSettings())
+	if err != nil {
+		t.Fatalf("the ID should be reusable %v", err)
+	}
+	if renewed.KeyID() == old.KeyID() {
+		t.Errorf("the new tenant should have a fresh key")
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	m, _ := helperOpen(t)
+	handler := m.AdminHandler()
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+
+	rec := call(http.MethodPost, "/admin/tenants", `{"id": "north", "settings": {"retention": 7}}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Failed to create the tenant %v %v", rec.Code, rec.Body.String())
+	}
+	created := TenantJSON{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("invalid response %v", err)
+	}
+	if created.ID != "north" || created.KeyID == "" || len(created.SigningKey) != 64 || created.Settings.Retention != 7 || created.Settings.FPR != DefaultSettings().FPR {
+		t.Errorf("unexpected tenant %+v", created)
+	}
+
+	for body, status := range map[string]int{
+		`{"id": "north"}`: http.StatusConflict,
+		`{"id": "No"}`:    http.StatusBadRequest,
+		`{"id": "south", "settings": {"fpr": 2}}`: http.StatusBadRequest,
+		`{`: http.StatusBadRequest,
+	} {
+		if rec := call(http.MethodPost, "/admin/tenants", body); rec.Code != status {
+			t.Errorf("unexpected status for %v %v", body, rec.Code)
+		}
+	}
+	if rec := call(http.MethodPost, "/admin/tenants", `{"id": "south"}`); rec.Code != http.StatusCreated {
+		t.Errorf("the settings should be optional %v", rec.Code)
+	}
+
+	rec = call(http.MethodGet, "/admin/tenants", "")
+	tenants := []TenantJSON{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tenants); err != nil || len(tenants) != 2 || tenants[0].ID != "north" {
+		t.Errorf("unexpected tenants %v %v", tenants, err)
+	}
+	if rec := call(http.MethodGet, "/admin/tenants/south", ""); rec.Code != http.StatusOK {
+		t.Errorf("unexpected status %v", rec.Code)
+	}
+	if rec := call(http.MethodDelete, "/admin/tenants/south", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Failed to retire the tenant %v", rec.Code)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if rec := call(method, "/admin/tenants/south", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("the tenant should be retired %v %v", method, rec.Code)
+		}
+	}
+	if rec := call(http.MethodPut, "/admin/tenants", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status %v", rec.Code)
+	}
+}
@@ -0,0 +1,324 @@
+//Package tenant hosts several independent TCN-PSI datasets, e.g. one per health authority, in a
+//single deployment.
+//
+//Every tenant has its own server key, operator signing key, report store, setup messages,
+//policies and metrics, kept in its own directory. The requests of a tenant are bounded by its own
+//concurrency limit, so the load or the failure of a tenant does not affect the others.
+package tenant
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openmined/tcn-psi/dataset"
+	"github.com/openmined/tcn-psi/keystore"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/server"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	settingsFile   = "tenant.json"
+	keyFile        = "server.key"
+	signingKeyFile = "signing.key"
+	storeFile      = "reports.log"
+	exportDir      = "export"
+)
+
+//DefaultMaxConcurrent is the default number of requests of a tenant processed at once.
+const DefaultMaxConcurrent = 16
+
+//idPattern matches the valid tenant IDs, which are also directory names and URL path segments.
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+//ValidID returns true if id can name a tenant: 1 to 63 lowercase letters, digits or dashes,
+//starting with a letter or a digit.
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+//Settings configures a tenant. They are stored in the directory of the tenant as JSON, whose
+//keys match the flags of tcnpsi-server.
+type Settings struct {
+	FPR          float64 `json:"fpr"`
+	ClientInputs int64   `json:"client-inputs"`
+	Retention    int     `json:"retention"`
+
+	MaxSpan   int     `json:"max-span"`
+	RateLimit float64 `json:"rate-limit"`
+	Burst     int     `json:"burst"`
+
+	MinElements  int `json:"min-elements"`
+	MaxElements  int `json:"max-elements"`
+	ClientBudget int `json:"client-budget"`
+	GlobalBudget int `json:"global-budget"`
+
+	//MaxConcurrent bounds the requests of the tenant processed at once. Further requests are
+	//rejected until one completes.
+	MaxConcurrent int `json:"max-concurrent"`
+	//Export writes the setup messages of the tenant and their signed manifest as static files to
+	//the export directory of the tenant, for a CDN.
+	Export bool `json:"export"`
+}
+
+//DefaultSettings returns the settings of a tenant created without explicit settings.
+func DefaultSettings() Settings {
+	return Settings{
+		FPR:           1e-6,
+		ClientInputs:  1024,
+		Retention:     14,
+		RateLimit:     0.1,
+		Burst:         10,
+		MinElements:   64,
+		MaxConcurrent: DefaultMaxConcurrent,
+	}
+}
+
+//Validate checks the consistency of the settings.
+func (s *Settings) Validate() error {
+	switch {
+	case s.FPR <= 0 || s.FPR >= 1:
+		return errors.New("the false-positive rate must be in (0, 1)")
+	case s.ClientInputs <= 0:
+		return errors.New("the number of client inputs must be positive")
+	case s.Retention <= 0:
+		return errors.New("the retention must be positive")
+	case s.MaxSpan < 0 || s.RateLimit < 0 || s.Burst < 0:
+		return errors.New("the ingestion limits must not be negative")
+	case s.RateLimit > 0 && s.Burst < 1:
+		return errors.New("the burst must be at least 1 when the rate is limited")
+	case s.MinElements < 0 || s.MaxElements < 0 || s.ClientBudget < 0 || s.GlobalBudget < 0:
+		return errors.New("the query limits must not be negative")
+	case s.MaxConcurrent <= 0:
+		return errors.New("the concurrency limit must be positive")
+	}
+	return nil
+}
+
+//Tenant is a dataset served independently of the others.
+//
+//A Tenant is safe for concurrent use by multiple goroutines.
+type Tenant struct {
+	id       string
+	dir      string
+	settings Settings
+
+	data    *dataset.Dataset
+	handler http.Handler
+	//slots holds a token per request being processed.
+	slots chan struct{}
+}
+
+//ID returns the identifier of the tenant.
+func (t *Tenant) ID() string {
+	return t.id
+}
+
+//Settings returns the settings of the tenant.
+func (t *Tenant) Settings() Settings {
+	return t.settings
+}
+
+//KeyID returns the identifier of the server key of the tenant.
+func (t *Tenant) KeyID() message.KeyID {
+	return t.data.KeyID()
+}
+
+//SigningKey returns the public key authenticating the setup messages of the tenant, which its
+//clients pin, or nil if they are not signed.
+func (t *Tenant) SigningKey() ed25519.PublicKey {
+	return t.data.SigningKey()
+}
+
+//Manifest returns the setup messages published by the tenant.
+func (t *Tenant) Manifest() ([]server.ShardInfo, error) {
+	return t.data.Manifest()
+}
+
+//Ready returns nil once the tenant serves requests, and the reason why it does not otherwise.
+func (t *Tenant) Ready() error {
+	return t.data.Ready()
+}
+
+//ServeHTTP serves the REST API of the rest package for the tenant.
+//
+//Requests beyond the concurrency limit of the tenant are rejected with 503, and a panic while
+//serving a request only fails that request.
+func (t *Tenant) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case t.slots <- struct{}{}:
+	default:
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("tenant %v overloaded", t.id))
+		return
+	}
+	defer func() {
+		<-t.slots
+		if err := recover(); err != nil {
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("tenant %v: panic serving %v: %v", t.id, r.URL.Path, err)
+			writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+		}
+	}()
+	t.handler.ServeHTTP(w, r)
+}
+
+//writeSettings stores settings in dir.
+func writeSettings(dir string, settings Settings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, settingsFile), data, 0600)
+}
+
+//readSettings reads the settings stored in dir. The missing keys take their default value.
+func readSettings(dir string) (Settings, error) {
+	settings := DefaultSettings()
+	data, err := ioutil.ReadFile(filepath.Join(dir, settingsFile))
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, err
+	}
+	return settings, settings.Validate()
+}
+
+//createKey creates a server with a fresh key and saves the key in dir, encrypted with the
+//passphrase supplied by passphrase.
+func createKey(dir string, passphrase keystore.Passphrase) (*server.TCNServer, error) {
+	secret, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	defer keystore.Zero(secret)
+	tcnServer, err := server.CreateWithNewKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := keystore.SaveServer(tcnServer, filepath.Join(dir, keyFile), secret); err != nil {
+		tcnServer.Close()
+		return nil, err
+	}
+	return tcnServer, nil
+}
+
+//createSigningKey generates an operator signing key and saves it in dir, hex encoded.
+func createSigningKey(dir string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, hex.EncodedLen(len(key)))
+	defer keystore.Zero(data)
+	hex.Encode(data, key)
+	file, err := os.OpenFile(filepath.Join(dir, signingKeyFile), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return key, err
+}
+
+//loadSigningKey reads the operator signing key saved in dir. Returns a nil key if the tenant
+//has none, e.g. because it was created by a version which did not sign the setup messages.
+func loadSigningKey(dir string) (ed25519.PrivateKey, error) {
+	path := filepath.Join(dir, signingKeyFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err := keystore.CheckPermissions(path); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer keystore.Zero(data)
+	key, err := keystore.DecodeHex(data)
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		keystore.Zero(key)
+		return nil, errors.New("invalid signing key file")
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+//loadKey creates a server from the key saved in dir, decrypted with the passphrase supplied by
+//passphrase.
+func loadKey(dir string, passphrase keystore.Passphrase) (*server.TCNServer, error) {
+	return keystore.LoadServer(&keystore.EncryptedFile{Path: filepath.Join(dir, keyFile), Passphrase: passphrase})
+}
+
+//shredKey overwrites the key file saved in dir with zeros, then deletes it.
+func shredKey(dir, name string) error {
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, make([]byte, info.Size()), 0600); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+//open starts the tenant id stored in dir with tcnServer, signs its setup messages with
+//signingKey if not nil and loads its reports.
+func open(id, dir string, settings Settings, tcnServer *server.TCNServer, signingKey ed25519.PrivateKey) (*Tenant, error) {
+	config := dataset.Config{
+		Store:        filepath.Join(dir, storeFile),
+		FPR:          settings.FPR,
+		ClientInputs: settings.ClientInputs,
+		Retention:    settings.Retention,
+		MaxSpan:      settings.MaxSpan,
+		RateLimit:    settings.RateLimit,
+		Burst:        settings.Burst,
+		MinElements:  settings.MinElements,
+		MaxElements:  settings.MaxElements,
+		ClientBudget: settings.ClientBudget,
+		GlobalBudget: settings.GlobalBudget,
+		SigningKey:   signingKey,
+	}
+	if settings.Export {
+		config.ExportDir = filepath.Join(dir, exportDir)
+	}
+	data, err := dataset.Open(tcnServer, config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := data.Load(); err != nil {
+		data.Close()
+		return nil, err
+	}
+	return &Tenant{
+		id:       id,
+		dir:      dir,
+		settings: settings,
+		data:     data,
+		handler:  data,
+		slots:    make(chan struct{}, settings.MaxConcurrent),
+	}, nil
+}
+
+//Maintain runs the maintenance of the tenant, see dataset.Dataset.Maintain.
+func (t *Tenant) Maintain() error {
+	return t.data.Maintain()
+}
+
+//close releases the store and the server of the tenant.
+func (t *Tenant) close() error {
+	return t.data.Close()
+}
@@ -0,0 +1,211 @@
+package tenant
+
+import (
+	"context"
+	"github.com/openmined/tcn-psi/client"
+	"github.com/openmined/tcn-psi/internal/reporttest"
+	"github.com/openmined/tcn-psi/message"
+	"github.com/openmined/tcn-psi/restclient"
+	"github.com/openmined/tcn-psi/static"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//helperPassphrase supplies the passphrase of the server keys of the tenants.
+func helperPassphrase() ([]byte, error) {
+	return []byte("correct horse battery staple"), nil
+}
+
+func helperOpen(t *testing.T) (*Manager, string) {
+	dir, err := ioutil.TempDir("", "tenant")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	m, err := Open(dir, helperPassphrase)
+	if err != nil {
+		t.Fatalf("Failed to open the tenants %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m, dir
+}
+
+func helperClient(t *testing.T, url string) *restclient.Client {
+	c, err := restclient.NewClient(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create a client %v", err)
+	}
+	c.Retries = 0
+	return c
+}
+
+func TestValidID(t *testing.T) {
+	for _, id := range []string{"a", "health-authority-1", "0x"} {
+		if !ValidID(id) {
+			t.Errorf("%q should be valid", id)
+		}
+	}
+	for _, id := range []string{"", "-a", "Upper", "a/b", "..", ".retired", string(make([]byte, 64))} {
+		if ValidID(id) {
+			t.Errorf("%q should be invalid", id)
+		}
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	settings := DefaultSettings()
+	if err := settings.Validate(); err != nil {
+		t.Errorf("the default settings should be valid %v", err)
+	}
+	for _, change := range []func(s *Settings){
+		func(s *Settings) { s.FPR = 0 },
+		func(s *Settings) { s.Retention = 0 },
+		func(s *Settings) { s.ClientInputs = 0 },
+		func(s *Settings) { s.Burst = -1 },
+		func(s *Settings) { s.Burst = 0 },
+		func(s *Settings) { s.GlobalBudget = -1 },
+		func(s *Settings) { s.MaxConcurrent = 0 },
+	} {
+		settings := DefaultSettings()
+		change(&settings)
+		if err := settings.Validate(); err == nil {
+			t.Errorf("the settings should be invalid %+v", settings)
+		}
+	}
+}
+
+func TestIsolation(t *testing.T) {
+	m, _ := helperOpen(t)
+	settings := DefaultSettings()
+	settings.MinElements = 0
+	for _, id := range []string{"north", "south"} {
+		if _, err := m.Create(id, settings); err != nil {
+			t.Fatalf("Failed to create the tenant %v %v", id, err)
+		}
+	}
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	north := helperClient(t, srv.URL+"/t/north")
+	south := helperClient(t, srv.URL+"/t/south")
+
+	ctx := context.Background()
+	report, tcns := reporttest.Report(t)
+	if _, err := north.SubmitReport(ctx, report); err != nil {
+		t.Fatalf("Failed to submit the report %v", err)
+	}
+	if cnt, err := north.Check(ctx, tcns); err != nil || cnt < int64(len(tcns)) {
+		t.Errorf("the tenant should hold the report %v %v", cnt, err)
+	}
+	if cnt, err := south.Check(ctx, tcns); err != nil || cnt != 0 {
+		t.Errorf("the report should not leak to another tenant %v %v", cnt, err)
+	}
+
+	a, _ := m.Get("north")
+	b, _ := m.Get("south")
+	if a.KeyID() == b.KeyID() || a.SigningKey().Equal(b.SigningKey()) {
+		t.Errorf("the tenants should have their own keys")
+	}
+	//the clients pin the signing key of their tenant.
+	north.Verifier, _ = client.NewSetupVerifier(time.Hour, a.SigningKey())
+	if cnt, err := north.Check(ctx, tcns); err != nil || cnt < int64(len(tcns)) {
+		t.Errorf("the setup messages should be signed by the tenant %v %v", cnt, err)
+	}
+	other, otherTCNs := reporttest.Report(t)
+	if _, err := south.SubmitReport(ctx, other); err != nil {
+		t.Fatalf("Failed to submit the report %v", err)
+	}
+	south.Verifier = north.Verifier
+	if _, err := south.Check(ctx, otherTCNs); err != message.ErrInvalidSignature {
+		t.Errorf("the setup messages of another tenant should be rejected %v", err)
+	}
+	for _, path := range []string{"/t/unknown/v1/setup", "/t/north", "/v1/setup"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("unexpected status for %v %v", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestOverload(t *testing.T) {
+	m, _ := helperOpen(t)
+	settings := DefaultSettings()
+	settings.MaxConcurrent = 1
+	busy, err := m.Create("busy", settings)
+	if err != nil {
+		t.Fatalf("Failed to create the tenant %v", err)
+	}
+	if _, err := m.Create("idle", settings); err != nil {
+		t.Fatalf("Failed to create the tenant %v", err)
+	}
+	handler := m.Handler()
+
+	//occupy the only slot of the busy tenant.
+	busy.slots <- struct{}{}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/t/busy/v1/setup", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("the busy tenant should reject the request %v", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/t/idle/v1/setup", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("the other tenant should not be affected %v", rec.Code)
+	}
+	<-busy.slots
+
+	busy.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/t/busy/v1/setup", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("a panic should fail the request %v", rec.Code)
+	}
+	if len(busy.slots) != 0 {
+		t.Errorf("a panic should release the slot")
+	}
+}
+
+func TestMaintain(t *testing.T) {
+	m, dir := helperOpen(t)
+	tenant, err := m.Create("north", DefaultSettings())
+	if err != nil {
+		t.Fatalf("Failed to create the tenant %v", err)
+	}
+	if err := m.Maintain(); err != nil {
+		t.Errorf("Maintain failed %v", err)
+	}
+	if err := tenant.Ready(); err != nil {
+		t.Errorf("the tenant should be ready %v", err)
+	}
+	if _, err := tenant.Manifest(); err != nil {
+		t.Errorf("Manifest failed %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "north", storeFile)); err != nil {
+		t.Errorf("the reports should be stored in the directory of the tenant %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	m, dir := helperOpen(t)
+	settings := DefaultSettings()
+	settings.Export = true
+	if _, err := m.Create("north", settings); err != nil {
+		t.Fatalf("Failed to create the tenant %v", err)
+	}
+	if err := m.Maintain(); err != nil {
+		t.Errorf("Maintain failed %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "north", exportDir, static.ManifestFile)); err != nil {
+		t.Errorf("the setup messages should be exported in the directory of the tenant %v", err)
+	}
+}